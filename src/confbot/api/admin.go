@@ -0,0 +1,364 @@
+package api
+
+import (
+	"confbot"
+	"crypto/hmac"
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gopkg.in/labstack/echo.v1"
+)
+
+// AdminConfig configures the admin API.
+type AdminConfig struct {
+	// Token is the bearer token admin requests must supply.
+	Token       string
+	MasterToken string
+	Bot         *confbot.Confbot
+	TokenPool   *confbot.TokenPool
+	// Redact hides secrets, such as tokens added to the pool, in the logs.
+	Redact func(secrets ...string)
+	// LogSearchURL links the dashboard to a project's logs. {project} is
+	// replaced with the project ID.
+	LogSearchURL string
+}
+
+// adminActions maps admin project actions to the bot action they run, and
+// the text which triggers it.
+var adminActions = map[string]struct {
+	name string
+	text string
+}{
+	"delete":    {name: "delete", text: "./delete"},
+	"provision": {name: "provision", text: "./provision"},
+	"reset":     {name: "reset", text: "./reset"},
+}
+
+type projectView struct {
+	confbot.Project
+	Hostname  string                    `json:"hostname"`
+	Resources *confbot.ProjectResources `json:"resources,omitempty"`
+}
+
+type addTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// EnableAdmin adds the admin API under /admin/.
+func (a *API) EnableAdmin(cfg AdminConfig) {
+	a.admin = &cfg
+
+	g := a.echo.Group("/admin")
+	g.Use(a.requireAdmin)
+
+	g.Get("/projects", a.adminProjects)
+	g.Get("/projects/:id", a.adminProject)
+	g.Get("/projects/:id/history", a.adminProjectHistory)
+	g.Post("/projects/:id/:action", a.adminProjectAction)
+	g.Get("/roster", a.adminRoster)
+	g.Get("/audit", a.adminAudit)
+	g.Get("/tokens", a.adminTokens)
+	g.Post("/tokens", a.adminAddToken)
+	g.Delete("/tokens/:fingerprint", a.adminRemoveToken)
+	g.Get("/dashboard", a.dashboardStatus)
+
+	// the page itself holds no data. it asks for the admin token, and uses
+	// it to call the admin api.
+	a.echo.Get("/dashboard", a.dashboardPage)
+}
+
+func (a *API) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		auth := c.Request().Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+
+		if a.admin.Token == "" || token == auth || !hmac.Equal([]byte(token), []byte(a.admin.Token)) {
+			a.log.WithField("remote", c.Request().RemoteAddr).Warn("rejected admin request")
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+
+		return next(c)
+	}
+}
+
+func (a *API) adminProjects(c *echo.Context) error {
+	projects, err := confbot.ListProjects(a.repo)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, p := range projects {
+		ids = append(ids, p.ID)
+	}
+
+	resources, err := confbot.FindProjectResources(a.repo, a.admin.MasterToken, ids)
+	if err != nil {
+		a.log.WithError(err).Warn("unable to find project resources")
+	}
+
+	views := []projectView{}
+	for _, p := range projects {
+		views = append(views, projectView{
+			Project:   p,
+			Hostname:  p.Hostname(),
+			Resources: resources[p.ID],
+		})
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+func (a *API) adminProject(c *echo.Context) error {
+	p, err := a.loadProject(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown project"})
+	}
+
+	resources, err := confbot.FindProjectResources(a.repo, a.admin.MasterToken, []string{p.ID})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, projectView{
+		Project:   *p,
+		Hostname:  p.Hostname(),
+		Resources: resources[p.ID],
+	})
+}
+
+// adminProjectHistory returns a project's events. History is kept after a
+// project is deleted, so unknown projects return an empty list.
+func (a *API) adminProjectHistory(c *echo.Context) error {
+	events, err := a.repo.History(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if events == nil {
+		events = []confbot.Event{}
+	}
+
+	if c.Query("format") == "text" {
+		return c.String(http.StatusOK, confbot.FormatHistory(events, len(events)))
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+// adminProjectAction runs a bot action for a project's owner. The owner sees
+// the action's progress in Slack.
+func (a *API) adminProjectAction(c *echo.Context) error {
+	action, ok := adminActions[c.Param("action")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown action"})
+	}
+
+	p, err := a.loadProject(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown project"})
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"action":     action.name,
+		"project-id": p.ID,
+		"user-id":    p.UserID,
+	}).Info("admin started action")
+
+	if err := a.admin.Bot.RunAction(a.requestContext(c), action.name, p.UserID, action.text, "admin-api"); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"project_id": p.ID,
+		"action":     action.name,
+		"status":     "started",
+	})
+}
+
+// adminRoster exports the roster as CSV, or JSON with ?format=json.
+func (a *API) adminRoster(c *echo.Context) error {
+	projects, err := confbot.ListProjects(a.repo)
+	if err != nil {
+		return err
+	}
+
+	names := a.userNames()
+
+	type rosterEntry struct {
+		ProjectID string `json:"project_id"`
+		UserID    string `json:"user_id"`
+		UserName  string `json:"user_name"`
+		Region    string `json:"region"`
+		Status    string `json:"status"`
+		Hostname  string `json:"hostname"`
+	}
+
+	roster := []rosterEntry{}
+	for _, p := range projects {
+		roster = append(roster, rosterEntry{
+			ProjectID: p.ID,
+			UserID:    p.UserID,
+			UserName:  names[p.UserID],
+			Region:    p.Region,
+			Status:    p.Status,
+			Hostname:  p.Hostname(),
+		})
+	}
+
+	if c.Query("format") == "json" {
+		return c.JSON(http.StatusOK, roster)
+	}
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "text/csv")
+	res.Header().Set("Content-Disposition", `attachment; filename="roster.csv"`)
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	w.Write([]string{"project_id", "user_id", "user_name", "region", "status", "hostname"})
+	for _, r := range roster {
+		w.Write([]string{r.ProjectID, r.UserID, r.UserName, r.Region, r.Status, r.Hostname})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// adminAudit exports the audit log as JSON, or CSV with ?format=csv. It can
+// be filtered with since and until (RFC 3339 times, or durations such as
+// 24h), user and action.
+func (a *API) adminAudit(c *echo.Context) error {
+	f := confbot.AuditFilter{
+		UserID: c.Query("user"),
+		Action: c.Query("action"),
+	}
+
+	var err error
+	if f.Since, err = parseAuditTime(c.Query("since")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid since"})
+	}
+	if f.Until, err = parseAuditTime(c.Query("until")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid until"})
+	}
+
+	entries, err := a.repo.AuditEntries(f)
+	if err != nil {
+		return err
+	}
+
+	if c.Query("format") != "csv" {
+		return c.JSON(http.StatusOK, entries)
+	}
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "text/csv")
+	res.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	w.Write([]string{"at", "user_id", "channel_id", "request_id", "text", "action", "args", "run_by", "result", "error", "duration"})
+	for _, e := range entries {
+		w.Write([]string{
+			e.At.UTC().Format(time.RFC3339),
+			e.UserID,
+			e.ChannelID,
+			e.RequestID,
+			e.Text,
+			e.Action,
+			strings.Join(e.Args, " "),
+			e.RunBy,
+			e.Result,
+			e.Error,
+			e.Duration.String(),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// parseAuditTime parses a time, or a duration before now.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+func (a *API) adminTokens(c *echo.Context) error {
+	return c.JSON(http.StatusOK, a.admin.TokenPool.Capacity())
+}
+
+// adminAddToken adds a token to the pool. It is saved so it is still in the
+// pool after a restart.
+func (a *API) adminAddToken(c *echo.Context) error {
+	var r addTokenRequest
+	if err := c.Bind(&r); err != nil || r.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	if a.admin.Redact != nil {
+		a.admin.Redact(r.Token)
+	}
+
+	if err := a.admin.TokenPool.Add(r.Token); err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	if err := a.repo.AddPoolToken(r.Token); err != nil {
+		return err
+	}
+
+	a.log.Info("admin added token")
+
+	return c.JSON(http.StatusCreated, a.admin.TokenPool.Capacity())
+}
+
+// adminRemoveToken removes a token from the pool. Tokens supplied in the
+// environment return after a restart.
+func (a *API) adminRemoveToken(c *echo.Context) error {
+	token, err := a.admin.TokenPool.Remove(c.Param("fingerprint"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	if err := a.repo.RemovePoolToken(token); err != nil {
+		return err
+	}
+
+	a.log.WithField("token-fingerprint", c.Param("fingerprint")).Info("admin removed token")
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// loadProject returns a project. It returns nil if the project doesn't exist.
+func (a *API) loadProject(id string) (*confbot.Project, error) {
+	ids, err := a.repo.ProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, projectID := range ids {
+		if projectID == id {
+			return confbot.LoadProject(a.repo, id)
+		}
+	}
+
+	return nil, nil
+}
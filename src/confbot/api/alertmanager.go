@@ -0,0 +1,77 @@
+package api
+
+import (
+	"confbot"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+// alertmanagerPayload is a Prometheus Alertmanager webhook notification.
+type alertmanagerPayload struct {
+	Status      string            `json:"status"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	ExternalURL string            `json:"externalURL"`
+	Alerts      []struct {
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		StartsAt     time.Time         `json:"startsAt"`
+		GeneratorURL string            `json:"generatorURL"`
+	} `json:"alerts"`
+}
+
+// alertmanager notifies a project's owner about alerts.
+func (a *API) alertmanager(ctx context.Context, ev *confbot.WebhookEvent) error {
+	var p alertmanagerPayload
+	if err := json.Unmarshal(ev.Payload, &p); err != nil {
+		return err
+	}
+
+	if len(p.Alerts) == 0 {
+		return errors.New("alertmanager payload has no alerts")
+	}
+
+	var attachments []slack.Attachment
+	for _, alert := range p.Alerts {
+		name := alert.Labels["alertname"]
+		color := "danger"
+		if alert.Status == "resolved" {
+			color = "good"
+		}
+
+		text := alert.Annotations["description"]
+		if text == "" {
+			text = alert.Annotations["summary"]
+		}
+
+		fields := []slack.AttachmentField{
+			{Title: "Status", Value: alert.Status, Short: true},
+		}
+		if severity, ok := alert.Labels["severity"]; ok {
+			fields = append(fields, slack.AttachmentField{Title: "Severity", Value: severity, Short: true})
+		}
+		if !alert.StartsAt.IsZero() {
+			fields = append(fields, slack.AttachmentField{Title: "Started", Value: alert.StartsAt.Format(time.RFC1123), Short: true})
+		}
+
+		attachments = append(attachments, slack.Attachment{
+			Fallback:  fmt.Sprintf("[%s] %s", alert.Status, name),
+			Title:     name,
+			TitleLink: alert.GeneratorURL,
+			Text:      text,
+			Color:     color,
+			Fields:    fields,
+		})
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.Attachments = attachments
+
+	msg := fmt.Sprintf("Alertmanager: %d alert(s) %s", len(p.Alerts), p.Status)
+	return a.notifyOwner(ev, msg, params)
+}
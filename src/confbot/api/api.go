@@ -2,10 +2,12 @@ package api
 
 import (
 	"confbot"
+	"confbot/metrics"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nlopes/slack"
@@ -20,6 +22,7 @@ type requestWebhook struct {
 	Type      string            `json:"type"`
 	ProjectID string            `json:"project_id"`
 	Options   map[string]string `json:"options"`
+	Payload   json.RawMessage   `json:"payload,omitempty"`
 }
 
 // API is the confbot API.
@@ -29,115 +32,209 @@ type API struct {
 	log         *logrus.Entry
 	slackClient *slack.Client
 	ctx         context.Context
+	queue       *webhookQueue
+	webhooks    *WebhookRegistry
+	health      *readinessChecker
+	echo        *echo.Echo
+	admin       *AdminConfig
+	names       userNameCache
+	masterToken string
 }
 
-// New creates an instance of API.
-func New(ctx context.Context, repo confbot.Repo, s *slack.Client) *API {
-	log := ctx.Value("log").(*logrus.Entry)
+// New creates an instance of API. masterToken is used to find a project's
+// hosts when it is provisioned.
+func New(ctx context.Context, repo confbot.Repo, s *slack.Client, masterToken string) *API {
+	log := confbot.LogFromContext(ctx)
 	a := &API{
 		repo:        repo,
+		masterToken: masterToken,
 		log:         log,
 		slackClient: s,
 		ctx:         ctx,
+		webhooks:    NewWebhookRegistry(),
+		health:      &readinessChecker{checks: map[string]CheckFn{}},
 	}
 
+	a.AddCheck("redis", repo.Ping)
+
+	a.registerBuiltinWebhooks()
+	a.queue = newWebhookQueue(repo, a.processWebhook, log)
+
 	e := echo.New()
 
 	e.Use(NewWithNameAndLogger("api", log))
 	e.Use(mw.Recover())
 
 	e.Post("/webhook", a.webhook)
-	e.Get("/status", a.status)
-
+	e.Get("/status", a.liveness)
+	e.Get("/status/live", a.liveness)
+	e.Get("/status/ready", a.readiness)
+	e.Get("/metrics", metrics.Handler())
+	e.Get("/download/:id", a.downloadConfirm)
+	e.Post("/download/:id", a.download)
+
+	a.echo = e
 	a.Mux = e
 
 	return a
 }
 
-func (a *API) status(c *echo.Context) error {
-	return c.String(http.StatusOK, "OK")
-}
-
 func (a *API) webhook(c *echo.Context) error {
-	r := &requestWebhook{}
-	if err := c.Bind(r); err != nil {
+	ctx := a.requestContext(c)
+	log := confbot.LogFromContext(ctx)
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
 		return err
 	}
 
-	a.log.WithFields(logrus.Fields{
+	r := &requestWebhook{}
+	if err := json.Unmarshal(body, r); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	log.WithFields(logrus.Fields{
 		"project_id": r.ProjectID,
 		"type":       r.Type,
 	}).Info("webhook received")
 
+	header := c.Request().Header
+	signature := header.Get(confbot.WebhookSignatureHeader)
+
+	// the key is only derived from the signed body, so a sender can't
+	// claim another event's key.
+	key := confbot.WebhookIdempotencyKey(r.ProjectID, r.Type, r.Options, r.Payload)
+
+	err = confbot.VerifyWebhook(a.repo, r.ProjectID, header.Get(confbot.WebhookTimestampHeader), signature, body)
+	switch err {
+	case nil:
+	case confbot.ErrWebhookReplayed:
+		// senders retry with the same signature, so a replay of an event
+		// which was accepted is acknowledged rather than rejected.
+		existing, err := a.repo.WebhookEvent(r.ProjectID, key)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil && existing.ProjectID == r.ProjectID {
+			log.WithFields(logrus.Fields{
+				"webhook":    r.Type,
+				"project_id": r.ProjectID,
+				"key":        key,
+				"status":     existing.Status,
+			}).Info("duplicate webhook delivery")
+			return c.JSON(http.StatusOK, webhookResponse{Key: key, Status: existing.Status, Duplicate: true})
+		}
+
+		log.WithError(confbot.ErrWebhookReplayed).
+			WithField("project_id", r.ProjectID).
+			Warn("rejected webhook")
+		return c.NoContent(http.StatusUnauthorized)
+	case confbot.ErrWebhookUnsigned, confbot.ErrWebhookStale, confbot.ErrWebhookSignature:
+		log.WithError(err).
+			WithField("project_id", r.ProjectID).
+			Warn("rejected webhook")
+		return c.NoContent(http.StatusUnauthorized)
+	default:
+		return err
+	}
+
+	h, ok := a.webhooks.Handler(r.Type)
+	if !ok {
+		log.WithField("type", r.Type).Warn("unknown webhook type")
+		return c.String(http.StatusBadRequest, fmt.Sprintf("unknown webhook type %q", r.Type))
+	}
+
+	if err := h.Validate(r.Options, r.Payload); err != nil {
+		log.WithError(err).Warn("invalid webhook")
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
 	userID, err := a.repo.User(r.ProjectID)
 	if err != nil {
-		a.log.WithError(err).
+		log.WithError(err).
 			WithField("project_id", r.ProjectID).
 			Error("unknown project")
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	_, _, channelID, err := a.slackClient.OpenIMChannel(userID)
+	now := time.Now()
+	ev := &confbot.WebhookEvent{
+		Key:        key,
+		Type:       r.Type,
+		ProjectID:  r.ProjectID,
+		UserID:     userID,
+		Options:    r.Options,
+		Payload:    r.Payload,
+		Status:     confbot.WebhookQueued,
+		RequestID:  confbot.RequestIDFromContext(ctx),
+		ReceivedAt: now,
+		UpdatedAt:  now,
+	}
+
+	existing, err := a.repo.CreateWebhookEvent(*ev)
 	if err != nil {
 		return err
 	}
 
-	log := a.log.WithFields(logrus.Fields{
-		"webhook": r.Type,
-		"user-id": userID})
-
-	switch r.Type {
-	case "install_complete":
-		projectID, err := a.repo.ProjectID(userID)
-		if err != nil {
-			return err
-		}
+	log = log.WithFields(logrus.Fields{
+		"webhook":    r.Type,
+		"project_id": r.ProjectID,
+		"key":        key,
+	})
 
-		log.Info("starting provisioner")
+	if existing != nil && existing.ProjectID != r.ProjectID {
+		log.WithField("existing-project-id", existing.ProjectID).Warn("webhook key belongs to another project")
+		return c.NoContent(http.StatusConflict)
+	}
 
-		params := slack.PostMessageParameters{}
-		msg := fmt.Sprintf(
-			"I've booted the shell Droplet for _%s_. Next, I will run the provisioner which will create the full "+
-				"environment. This process will take a few more minutes.", projectID)
-		a.slackClient.PostMessage(channelID, msg, params)
+	if existing != nil {
+		log.WithField("status", existing.Status).Info("duplicate webhook delivery")
+		return c.JSON(http.StatusOK, webhookResponse{Key: key, Status: existing.Status, Duplicate: true})
+	}
 
-		provisioner := confbot.NewProvision(a.ctx, userID, projectID, channelID, a.repo, a.slackClient)
-		provisioner.Run()
-	case "jenkins":
-		log.WithField("raw", fmt.Sprintf("%#v", r)).Info("jenkins received")
+	if err := a.queue.Enqueue(ev); err != nil {
+		log.WithError(err).Error("unable to queue webhook")
+		// forget the event and its signature so the sender can retry it.
+		if rerr := a.repo.RemoveWebhookEvent(r.ProjectID, key); rerr != nil {
+			log.WithError(rerr).Error("unable to remove webhook event")
+		}
+		if rerr := a.repo.UnmarkWebhookSeen(signature); rerr != nil {
+			log.WithError(rerr).Error("unable to forget webhook signature")
+		}
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
 
-		jobName := r.Options["name"]
-		buildNum := r.Options["number"]
-		buildURL := fmt.Sprintf("http://app.%s.%s:8080/job/%s/%s", r.ProjectID, confbot.DropletDomain, jobName, buildNum)
-		buildURLJSON := fmt.Sprintf("%s/api/json", buildURL)
+	log.Info("webhook queued")
 
-		log.WithField("jenkins-info-url", buildURLJSON).Info("fetching job data")
-		res, err := http.Get(buildURLJSON)
-		if err != nil {
-			log.WithError(err).WithField("build-url", buildURLJSON).Error("unable to retrieve build data")
-			return err
-		}
-		defer res.Body.Close()
+	return c.JSON(http.StatusAccepted, webhookResponse{Key: key, Status: ev.Status})
+}
 
-		b, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
-		}
+type webhookResponse struct {
+	Key       string `json:"key"`
+	Status    string `json:"status"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
 
-		var in map[string]interface{}
-		err = json.Unmarshal(b, &in)
-		if err != nil {
-			log.WithError(err).WithField("raw", string(b)).Error("unable to decode build json")
-		}
+func (a *API) installComplete(ctx context.Context, ev *confbot.WebhookEvent) error {
+	userID := ev.UserID
 
-		buildStatus := in["result"].(string)
+	_, _, channelID, err := a.slackClient.OpenIMChannel(userID)
+	if err != nil {
+		return err
+	}
 
-		params := slack.NewPostMessageParameters()
-		params.Username = "jenkins"
-		params.IconURL = "https://s3.pifft.com/oscon2016/jenkins.png"
-		msg := fmt.Sprintf("Build Number: %s - %s - %s", buildNum, buildStatus, buildURL)
-		a.slackClient.PostMessage(channelID, msg, params)
+	projectID, err := a.repo.ProjectID(userID)
+	if err != nil {
+		return err
 	}
 
-	return c.NoContent(http.StatusNoContent)
+	confbot.LogFromContext(ctx).WithFields(logrus.Fields{
+		"webhook": ev.Type,
+		"user-id": userID}).Info("starting provisioner")
+
+	provisioner := confbot.NewProvision(ctx, userID, projectID, channelID, a.masterToken, a.repo, a.slackClient)
+	provisioner.Run()
+
+	return nil
 }
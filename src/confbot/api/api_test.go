@@ -0,0 +1,196 @@
+package api
+
+import (
+	"confbot"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// webhookRepo keeps the webhook data in memory. Other Repo methods panic.
+type webhookRepo struct {
+	confbot.Repo
+
+	mu         sync.Mutex
+	secrets    map[string]string
+	users      map[string]string
+	signatures map[string]bool
+	events     map[string]confbot.WebhookEvent
+}
+
+func newWebhookRepo() *webhookRepo {
+	return &webhookRepo{
+		secrets:    map[string]string{},
+		users:      map[string]string{},
+		signatures: map[string]bool{},
+		events:     map[string]confbot.WebhookEvent{},
+	}
+}
+
+func (r *webhookRepo) Ping() error { return nil }
+
+func (r *webhookRepo) User(projectID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.users[projectID], nil
+}
+
+func (r *webhookRepo) WebhookSecret(projectID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.secrets[projectID], nil
+}
+
+func (r *webhookRepo) MarkWebhookSeen(signature string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signatures[signature] {
+		return false, nil
+	}
+	r.signatures[signature] = true
+	return true, nil
+}
+
+func (r *webhookRepo) UnmarkWebhookSeen(signature string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.signatures, signature)
+	return nil
+}
+
+func (r *webhookRepo) WebhookEvent(projectID, key string) (*confbot.WebhookEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev, ok := r.events[projectID+"/"+key]
+	if !ok {
+		return nil, nil
+	}
+	return &ev, nil
+}
+
+func (r *webhookRepo) CreateWebhookEvent(ev confbot.WebhookEvent) (*confbot.WebhookEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.events[ev.ProjectID+"/"+ev.Key]; ok {
+		return &existing, nil
+	}
+	r.events[ev.ProjectID+"/"+ev.Key] = ev
+	return nil, nil
+}
+
+func (r *webhookRepo) UpdateWebhookEvent(ev confbot.WebhookEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[ev.ProjectID+"/"+ev.Key] = ev
+	return nil
+}
+
+func (r *webhookRepo) UnfinishedWebhookEvents() ([]confbot.WebhookEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []confbot.WebhookEvent
+	for _, ev := range r.events {
+		if ev.Status == confbot.WebhookQueued || ev.Status == confbot.WebhookRunning {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (r *webhookRepo) RemoveWebhookEvent(projectID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.events, projectID+"/"+key)
+	return nil
+}
+
+func TestWebhookRetryAfterUnavailable(t *testing.T) {
+	repo := newWebhookRepo()
+	repo.secrets["project"] = "secret"
+	repo.users["project"] = "user"
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	ctx := confbot.ContextWithLog(context.Background(), logrus.NewEntry(log))
+	a := New(ctx, repo, nil, "")
+
+	body := `{"type":"jenkins","project_id":"project","options":{"name":"app","number":"1"}}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := confbot.SignWebhook("secret", ts, []byte(body))
+
+	send := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/webhook", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(confbot.WebhookTimestampHeader, ts)
+		req.Header.Set(confbot.WebhookSignatureHeader, sig)
+
+		w := httptest.NewRecorder()
+		a.Mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// a queue without room or workers refuses every event.
+	a.queue = &webhookQueue{events: make(chan *confbot.WebhookEvent)}
+	if w := send(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first delivery = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	a.queue = &webhookQueue{events: make(chan *confbot.WebhookEvent, 1)}
+	if w := send(); w.Code != http.StatusAccepted {
+		t.Fatalf("retried delivery = %d %s, want %d", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+
+	w := send()
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"duplicate":true`) {
+		t.Fatalf("repeated delivery = %d %s, want %d duplicate", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	if n := len(a.queue.events); n != 1 {
+		t.Errorf("queued %d events, want 1", n)
+	}
+}
+
+func TestWebhookQueueResume(t *testing.T) {
+	repo := newWebhookRepo()
+	for _, status := range []string{confbot.WebhookQueued, confbot.WebhookRunning, confbot.WebhookDone} {
+		ev := confbot.WebhookEvent{Key: status, ProjectID: "project", Status: status}
+		repo.events[ev.ProjectID+"/"+ev.Key] = ev
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	// the queue only has room for one of the unfinished events.
+	q := &webhookQueue{
+		events: make(chan *confbot.WebhookEvent, 1),
+		repo:   repo,
+		log:    logrus.NewEntry(log),
+	}
+	if err := q.Resume(); err != nil {
+		t.Fatalf("Resume() = %v", err)
+	}
+
+	if n := len(q.events); n != 1 {
+		t.Fatalf("resumed %d events, want 1", n)
+	}
+
+	failed := 0
+	for _, ev := range repo.events {
+		if ev.Status == confbot.WebhookFailed {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d events failed, want 1", failed)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"confbot"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+const (
+	ciGitHub = "github"
+	ciGitLab = "gitlab"
+)
+
+var ciProviders = []string{ciGitHub, ciGitLab}
+
+// ciBuild is a CI notification with the provider specific details removed.
+type ciBuild struct {
+	Repository string
+	Name       string
+	Ref        string
+	Commit     string
+	Status     string
+	URL        string
+}
+
+// gitHubPayload is the part of a GitHub workflow_run or status event
+// that confbot uses.
+type gitHubPayload struct {
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		HeadSHA    string `json:"head_sha"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+	State      string `json:"state"`
+	Context    string `json:"context"`
+	SHA        string `json:"sha"`
+	TargetURL  string `json:"target_url"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// gitLabPayload is the part of a GitLab pipeline event that confbot uses.
+type gitLabPayload struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		ID     int    `json:"id"`
+		Ref    string `json:"ref"`
+		SHA    string `json:"sha"`
+		Status string `json:"status"`
+	} `json:"object_attributes"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+func parseCIBuild(provider string, payload []byte) (*ciBuild, error) {
+	switch provider {
+	case ciGitHub:
+		var p gitHubPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, err
+		}
+
+		b := &ciBuild{Repository: p.Repository.FullName}
+		if wr := p.WorkflowRun; wr != nil {
+			b.Name = wr.Name
+			b.Ref = wr.HeadBranch
+			b.Commit = wr.HeadSHA
+			b.Status = wr.Conclusion
+			if b.Status == "" {
+				b.Status = wr.Status
+			}
+			b.URL = wr.HTMLURL
+		} else {
+			b.Name = p.Context
+			b.Commit = p.SHA
+			b.Status = p.State
+			b.URL = p.TargetURL
+		}
+
+		if b.Status == "" {
+			return nil, errors.New("github payload has no build status")
+		}
+
+		return b, nil
+	case ciGitLab:
+		var p gitLabPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, err
+		}
+
+		if p.ObjectKind != "pipeline" {
+			return nil, fmt.Errorf("unsupported gitlab event %q", p.ObjectKind)
+		}
+
+		attrs := p.ObjectAttributes
+		return &ciBuild{
+			Repository: p.Project.PathWithNamespace,
+			Name:       fmt.Sprintf("pipeline #%d", attrs.ID),
+			Ref:        attrs.Ref,
+			Commit:     attrs.SHA,
+			Status:     attrs.Status,
+			URL:        fmt.Sprintf("%s/pipelines/%d", p.Project.WebURL, attrs.ID),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown ci provider %q", provider)
+	}
+}
+
+func ciColor(status string) string {
+	switch status {
+	case "success":
+		return "good"
+	case "failure", "failed", "error", "timed_out", "cancelled", "canceled":
+		return "danger"
+	default:
+		return "warning"
+	}
+}
+
+// ci notifies a project's owner about a CI build.
+func (a *API) ci(ctx context.Context, ev *confbot.WebhookEvent) error {
+	b, err := parseCIBuild(ev.Options["provider"], ev.Payload)
+	if err != nil {
+		return err
+	}
+
+	commit := b.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+
+	fields := []slack.AttachmentField{
+		{Title: "Status", Value: b.Status, Short: true},
+	}
+	if b.Ref != "" {
+		fields = append(fields, slack.AttachmentField{Title: "Ref", Value: b.Ref, Short: true})
+	}
+	if commit != "" {
+		fields = append(fields, slack.AttachmentField{Title: "Commit", Value: commit, Short: true})
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.Attachments = []slack.Attachment{
+		{
+			Fallback:  fmt.Sprintf("%s %s: %s", b.Repository, b.Name, b.Status),
+			Title:     fmt.Sprintf("%s %s", b.Repository, b.Name),
+			TitleLink: b.URL,
+			Color:     ciColor(b.Status),
+			Fields:    fields,
+		},
+	}
+
+	return a.notifyOwner(ev, "", params)
+}
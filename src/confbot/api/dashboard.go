@@ -0,0 +1,294 @@
+package api
+
+import (
+	"confbot"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/labstack/echo.v1"
+)
+
+var (
+	// userNameCacheTTL is how long Slack user names are cached for the dashboard.
+	userNameCacheTTL = 5 * time.Minute
+)
+
+// userNameCache caches Slack user names, so the dashboard doesn't list every
+// user each time it refreshes.
+type userNameCache struct {
+	mu        sync.Mutex
+	names     map[string]string
+	fetchedAt time.Time
+}
+
+type dashboardProject struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Hostname string `json:"hostname"`
+	Region   string `json:"region"`
+	Status   string `json:"status"`
+	Step     string `json:"step"`
+	Error    string `json:"error,omitempty"`
+	LogURL   string `json:"log_url,omitempty"`
+	// InState is how long the project has been in its current state.
+	InState string `json:"in_state"`
+	// Durations is the time spent in each state.
+	Durations []dashboardDuration `json:"durations"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+type dashboardDuration struct {
+	State    string `json:"state"`
+	Duration string `json:"duration"`
+}
+
+type dashboardStatus struct {
+	Projects []dashboardProject `json:"projects"`
+	Counts   map[string]int     `json:"counts"`
+	At       time.Time          `json:"at"`
+}
+
+func (a *API) dashboardPage(c *echo.Context) error {
+	return c.HTML(http.StatusOK, dashboardHTML)
+}
+
+func (a *API) dashboardStatus(c *echo.Context) error {
+	projects, err := confbot.ListProjects(a.repo)
+	if err != nil {
+		return err
+	}
+
+	names := a.userNames()
+	now := time.Now()
+
+	out := dashboardStatus{
+		Projects: []dashboardProject{},
+		Counts:   map[string]int{},
+		At:       now,
+	}
+
+	for _, p := range projects {
+		dp := dashboardProject{
+			ID:        p.ID,
+			UserID:    p.UserID,
+			UserName:  names[p.UserID],
+			Hostname:  p.Hostname(),
+			Region:    p.Region,
+			Status:    p.Status,
+			Step:      p.Step,
+			Error:     p.Error,
+			Durations: stateDurations(p.Transitions, now),
+			UpdatedAt: p.UpdatedAt,
+		}
+
+		if n := len(p.Transitions); n > 0 {
+			dp.InState = now.Sub(p.Transitions[n-1].At).Round(time.Second).String()
+		}
+
+		if p.Error != "" && a.admin.LogSearchURL != "" {
+			dp.LogURL = strings.Replace(a.admin.LogSearchURL, "{project}", url.QueryEscape(p.ID), -1)
+		}
+
+		out.Counts[p.Status]++
+		out.Projects = append(out.Projects, dp)
+	}
+
+	sort.Sort(byStatus(out.Projects))
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// stateDurations returns the time spent in each state, in the order the
+// states were entered. The last state is measured until now.
+func stateDurations(transitions []confbot.ProjectTransition, now time.Time) []dashboardDuration {
+	var out []dashboardDuration
+	for i, t := range transitions {
+		end := now
+		if i+1 < len(transitions) {
+			end = transitions[i+1].At
+		}
+
+		state := t.Status
+		if t.Step != "" {
+			state = fmt.Sprintf("%s:%s", t.Status, t.Step)
+		}
+
+		out = append(out, dashboardDuration{
+			State:    state,
+			Duration: end.Sub(t.At).Round(time.Second).String(),
+		})
+	}
+
+	return out
+}
+
+func (a *API) userNames() map[string]string {
+	a.names.mu.Lock()
+	defer a.names.mu.Unlock()
+
+	if a.names.names != nil && time.Since(a.names.fetchedAt) < userNameCacheTTL {
+		return a.names.names
+	}
+
+	users, err := a.slackClient.GetUsers()
+	if err != nil {
+		a.log.WithError(err).Warn("unable to retrieve slack users")
+		if a.names.names == nil {
+			return map[string]string{}
+		}
+		return a.names.names
+	}
+
+	names := map[string]string{}
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+
+	a.names.names = names
+	a.names.fetchedAt = time.Now()
+
+	return names
+}
+
+// statusOrder puts projects which need attention first.
+var statusOrder = map[string]int{
+	confbot.ProjectFailed:       0,
+	confbot.ProjectProvisioning: 1,
+	confbot.ProjectInstalling:   2,
+	confbot.ProjectBooting:      3,
+	confbot.ProjectDeleting:     4,
+	confbot.ProjectUnknown:      5,
+	confbot.ProjectReady:        6,
+}
+
+type byStatus []dashboardProject
+
+func (s byStatus) Len() int      { return len(s) }
+func (s byStatus) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byStatus) Less(i, j int) bool {
+	if statusOrder[s[i].Status] != statusOrder[s[j].Status] {
+		return statusOrder[s[i].Status] < statusOrder[s[j].Status]
+	}
+	return s[i].ID < s[j].ID
+}
+
+// dashboardHTML is the instructor dashboard. It asks for the admin token,
+// and polls the admin API for status.
+var dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>confbot workshop</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; font-size: 14px; }
+.failed { background: #fdd; }
+.ready { background: #dfd; }
+.provisioning, .installing, .booting { background: #ffd; }
+.durations { color: #666; font-size: 12px; }
+#counts span { margin-right: 1em; }
+#error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Workshop status</h1>
+<div id="counts"></div>
+<div id="error"></div>
+<table>
+<thead><tr><th>Project</th><th>Attendee</th><th>Region</th><th>Status</th><th>In state</th><th>Time per state</th><th>Error</th><th></th></tr></thead>
+<tbody id="projects"></tbody>
+</table>
+<script>
+var token = sessionStorage.getItem("confbot-admin-token");
+if (!token) {
+  token = prompt("Admin token");
+  sessionStorage.setItem("confbot-admin-token", token);
+}
+
+function text(s) {
+  var d = document.createElement("div");
+  d.textContent = s || "";
+  return d.innerHTML;
+}
+
+// attr escapes s for a double quoted attribute value.
+function attr(s) {
+  return text(s).replace(/"/g, "&quot;");
+}
+
+function request(method, path) {
+  return fetch(path, {method: method, headers: {"Authorization": "Bearer " + token}}).then(function(res) {
+    if (res.status === 401) {
+      sessionStorage.removeItem("confbot-admin-token");
+      throw new Error("unauthorized: reload to enter the admin token again");
+    }
+    if (!res.ok) {
+      throw new Error(method + " " + path + ": " + res.status);
+    }
+    return res.json();
+  });
+}
+
+function action(id, name) {
+  if (name === "delete" && !confirm("Delete project " + id + "?")) {
+    return;
+  }
+  request("POST", "/admin/projects/" + encodeURIComponent(id) + "/" + name).then(refresh, showError);
+}
+
+function showError(err) {
+  document.getElementById("error").textContent = err.message;
+}
+
+function render(status) {
+  document.getElementById("error").textContent = "";
+  document.getElementById("counts").innerHTML = Object.keys(status.counts).map(function(k) {
+    return "<span>" + text(k) + ": " + status.counts[k] + "</span>";
+  }).join("");
+
+  document.getElementById("projects").innerHTML = status.projects.map(function(p) {
+    var durations = (p.durations || []).map(function(d) { return text(d.state) + " " + text(d.duration); }).join(", ");
+    var error = text(p.error);
+    if (p.log_url) {
+      error += ' <a href="' + attr(p.log_url) + '" target="_blank">logs</a>';
+    }
+    var state = text(p.status) + (p.step ? " (" + text(p.step) + ")" : "");
+    return '<tr class="' + attr(p.status) + '">' +
+      "<td>" + text(p.id) + "<br><small>" + text(p.hostname) + "</small></td>" +
+      "<td>" + text(p.user_name || p.user_id) + "</td>" +
+      "<td>" + text(p.region) + "</td>" +
+      "<td>" + state + "</td>" +
+      "<td>" + text(p.in_state) + "</td>" +
+      '<td class="durations">' + durations + "</td>" +
+      "<td>" + error + "</td>" +
+      '<td><button data-action="provision" data-id="' + attr(p.id) + '">Retry</button> ' +
+      '<button data-action="delete" data-id="' + attr(p.id) + '">Delete</button></td>' +
+      "</tr>";
+  }).join("");
+}
+
+function refresh() {
+  request("GET", "/admin/dashboard").then(render, showError);
+}
+
+// the buttons are replaced on every refresh, so clicks are handled on the table.
+document.getElementById("projects").addEventListener("click", function(e) {
+  var id = e.target.getAttribute("data-id");
+  var name = e.target.getAttribute("data-action");
+  if (id && name) {
+    action(id, name);
+  }
+});
+
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>`
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"confbot"
+	"mime"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+
+	"gopkg.in/labstack/echo.v1"
+)
+
+// downloadConfirm is shown before a download is taken. Link previews and
+// scanners only issue GET requests, so they can't use up a download.
+var downloadConfirm = `<!DOCTYPE html>
+<html>
+<head><title>Download</title></head>
+<body>
+<form method="POST">
+<p>This link can only be used once.</p>
+<button type="submit">Download</button>
+</form>
+</body>
+</html>`
+
+func (a *API) downloadConfirm(c *echo.Context) error {
+	return c.HTML(http.StatusOK, downloadConfirm)
+}
+
+func (a *API) download(c *echo.Context) error {
+	id := c.Param("id")
+	req := c.Request()
+
+	log := a.log.WithFields(logrus.Fields{
+		"download-id": id,
+		"remote":      req.RemoteAddr,
+	})
+
+	d, err := confbot.TakeDownload(a.repo, id, c.Query("expires"), c.Query("sig"), req.RemoteAddr, req.UserAgent())
+	if err != nil {
+		if err == confbot.ErrDownloadInvalid {
+			log.Warn("download rejected")
+			return c.String(http.StatusGone, err.Error())
+		}
+		log.WithError(err).Error("unable to take download")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"user-id":    d.UserID,
+		"project-id": d.ProjectID,
+		"filename":   d.Filename,
+	}).Info("credential downloaded")
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.Filename}))
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(d.Content)
+
+	return err
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/labstack/echo.v1"
+)
+
+const (
+	healthOK   = "OK"
+	healthFail = "FAIL"
+)
+
+var (
+	// checkTimeout is how long a readiness check can take before it fails.
+	checkTimeout = 5 * time.Second
+
+	// readinessCacheTTL is how long readiness results are reused, so
+	// frequent load balancer checks don't use up API rate limits.
+	readinessCacheTTL = 10 * time.Second
+
+	errCheckTimeout = errors.New("check timed out")
+)
+
+// CheckFn is a readiness check. It returns an error if the dependency it
+// checks is down.
+type CheckFn func() error
+
+// CheckSetFn returns readiness checks by name. It is called for every
+// check, so it can follow dependencies which change while confbot runs.
+type CheckSetFn func() map[string]CheckFn
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Took   string `json:"took"`
+}
+
+type queueStatus struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+	Running  int `json:"running"`
+}
+
+type readiness struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]checkResult `json:"checks"`
+	Queue     queueStatus            `json:"queue"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+type readinessChecker struct {
+	mu     sync.Mutex
+	checks map[string]CheckFn
+	sets   []CheckSetFn
+	last   *readiness
+}
+
+// AddCheck adds a readiness check.
+func (a *API) AddCheck(name string, fn CheckFn) {
+	a.health.mu.Lock()
+	defer a.health.mu.Unlock()
+
+	a.health.checks[name] = fn
+}
+
+// AddCheckSet adds readiness checks which are listed when readiness is
+// checked.
+func (a *API) AddCheckSet(fn CheckSetFn) {
+	a.health.mu.Lock()
+	defer a.health.mu.Unlock()
+
+	a.health.sets = append(a.health.sets, fn)
+}
+
+func (a *API) liveness(c *echo.Context) error {
+	return c.String(http.StatusOK, healthOK)
+}
+
+func (a *API) readiness(c *echo.Context) error {
+	r := a.checkReadiness()
+
+	status := http.StatusOK
+	if r.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(status, r)
+}
+
+func (a *API) checkReadiness() *readiness {
+	a.health.mu.Lock()
+	defer a.health.mu.Unlock()
+
+	if last := a.health.last; last != nil && time.Since(last.CheckedAt) < readinessCacheTTL {
+		return last
+	}
+
+	checks := map[string]CheckFn{}
+	for name, fn := range a.health.checks {
+		checks[name] = fn
+	}
+	for _, set := range a.health.sets {
+		for name, fn := range set() {
+			checks[name] = fn
+		}
+	}
+
+	checks["webhook-queue"] = func() error {
+		if a.queue.Depth() >= a.queue.Capacity() {
+			return errQueueFull
+		}
+		return nil
+	}
+
+	r := &readiness{
+		Status: healthOK,
+		Checks: runChecks(checks),
+		Queue: queueStatus{
+			Depth:    a.queue.Depth(),
+			Capacity: a.queue.Capacity(),
+			Running:  a.queue.Running(),
+		},
+		CheckedAt: time.Now(),
+	}
+
+	var failed []string
+	for name, cr := range r.Checks {
+		if cr.Status != healthOK {
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		r.Status = healthFail
+		a.log.WithField("failed-checks", failed).Warn("not ready")
+	}
+
+	a.health.last = r
+
+	return r
+}
+
+// runChecks runs checks concurrently. A check which doesn't finish within
+// checkTimeout fails.
+func runChecks(checks map[string]CheckFn) map[string]checkResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]checkResult{}
+
+	for name, fn := range checks {
+		wg.Add(1)
+		go func(name string, fn CheckFn) {
+			defer wg.Done()
+
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- fn() }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(checkTimeout):
+				err = errCheckTimeout
+			}
+
+			cr := checkResult{
+				Status: healthOK,
+				Took:   time.Since(start).String(),
+			}
+			if err != nil {
+				cr.Status = healthFail
+				cr.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[name] = cr
+			mu.Unlock()
+		}(name, fn)
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"confbot"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+const (
+	jenkinsConsoleLines = 20
+)
+
+// jenkins notifies a project's owner when a Jenkins build finishes.
+func (a *API) jenkins(ctx context.Context, ev *confbot.WebhookEvent) error {
+	jobName := ev.Options["name"]
+	buildNum, err := strconv.Atoi(ev.Options["number"])
+	if err != nil {
+		return err
+	}
+
+	log := confbot.LogFromContext(ctx).WithFields(logrus.Fields{
+		"webhook": ev.Type,
+		"user-id": ev.UserID,
+		"job":     jobName,
+		"build":   buildNum,
+	})
+
+	svc, ok := confbot.CurrentWorkshop.Service("jenkins")
+	if !ok {
+		return errors.New("the workshop doesn't have a jenkins service")
+	}
+	jc := confbot.NewJenkinsClient(svc.URL(ev.ProjectID))
+
+	log.Info("waiting for jenkins build")
+	build, err := jc.WaitForBuild(jobName, buildNum)
+	if err != nil {
+		log.WithError(err).Error("unable to retrieve build data")
+		return err
+	}
+
+	fields := []slack.AttachmentField{
+		{Title: "Result", Value: build.Result, Short: true},
+		{Title: "Duration", Value: build.BuildDuration().String(), Short: true},
+	}
+
+	if sha, branch := build.Commit(); sha != "" {
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		commit := sha
+		if branch != "" {
+			commit = fmt.Sprintf("%s (%s)", sha, branch)
+		}
+		fields = append(fields, slack.AttachmentField{Title: "Commit", Value: commit, Short: true})
+	}
+
+	tr, err := jc.TestReport(jobName, buildNum)
+	if err != nil {
+		log.WithError(err).Warn("unable to retrieve test report")
+	}
+	if tr != nil {
+		fields = append(fields, slack.AttachmentField{
+			Title: "Tests",
+			Value: fmt.Sprintf("%d passed, %d failed, %d skipped", tr.PassCount, tr.FailCount, tr.SkipCount),
+			Short: true,
+		})
+	}
+
+	attachment := slack.Attachment{
+		Fallback:  fmt.Sprintf("Build Number: %d - %s - %s", buildNum, build.Result, jc.BuildURL(jobName, buildNum)),
+		Title:     fmt.Sprintf("%s #%d", jobName, buildNum),
+		TitleLink: jc.BuildURL(jobName, buildNum),
+		Color:     jenkinsColor(build.Result),
+		Fields:    fields,
+	}
+
+	if build.Result != "SUCCESS" {
+		console, err := jc.ConsoleTail(jobName, buildNum, jenkinsConsoleLines)
+		if err != nil {
+			log.WithError(err).Warn("unable to retrieve console log")
+		} else {
+			attachment.Text = fmt.Sprintf("```%s```", console)
+			attachment.MarkdownIn = []string{"text"}
+		}
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.Username = "jenkins"
+	params.IconURL = confbot.CurrentWorkshop.JenkinsIconURL
+	params.Attachments = []slack.Attachment{attachment}
+
+	return a.notifyOwner(ev, "", params)
+}
+
+func jenkinsColor(result string) string {
+	switch result {
+	case "SUCCESS":
+		return "good"
+	case "UNSTABLE":
+		return "warning"
+	default:
+		return "danger"
+	}
+}
@@ -1,15 +1,34 @@
 package api
 
 import (
+	"confbot"
 	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
 	"gopkg.in/labstack/echo.v1"
 
 	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
 )
 
+// requestIDKey is the key for the request ID in an echo context.
+const requestIDKey = "request-id"
+
+// validRequestID matches request IDs which are accepted from clients.
+var validRequestID = regexp.MustCompile(`^[\w.:-]{1,128}$`)
+
+// requestContext returns a context which carries the request's ID.
+func (a *API) requestContext(c *echo.Context) context.Context {
+	id, _ := c.Get(requestIDKey).(string)
+	if id == "" {
+		id = confbot.NewRequestID()
+	}
+
+	return confbot.ContextWithRequestID(a.ctx, id)
+}
+
 // NewWithNameAndLogger returns a new middleware handler with the specified name
 // and logger
 func NewWithNameAndLogger(name string, l *logrus.Entry) echo.MiddlewareFunc {
@@ -18,14 +37,20 @@ func NewWithNameAndLogger(name string, l *logrus.Entry) echo.MiddlewareFunc {
 			start := time.Now()
 
 			entry := l.WithFields(logrus.Fields{
-				"request": c.Request().RequestURI,
+				// the query isn't logged, it can hold secrets such as
+				// download signatures.
+				"request": c.Request().URL.Path,
 				"method":  c.Request().Method,
 				"remote":  c.Request().RemoteAddr,
 			})
 
-			if reqID := c.Request().Header.Get("X-Request-Id"); reqID != "" {
-				entry = entry.WithField("request_id", reqID)
+			reqID := c.Request().Header.Get(confbot.RequestIDHeader)
+			if !validRequestID.MatchString(reqID) {
+				reqID = confbot.NewRequestID()
 			}
+			c.Set(requestIDKey, reqID)
+			c.Response().Header().Set(confbot.RequestIDHeader, reqID)
+			entry = entry.WithField("request-id", reqID)
 
 			entry.Info("started handling request")
 
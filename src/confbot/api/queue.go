@@ -0,0 +1,154 @@
+package api
+
+import (
+	"confbot"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var (
+	// WebhookQueueSize is the amount of events which can wait for a worker.
+	// Deliveries beyond it are refused with a 503, and droplets give up
+	// retrying install_complete after about 30 seconds.
+	WebhookQueueSize = 200
+
+	// WebhookWorkers is the amount of events processed at the same time. An
+	// install_complete event holds its worker for the whole provision, so
+	// there should be a worker for each attendee booting at the same time.
+	WebhookWorkers = 25
+
+	errQueueFull = errors.New("webhook queue is full")
+)
+
+// webhookQueue runs webhook events in the background.
+type webhookQueue struct {
+	events  chan *confbot.WebhookEvent
+	repo    confbot.Repo
+	handler func(*confbot.WebhookEvent) error
+	log     *logrus.Entry
+	running int32
+}
+
+func newWebhookQueue(repo confbot.Repo, handler func(*confbot.WebhookEvent) error, log *logrus.Entry) *webhookQueue {
+	q := &webhookQueue{
+		events:  make(chan *confbot.WebhookEvent, WebhookQueueSize),
+		repo:    repo,
+		handler: handler,
+		log:     log.WithField("component", "webhook-queue"),
+	}
+
+	for i := 0; i < WebhookWorkers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue adds an event to the queue. It does not block if the queue is full.
+func (q *webhookQueue) Enqueue(ev *confbot.WebhookEvent) error {
+	select {
+	case q.events <- ev:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Resume queues the events which were queued or running when the bot last
+// stopped. Events which don't fit in the queue are marked as failed.
+func (q *webhookQueue) Resume() error {
+	events, err := q.repo.UnfinishedWebhookEvents()
+	if err != nil {
+		return err
+	}
+
+	for i := range events {
+		ev := &events[i]
+		log := q.log.WithFields(logrus.Fields{
+			"webhook":    ev.Type,
+			"project_id": ev.ProjectID,
+			"key":        ev.Key,
+			"status":     ev.Status,
+		})
+
+		if err := q.Enqueue(ev); err != nil {
+			log.WithError(err).Error("unable to resume webhook")
+			q.setStatus(ev, confbot.WebhookFailed, err)
+			continue
+		}
+
+		log.Info("resumed webhook")
+	}
+
+	return nil
+}
+
+// Depth is the amount of events waiting to be processed.
+func (q *webhookQueue) Depth() int {
+	return len(q.events)
+}
+
+// Capacity is the amount of events which can wait to be processed.
+func (q *webhookQueue) Capacity() int {
+	return cap(q.events)
+}
+
+// Running is the amount of events being processed.
+func (q *webhookQueue) Running() int {
+	return int(atomic.LoadInt32(&q.running))
+}
+
+func (q *webhookQueue) work() {
+	for ev := range q.events {
+		q.process(ev)
+	}
+}
+
+func (q *webhookQueue) process(ev *confbot.WebhookEvent) {
+	log := q.log.WithFields(logrus.Fields{
+		"webhook":    ev.Type,
+		"project_id": ev.ProjectID,
+		"key":        ev.Key,
+		"request-id": ev.RequestID,
+	})
+
+	atomic.AddInt32(&q.running, 1)
+	defer atomic.AddInt32(&q.running, -1)
+
+	q.setStatus(ev, confbot.WebhookRunning, nil)
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("webhook handler panicked: %v", r)
+			}
+		}()
+
+		return q.handler(ev)
+	}()
+
+	if err != nil {
+		log.WithError(err).Error("webhook failed")
+		q.setStatus(ev, confbot.WebhookFailed, err)
+		return
+	}
+
+	log.Info("webhook processed")
+	q.setStatus(ev, confbot.WebhookDone, nil)
+}
+
+func (q *webhookQueue) setStatus(ev *confbot.WebhookEvent, status string, err error) {
+	ev.Status = status
+	ev.UpdatedAt = time.Now()
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	if err := q.repo.UpdateWebhookEvent(*ev); err != nil {
+		q.log.WithError(err).WithField("key", ev.Key).Error("unable to update webhook event")
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"confbot"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+// WebhookHandlerFn processes an accepted webhook event. ctx carries the
+// webhook's request ID.
+type WebhookHandlerFn func(ctx context.Context, ev *confbot.WebhookEvent) error
+
+// WebhookOption describes an option a webhook accepts.
+type WebhookOption struct {
+	Name     string
+	Required bool
+	// Pattern, if set, must match the option's value.
+	Pattern *regexp.Regexp
+}
+
+// WebhookHandler handles a type of webhook.
+type WebhookHandler struct {
+	Type    string
+	Options []WebhookOption
+	// Payload is true if the webhook requires a payload.
+	Payload bool
+	Handle  WebhookHandlerFn
+}
+
+// WebhookValidationErr is returned when a webhook doesn't match its handler's schema.
+type WebhookValidationErr struct {
+	Type   string
+	Reason string
+}
+
+var _ error = (*WebhookValidationErr)(nil)
+
+func (e *WebhookValidationErr) Error() string {
+	return fmt.Sprintf("invalid %s webhook: %s", e.Type, e.Reason)
+}
+
+// Validate checks a webhook's options and payload against the handler's schema.
+func (h *WebhookHandler) Validate(options map[string]string, payload []byte) error {
+	known := map[string]WebhookOption{}
+	for _, o := range h.Options {
+		known[o.Name] = o
+
+		v, ok := options[o.Name]
+		if !ok || v == "" {
+			if o.Required {
+				return &WebhookValidationErr{Type: h.Type, Reason: fmt.Sprintf("option %q is required", o.Name)}
+			}
+			continue
+		}
+
+		if o.Pattern != nil && !o.Pattern.MatchString(v) {
+			return &WebhookValidationErr{Type: h.Type, Reason: fmt.Sprintf("option %q is invalid", o.Name)}
+		}
+	}
+
+	for name := range options {
+		if _, ok := known[name]; !ok {
+			return &WebhookValidationErr{Type: h.Type, Reason: fmt.Sprintf("unknown option %q", name)}
+		}
+	}
+
+	if h.Payload && len(payload) == 0 {
+		return &WebhookValidationErr{Type: h.Type, Reason: "payload is required"}
+	}
+
+	return nil
+}
+
+// WebhookRegistry holds webhook handlers by type.
+type WebhookRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]*WebhookHandler
+}
+
+// NewWebhookRegistry creates an instance of WebhookRegistry.
+func NewWebhookRegistry() *WebhookRegistry {
+	return &WebhookRegistry{
+		handlers: map[string]*WebhookHandler{},
+	}
+}
+
+// Register adds a handler. It replaces any handler registered for the same type.
+func (wr *WebhookRegistry) Register(h *WebhookHandler) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.handlers[h.Type] = h
+}
+
+// Handler returns the handler for a type.
+func (wr *WebhookRegistry) Handler(t string) (*WebhookHandler, bool) {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	h, ok := wr.handlers[t]
+	return h, ok
+}
+
+// Types returns the registered webhook types.
+func (wr *WebhookRegistry) Types() []string {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	var types []string
+	for t := range wr.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// RegisterWebhook adds a webhook handler to the API.
+func (a *API) RegisterWebhook(h *WebhookHandler) {
+	a.webhooks.Register(h)
+}
+
+// ResumeWebhooks queues the webhooks which were queued or running when the
+// bot last stopped. Call it once all webhook handlers have been registered.
+func (a *API) ResumeWebhooks() error {
+	return a.queue.Resume()
+}
+
+// processWebhook runs an accepted webhook in the background.
+func (a *API) processWebhook(ev *confbot.WebhookEvent) error {
+	h, ok := a.webhooks.Handler(ev.Type)
+	if !ok {
+		return fmt.Errorf("no handler for %s webhook", ev.Type)
+	}
+
+	ctx := a.ctx
+	if ev.RequestID != "" {
+		ctx = confbot.ContextWithRequestID(ctx, ev.RequestID)
+	}
+	if ev.UserID != "" {
+		ctx = confbot.ContextWithUserLocale(ctx, a.repo, ev.UserID)
+	}
+
+	confbot.EventBusFromContext(ctx).Publish(confbot.Event{
+		Type:      confbot.EventWebhookReceived,
+		ProjectID: ev.ProjectID,
+		UserID:    ev.UserID,
+		Data:      map[string]string{"type": ev.Type, "key": ev.Key},
+	})
+
+	return h.Handle(ctx, ev)
+}
+
+// notifyOwner sends a message to the user who owns the webhook's project.
+func (a *API) notifyOwner(ev *confbot.WebhookEvent, msg string, params slack.PostMessageParameters) error {
+	_, _, channelID, err := a.slackClient.OpenIMChannel(ev.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = a.slackClient.PostMessage(channelID, msg, params)
+	return err
+}
+
+func (a *API) registerBuiltinWebhooks() {
+	a.RegisterWebhook(&WebhookHandler{
+		Type:   "install_complete",
+		Handle: a.installComplete,
+	})
+
+	a.RegisterWebhook(&WebhookHandler{
+		Type: "jenkins",
+		Options: []WebhookOption{
+			{Name: "name", Required: true, Pattern: regexp.MustCompile(`^[\w.-]+$`)},
+			{Name: "number", Required: true, Pattern: regexp.MustCompile(`^\d+$`)},
+		},
+		Handle: a.jenkins,
+	})
+
+	a.RegisterWebhook(&WebhookHandler{
+		Type: "ci",
+		Options: []WebhookOption{
+			{Name: "provider", Required: true, Pattern: regexp.MustCompile(`^(` + strings.Join(ciProviders, "|") + `)$`)},
+		},
+		Payload: true,
+		Handle:  a.ci,
+	})
+
+	a.RegisterWebhook(&WebhookHandler{
+		Type:    "alertmanager",
+		Payload: true,
+		Handle:  a.alertmanager,
+	})
+}
@@ -0,0 +1,90 @@
+package confbot
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+var (
+	// AuditRetention is how long audit entries are kept.
+	AuditRetention = 90 * 24 * time.Hour
+)
+
+// AuditEntry records a command run by a user.
+type AuditEntry struct {
+	At        time.Time `json:"at"`
+	UserID    string    `json:"user_id"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Text      string    `json:"text"`
+	Action    string    `json:"action"`
+	Args      []string  `json:"args,omitempty"`
+	// RunBy is set when the command was run on the user's behalf.
+	RunBy    string        `json:"run_by,omitempty"`
+	Result   string        `json:"result"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AuditFilter selects audit entries.
+type AuditFilter struct {
+	Since  time.Time
+	Until  time.Time
+	UserID string
+	Action string
+}
+
+// Match returns true if the entry is selected by the filter.
+func (f *AuditFilter) Match(e AuditEntry) bool {
+	if !f.Since.IsZero() && e.At.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.At.After(f.Until) {
+		return false
+	}
+	if f.UserID != "" && e.UserID != f.UserID {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	return true
+}
+
+func newAuditEntry(ctx context.Context, name, runBy string, ev *slack.MessageEvent, matches [][]string, start time.Time, err error) AuditEntry {
+	e := AuditEntry{
+		At:        start,
+		UserID:    ev.User,
+		ChannelID: ev.Channel,
+		RequestID: RequestIDFromContext(ctx),
+		Text:      ev.Text,
+		Action:    name,
+		RunBy:     runBy,
+		Result:    outcome(err),
+		Duration:  time.Since(start),
+	}
+
+	if len(matches) > 0 && len(matches[0]) > 1 {
+		e.Args = matches[0][1:]
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	return e
+}
+
+// audit saves an audit entry. The command has already run, so failing to
+// save it is logged.
+func audit(repo Repo, log *logrus.Entry, e AuditEntry) {
+	if err := repo.AddAuditEntry(e); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			"action":  e.Action,
+			"user-id": e.UserID,
+		}).Error("unable to save audit entry")
+	}
+}
@@ -0,0 +1,169 @@
+package confbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+// CreateCertAction returns a function that manages SSH certificates. It
+// handles `./ssh cert`, `./ssh cert instructor`, `./ssh certs` and `./ssh revoke <serial>`.
+func CreateCertAction(ctx context.Context, masterToken string, ca *CertificateAuthority, repo Repo) ActionFn {
+	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
+		if len(matches) == 0 {
+			return fmt.Errorf("nothing to do")
+		}
+
+		userID := m.User
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID, "action": "ssh-cert"})
+
+		_, _, channelID, err := slackClient.OpenIMChannel(userID)
+		if err != nil {
+			return err
+		}
+
+		t := userMessages(ctx, repo, log, userID)
+		params := slack.NewPostMessageParameters()
+		subject, arg := matches[0][1], matches[0][2]
+
+		switch subject {
+		case "cert":
+			var kp *KeyPair
+			var cert []byte
+			var host string
+			var projectID string
+
+			switch arg {
+			case "":
+				projectID, err = repo.ProjectID(userID)
+				if err != nil {
+					return err
+				}
+
+				if projectID == "" {
+					slackClient.PostMessage(channelID, t("project_not_defined", nil), params)
+					return nil
+				}
+
+				kp, cert, err = ca.IssueUserCertificate(userID, projectID)
+				if err != nil {
+					return err
+				}
+				host = fmt.Sprintf("shell.%s.%s", projectID, DropletDomain)
+
+			case InstructorPrincipal:
+				if !isAdmin(userID) {
+					slackClient.PostMessage(channelID, t("cert_instructor_only", nil), params)
+					return nil
+				}
+
+				kp, cert, err = ca.IssueInstructorCertificate(userID)
+				if err != nil {
+					return err
+				}
+				host = fmt.Sprintf("shell.<project>.%s", DropletDomain)
+
+			default:
+				msg := t("cert_unknown", MessageVars{"Kind": arg})
+				slackClient.PostMessage(channelID, msg, params)
+				return nil
+			}
+
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, "id_confbot", kp.private); err != nil {
+				return err
+			}
+
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, "id_confbot-cert.pub", cert); err != nil {
+				return err
+			}
+
+			msg := t("cert_issued", MessageVars{"Host": host, "TTL": ttlFor(arg)})
+			_, _, err = slackClient.PostMessage(channelID, msg, params)
+			return err
+
+		case "certs":
+			certs, err := ca.Certificates(userID)
+			if err != nil {
+				return err
+			}
+
+			if len(certs) == 0 {
+				_, _, err = slackClient.PostMessage(channelID, t("cert_none", nil), params)
+				return err
+			}
+
+			var fields []slack.AttachmentField
+			for _, c := range certs {
+				status := fmt.Sprintf("expires %s", c.ValidBefore.Format(time.RFC1123))
+				if c.Revoked {
+					status = "revoked"
+				}
+
+				fields = append(fields, slack.AttachmentField{
+					Title: fmt.Sprintf("Serial %d", c.Serial),
+					Value: fmt.Sprintf("%s (%s)", strings.Join(c.Principals, ", "), status),
+				})
+			}
+
+			params.Attachments = []slack.Attachment{{Pretext: t("cert_list", nil), Fields: fields}}
+			_, _, err = slackClient.PostMessage(channelID, "Certificates", params)
+			return err
+
+		case "revoke":
+			serial, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				slackClient.PostMessage(channelID, t("cert_revoke_usage", nil), params)
+				return nil
+			}
+
+			certs, err := ca.Certificates("")
+			if err != nil {
+				return err
+			}
+
+			allowed := isAdmin(userID)
+			for _, c := range certs {
+				if c.Serial == serial && c.UserID == userID {
+					allowed = true
+				}
+			}
+
+			if !allowed {
+				msg := t("cert_not_found", MessageVars{"Serial": serial})
+				slackClient.PostMessage(channelID, msg, params)
+				return nil
+			}
+
+			ic, err := ca.Revoke(serial)
+			if err != nil {
+				return err
+			}
+
+			if err := revokeOnHosts(ctx, repo, masterToken, ic); err != nil {
+				log.WithError(err).Error("unable to revoke certificate on hosts")
+				msg := t("cert_revoke_partial", MessageVars{"Serial": serial, "Error": err.Error()})
+				slackClient.PostMessage(channelID, msg, params)
+				return err
+			}
+
+			msg := t("cert_revoked", MessageVars{"Serial": serial})
+			_, _, err = slackClient.PostMessage(channelID, msg, params)
+			return err
+		}
+
+		return nil
+	}
+}
+
+func ttlFor(kind string) time.Duration {
+	if kind == InstructorPrincipal {
+		return InstructorCertificateTTL
+	}
+
+	return CertificateTTL
+}
@@ -0,0 +1,125 @@
+package confbot
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+	"golang.org/x/net/context"
+)
+
+const (
+	// ResourceDroplet is a droplet resource.
+	ResourceDroplet = "droplet"
+	// ResourceDomainRecord is a domain record resource.
+	ResourceDomainRecord = "domain_record"
+)
+
+// Resource is a cloud resource that was created for a project.
+type Resource struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	ProjectID string    `json:"project_id"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s:%d", r.Type, r.ID)
+}
+
+// deleteResource deletes a resource. Resources that no longer exist are
+// considered deleted.
+func deleteResource(r Resource, masterToken string) error {
+	var resp *godo.Response
+	var err error
+
+	switch r.Type {
+	case ResourceDroplet:
+		resp, err = buildDoClient(r.Token).Droplets.Delete(r.ID)
+	case ResourceDomainRecord:
+		resp, err = buildDoClient(masterToken).Domains.DeleteRecord(DropletDomain, r.ID)
+	default:
+		return fmt.Errorf("unknown resource type %q", r.Type)
+	}
+
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// Reconciler deletes resources that were recorded for cleanup after
+// a failed boot.
+type Reconciler struct {
+	repo        Repo
+	masterToken string
+	log         *logrus.Entry
+}
+
+// NewReconciler creates an instance of Reconciler.
+func NewReconciler(ctx context.Context, masterToken string, repo Repo) *Reconciler {
+	return &Reconciler{
+		repo:        repo,
+		masterToken: masterToken,
+		log:         LogFromContext(ctx).WithField("component", "reconciler"),
+	}
+}
+
+// Run deletes resources recorded for cleanup. It returns the
+// amount of resources that were deleted.
+func (r *Reconciler) Run() (int, error) {
+	resources, err := r.repo.Cleanups()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, res := range resources {
+		log := r.log.WithFields(logrus.Fields{
+			"project-id": res.ProjectID,
+			"resource":   res.String(),
+		})
+
+		if err := deleteResource(res, r.masterToken); err != nil {
+			log.WithError(err).Warn("unable to delete resource")
+			continue
+		}
+
+		if err := r.repo.RemoveCleanup(res); err != nil {
+			return deleted, err
+		}
+
+		log.Info("deleted resource")
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// Start runs the reconciler every interval until ctx is done. Expired
+// certificates are removed on the same interval.
+func (r *Reconciler) Start(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.Run(); err != nil {
+				r.log.WithError(err).Error("reconcile failed")
+			}
+
+			if n, err := RemoveExpiredCertificates(r.repo, time.Now()); err != nil {
+				r.log.WithError(err).Error("unable to remove expired certificates")
+			} else if n > 0 {
+				r.log.WithField("removed", n).Info("removed expired certificates")
+			}
+		}
+	}
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"confbot"
+	"confbot/simulate"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -18,6 +24,12 @@ import (
 
 const (
 	appName = "confbot"
+
+	reconcileInterval = 10 * time.Minute
+
+	// logFlushTimeout is how long queued log entries are given to be sent
+	// on shutdown.
+	logFlushTimeout = 5 * time.Second
 )
 
 var (
@@ -30,6 +42,12 @@ type Specification struct {
 	SlackToken         string   `envconfig:"slack_token" required:"true"`
 	PaperTrailHost     string   `envconfig:"papertrail_host"`
 	PaperTrailPort     int      `envconfig:"papertrail_port"`
+	PaperTrailNetwork  string   `envconfig:"papertrail_network" default:"tls"`
+	LogConfig          string   `envconfig:"log_config"`
+	LogFormat          string   `envconfig:"log_format" default:"text"`
+	LogLevel           string   `envconfig:"log_level" default:"info"`
+	LogFile            string   `envconfig:"log_file"`
+	LogHTTPURL         string   `envconfig:"log_http_url"`
 	HTTPAddr           string   `envconfig:"http_addr" default:"localhost:8080"`
 	Port               string   `envconfig:"port"`
 	RemoteLogging      bool     `envconfig:"remote_logging" default:"false"`
@@ -38,9 +56,42 @@ type Specification struct {
 	MasterToken        string   `envconfig:"master_token" required:"true"`
 	DropletDomain      string   `envconfig:"droplet_domain" required:"true"`
 	WebhookURL         string   `envconfig:"webhook_url" required:"true"`
+	DropletsPerProject int      `envconfig:"droplets_per_project" default:"3"`
+	WorkshopConfig     string   `envconfig:"workshop_config"`
+	MessagesDir        string   `envconfig:"messages_dir"`
+	DefaultLocale      string   `envconfig:"default_locale" default:"en"`
+	RegionPolicy       string   `envconfig:"region_policy"`
+	Regions            []string `envconfig:"regions"`
+	ConferenceLocation string   `envconfig:"conference_location"`
+	MasterKeys         []string `envconfig:"master_keys"`
+	SSHKeyType         string   `envconfig:"ssh_key_type" default:"rsa"`
+	SSHKeyBits         int      `envconfig:"ssh_key_bits" default:"3072"`
+	Admins             []string `envconfig:"admins"`
+	CertificateTTL     string   `envconfig:"certificate_ttl" default:"12h"`
+	PublicURL          string   `envconfig:"public_url"`
+	DownloadSecret     string   `envconfig:"download_secret" required:"true"`
+	DownloadTTL        string   `envconfig:"download_ttl" default:"15m"`
+	AdminToken         string   `envconfig:"admin_token"`
+	LogSearchURL       string   `envconfig:"log_search_url"`
+	AuditRetention     string   `envconfig:"audit_retention" default:"2160h"`
+	WebhookWorkers     int      `envconfig:"webhook_workers" default:"25"`
+	WebhookQueueSize   int      `envconfig:"webhook_queue_size" default:"200"`
 }
 
 func main() {
+	simulateMode := flag.Bool("simulate", false, "rehearse with fake Slack, DigitalOcean and droplets, configured by CONFBOT_SIMULATE_CONFIG")
+	flag.Parse()
+
+	var simulateConfig *simulate.Config
+	if *simulateMode {
+		cfg, err := loadSimulateConfig()
+		if err != nil {
+			rootLog.WithError(err).Fatal("unable to load simulate config")
+		}
+		simulateConfig = cfg
+		simulateEnvironment(simulateConfig)
+	}
+
 	var spec Specification
 	err := envconfig.Process("confbot", &spec)
 	if err != nil {
@@ -51,9 +102,23 @@ func main() {
 		"env": spec.Env,
 	})
 
-	setupLogger(spec, log)
+	logRouter := setupLogger(spec, log)
 
-	ctx := context.WithValue(context.Background(), "log", log)
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+
+		log.WithField("signal", sig.String()).Info("shutting down")
+		shutdown(logRouter, 0)
+	}()
+
+	var sim *simulate.Simulator
+	if simulateConfig != nil {
+		sim = startSimulator(simulateConfig, log)
+	}
+
+	ctx := confbot.ContextWithLog(context.Background(), log)
 
 	log.WithField("droplet-domain", spec.DropletDomain).Info("setting droplet domain")
 	confbot.DropletDomain = spec.DropletDomain
@@ -61,6 +126,101 @@ func main() {
 	log.WithField("webhook-url", spec.WebhookURL).Info("setting bot webhook URL")
 	confbot.WebhookURL = spec.WebhookURL
 
+	if spec.PublicURL == "" {
+		spec.PublicURL = strings.TrimSuffix(spec.WebhookURL, "/webhook")
+	}
+	log.WithField("public-url", spec.PublicURL).Info("setting bot public URL")
+	confbot.PublicURL = strings.TrimSuffix(spec.PublicURL, "/")
+
+	downloadTTL, err := time.ParseDuration(spec.DownloadTTL)
+	if err != nil {
+		log.WithError(err).Fatal("invalid download ttl")
+	}
+	confbot.DownloadTTL = downloadTTL
+	confbot.DownloadSecret = spec.DownloadSecret
+
+	log.WithField("droplets-per-project", spec.DropletsPerProject).Info("setting droplets per project")
+	confbot.DropletsPerProject = spec.DropletsPerProject
+
+	workshop := confbot.DefaultWorkshop()
+	if spec.WorkshopConfig != "" {
+		workshop, err = confbot.LoadWorkshop(spec.WorkshopConfig)
+		if err != nil {
+			log.WithError(err).Fatal("unable to load workshop config")
+		}
+	}
+
+	// settings from the environment override the workshop config.
+	if len(spec.MasterKeys) > 0 {
+		workshop.MasterKeys = spec.MasterKeys
+	}
+	if spec.RegionPolicy != "" {
+		workshop.Droplet.RegionPolicy = spec.RegionPolicy
+	}
+	if len(spec.Regions) > 0 {
+		workshop.Droplet.Regions = spec.Regions
+	}
+	if spec.ConferenceLocation != "" {
+		workshop.Location = spec.ConferenceLocation
+	}
+	if err := workshop.Validate(); err != nil {
+		log.WithError(err).Fatal("invalid workshop settings")
+	}
+
+	log.WithFields(logrus.Fields{
+		"workshop":     workshop.Name,
+		"droplet-size": workshop.Droplet.Size,
+		"image":        workshop.Droplet.Image,
+		"master-keys":  len(workshop.MasterKeys),
+	}).Info("setting workshop")
+	confbot.CurrentWorkshop = workshop
+
+	messages := confbot.DefaultMessages()
+	if spec.MessagesDir != "" {
+		messages, err = confbot.LoadMessages(spec.MessagesDir)
+		if err != nil {
+			log.WithError(err).Fatal("unable to load messages")
+		}
+	}
+
+	defaultLocale := confbot.NormalizeLocale(spec.DefaultLocale)
+	if !messages.Has(defaultLocale) {
+		log.WithField("locale", defaultLocale).Fatal("no messages for default locale")
+	}
+
+	log.WithFields(logrus.Fields{
+		"locales":        messages.Locales(),
+		"default-locale": defaultLocale,
+	}).Info("setting messages")
+	confbot.CurrentMessages = messages
+	confbot.DefaultLocale = defaultLocale
+
+	log.WithFields(logrus.Fields{
+		"ssh-key-type": spec.SSHKeyType,
+		"ssh-key-bits": spec.SSHKeyBits,
+	}).Info("setting ssh key settings")
+	confbot.SSHKeyType = spec.SSHKeyType
+	confbot.SSHKeyBits = spec.SSHKeyBits
+	if err := confbot.ValidateSSHKeySettings(); err != nil {
+		log.WithError(err).Fatal("invalid ssh key settings")
+	}
+
+	log.WithField("admins", spec.Admins).Info("setting admins")
+	confbot.Admins = spec.Admins
+
+	certificateTTL, err := time.ParseDuration(spec.CertificateTTL)
+	if err != nil {
+		log.WithError(err).Fatal("invalid certificate ttl")
+	}
+	confbot.CertificateTTL = certificateTTL
+
+	auditRetention, err := time.ParseDuration(spec.AuditRetention)
+	if err != nil {
+		log.WithError(err).Fatal("invalid audit retention")
+	}
+	log.WithField("audit-retention", auditRetention).Info("setting audit retention")
+	confbot.AuditRetention = auditRetention
+
 	slackClient := slack.New(spec.SlackToken)
 	slackClient.SetDebug(true)
 
@@ -78,47 +238,204 @@ func main() {
 		rootLog.WithError(err).Fatalf("unable to create repo")
 	}
 
+	poolTokens, err := repo.PoolTokens()
+	if err != nil {
+		log.WithError(err).Fatal("unable to load saved digitalocean tokens")
+	}
+
+	logRouter.Redact(poolTokens...)
+
+	tokens := spec.DigitalOceanTokens
+	for _, t := range poolTokens {
+		if !contains(tokens, t) {
+			tokens = append(tokens, t)
+		}
+	}
+
+	tokenPool := confbot.NewTokenPool(ctx, tokens, repo)
+	tokenPool.Refresh()
+
+	regionPolicy, err := confbot.NewRegionPolicy(workshop.Droplet.RegionPolicy, workshop.Droplet.Regions, workshop.Location)
+	if err != nil {
+		log.WithError(err).Fatal("unable to create region policy")
+	}
+
+	log.WithFields(logrus.Fields{
+		"region-policy": workshop.Droplet.RegionPolicy,
+		"regions":       workshop.Droplet.Regions,
+	}).Info("setting region policy")
+	regionSelector := confbot.NewRegionSelector(ctx, tokenPool.Client(spec.MasterToken), regionPolicy)
+
+	projects, err := confbot.ListProjects(repo)
+	if err != nil {
+		log.WithError(err).Error("unable to count shells by region")
+	}
+	for _, p := range projects {
+		if p.Region != "" {
+			regionSelector.Used(p.ID, p.Region)
+		}
+	}
+
+	ca, err := confbot.NewCertificateAuthority(ctx, repo)
+	if err != nil {
+		log.WithError(err).Fatal("unable to create ssh certificate authority")
+	}
+
+	events := confbot.NewEventBus(log)
+	events.Subscribe(confbot.SlackNotifier(slackClient, repo, log))
+	events.Subscribe(confbot.MetricsRecorder())
+	events.Subscribe(confbot.ProjectStateRecorder(repo, log))
+	events.Subscribe(confbot.HistoryRecorder(repo, log))
+	events.Subscribe(confbot.RegionUsageRecorder(regionSelector))
+	events.Subscribe(confbot.TokenUsageRecorder(tokenPool))
+	ctx = confbot.ContextWithEventBus(ctx, events)
+
 	cb := confbot.New(ctx, slackClient, repo)
 
-	cb.AddTextAction("^hello$", confbot.CreateHelloAction(ctx, repo))
-	cb.AddTextAction("^./help$", confbot.CreateHelpAction(ctx, repo))
-	cb.AddTextAction("^./boot shell$", confbot.CreateBootShellAction(ctx, spec.MasterToken, spec.DigitalOceanTokens, repo))
-	cb.AddTextAction("^./delete$", confbot.CreateDeleteAction(ctx, spec.MasterToken, repo))
-	cb.AddTextAction("^./reset$", confbot.CreateResetAction(ctx, repo))
-	cb.AddTextAction("^./provision$", confbot.CreateProvisionAction(ctx, repo))
-	cb.AddTextAction("^./settings$", confbot.CreateSettingsAction(repo))
-	cb.AddTextAction(`^./configure ssh (\w+)$`, confbot.CreateConfigureSSHAction(ctx, repo))
-	go cb.Listen()
-
-	a := api.New(ctx, repo, slackClient)
+	cb.AddTextAction("hello", "^hello$", confbot.CreateHelloAction(ctx, repo))
+	cb.AddTextAction("help", "^./help$", confbot.CreateHelpAction(ctx, repo))
+	cb.AddTextAction("boot-shell", "^./boot shell$", confbot.CreateBootShellAction(ctx, spec.MasterToken, tokenPool, regionSelector, ca, repo))
+	cb.AddTextAction("delete", "^./delete$", confbot.CreateDeleteAction(ctx, spec.MasterToken, tokenPool, repo))
+	cb.AddTextAction("reset", "^./reset$", confbot.CreateResetAction(ctx, repo))
+	cb.AddTextAction("provision", "^./provision$", confbot.CreateProvisionAction(ctx, spec.MasterToken, repo))
+	cb.AddTextAction("settings", "^./settings$", confbot.CreateSettingsAction(repo))
+	cb.AddTextAction("configure-ssh", `^./configure ssh ([\w-]+)$`, confbot.CreateConfigureSSHAction(ctx, repo))
+	cb.AddTextAction("rotate-key", "^./rotate key$", confbot.CreateRotateKeyAction(ctx, spec.MasterToken, repo))
+	cb.AddTextAction("ssh-cert", `^./ssh (cert|certs|revoke)(?: (\S+))?$`, confbot.CreateCertAction(ctx, spec.MasterToken, ca, repo))
+	cb.AddTextAction("history", "^./history$", confbot.CreateHistoryAction(ctx, repo))
+	cb.AddTextAction("language", `^./language(?: (\S+))?$`, confbot.CreateLanguageAction(ctx, repo))
+	cb.AddTextAction("admin-history", `^./admin history (\S+)$`, confbot.CreateAdminHistoryAction(ctx, repo))
+	if sim != nil {
+		sim.Slack.HandleMessages(cb.HandleMessage)
+		go sim.Console(os.Stdin, os.Stdout)
+	} else {
+		go cb.Listen()
+	}
+
+	reconciler := confbot.NewReconciler(ctx, spec.MasterToken, repo)
+	go reconciler.Start(ctx, reconcileInterval)
+
+	api.WebhookWorkers = spec.WebhookWorkers
+	api.WebhookQueueSize = spec.WebhookQueueSize
+	a := api.New(ctx, repo, slackClient, spec.MasterToken)
+	if err := a.ResumeWebhooks(); err != nil {
+		log.WithError(err).Error("unable to resume webhooks")
+	}
+	a.AddCheck("slack-auth", cb.CheckAuth)
+	if sim == nil {
+		a.AddCheck("slack-rtm", cb.CheckRTM)
+	}
+	a.AddCheckSet(func() map[string]api.CheckFn {
+		checks := map[string]api.CheckFn{}
+		for name, fn := range tokenPool.Checks() {
+			checks[name] = fn
+		}
+		return checks
+	})
+
+	if spec.AdminToken != "" {
+		log.Info("enabling admin api")
+		a.EnableAdmin(api.AdminConfig{
+			Token:        spec.AdminToken,
+			MasterToken:  spec.MasterToken,
+			Bot:          cb,
+			TokenPool:    tokenPool,
+			Redact:       logRouter.Redact,
+			LogSearchURL: spec.LogSearchURL,
+		})
+	}
 	http.Handle("/", a.Mux)
+	if sim != nil {
+		http.Handle("/simulate/", sim.ChatHandler())
+	}
 
 	if spec.Port != "" {
 		spec.HTTPAddr = fmt.Sprintf(":%s", spec.Port)
 	}
 
 	log.WithField("addr", spec.HTTPAddr).Info("created http server")
-	log.Fatal(http.ListenAndServe(spec.HTTPAddr, nil))
+	err = http.ListenAndServe(spec.HTTPAddr, nil)
+	log.WithError(err).Error("http server stopped")
+	shutdown(logRouter, 1)
 }
 
-func setupLogger(spec Specification, log *logrus.Entry) {
-	if spec.RemoteLogging {
-		hostname, err := os.Hostname()
+// setupLogger configures logging from the file in LOG_CONFIG, or from the
+// environment.
+func setupLogger(spec Specification, log *logrus.Entry) *logging.Router {
+	var cfg logging.Config
+	if spec.LogConfig != "" {
+		c, err := logging.LoadConfig(spec.LogConfig)
 		if err != nil {
-			log.Fatalf("unable to retrieve app hostname: %v", err)
+			log.WithError(err).Fatal("unable to read log config")
+		}
+		cfg = *c
+	} else {
+		cfg = logging.Config{
+			Format: spec.LogFormat,
+			Level:  spec.LogLevel,
+			Sinks:  []logging.SinkConfig{{Type: logging.SinkStdout}},
 		}
 
-		hook, err := logging.NewPapertrailHook(&logging.Hook{
-			Host:     spec.PaperTrailHost,
-			Port:     spec.PaperTrailPort,
-			Hostname: hostname,
-			Appname:  "confbot",
-		})
+		if spec.LogFile != "" {
+			cfg.Sinks = append(cfg.Sinks, logging.SinkConfig{Type: logging.SinkFile, Path: spec.LogFile})
+		}
 
-		if err != nil {
-			log.WithError(err).Fatalf("unable to set up papertrail logging")
+		if spec.RemoteLogging {
+			cfg.Sinks = append(cfg.Sinks, logging.SinkConfig{
+				Type:    logging.SinkSyslog,
+				Host:    spec.PaperTrailHost,
+				Port:    spec.PaperTrailPort,
+				Network: spec.PaperTrailNetwork,
+			})
+		}
+
+		if spec.LogHTTPURL != "" {
+			cfg.Sinks = append(cfg.Sinks, logging.SinkConfig{Type: logging.SinkHTTP, URL: spec.LogHTTPURL})
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("unable to retrieve app hostname: %v", err)
+	}
+
+	for i := range cfg.Sinks {
+		if cfg.Sinks[i].Type != logging.SinkSyslog {
+			continue
+		}
+		if cfg.Sinks[i].Hostname == "" {
+			cfg.Sinks[i].Hostname = hostname
 		}
+		if cfg.Sinks[i].Appname == "" {
+			cfg.Sinks[i].Appname = appName
+		}
+	}
 
-		rootLog.Hooks.Add(hook)
+	secrets := []string{spec.SlackToken, spec.MasterToken, spec.DownloadSecret, spec.AdminToken}
+	secrets = append(secrets, spec.DigitalOceanTokens...)
+
+	router, err := logging.Setup(rootLog, cfg, secrets...)
+	if err != nil {
+		log.WithError(err).Fatal("unable to set up logging")
+	}
+
+	return router
+}
+
+// shutdown sends queued log entries and exits.
+func shutdown(router *logging.Router, code int) {
+	if err := router.Close(logFlushTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to flush logs: %v\n", err)
+	}
+
+	os.Exit(code)
+}
+
+func contains(vs []string, s string) bool {
+	for _, v := range vs {
+		if v == s {
+			return true
+		}
 	}
+	return false
 }
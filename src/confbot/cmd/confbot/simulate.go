@@ -0,0 +1,79 @@
+package main
+
+import (
+	"confbot"
+	"confbot/simulate"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+// loadSimulateConfig reads the simulate config in CONFBOT_SIMULATE_CONFIG,
+// or returns the default config.
+func loadSimulateConfig() (*simulate.Config, error) {
+	path := os.Getenv("CONFBOT_SIMULATE_CONFIG")
+	if path == "" {
+		return simulate.DefaultConfig(), nil
+	}
+
+	return simulate.LoadConfig(path)
+}
+
+// simulateEnvironment fills in the settings which are faked when
+// simulating, so only redis is needed. Settings which are already set are
+// kept.
+func simulateEnvironment(cfg *simulate.Config) {
+	addr := os.Getenv("CONFBOT_HTTP_ADDR")
+	if addr == "" {
+		addr = "localhost:8080"
+	}
+	if port := os.Getenv("CONFBOT_PORT"); port != "" {
+		addr = "localhost:" + port
+	}
+
+	defaults := map[string]string{
+		"CONFBOT_ENV":                 "simulate",
+		"CONFBOT_SLACK_TOKEN":         "simulated-slack-token",
+		"CONFBOT_BOT_NAME":            "confbot",
+		"CONFBOT_DIGITALOCEAN_TOKENS": strings.Join(cfg.Tokens(), ","),
+		"CONFBOT_MASTER_TOKEN":        "simulated-master-token",
+		"CONFBOT_DROPLET_DOMAIN":      "simulated.test",
+		"CONFBOT_WEBHOOK_URL":         "http://" + addr + "/webhook",
+		"CONFBOT_DOWNLOAD_SECRET":     "simulated-download-secret",
+		"CONFBOT_ADMINS":              "instructor",
+		"REDIS_URL":                   "redis://localhost:6379",
+	}
+
+	for k, v := range defaults {
+		if os.Getenv(k) == "" {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+// startSimulator serves the fake APIs and points confbot at them.
+func startSimulator(cfg *simulate.Config, log *logrus.Entry) *simulate.Simulator {
+	sim, err := simulate.New(cfg, log)
+	if err != nil {
+		log.WithError(err).Fatal("unable to create simulator")
+	}
+
+	go func() {
+		err := sim.Serve()
+		log.WithError(err).Fatal("simulator stopped")
+	}()
+
+	log.WithFields(logrus.Fields{
+		"users":    cfg.Users,
+		"accounts": cfg.Cloud.Accounts,
+		"regions":  cfg.Cloud.Regions,
+	}).Warn("simulating slack, digitalocean and droplets")
+
+	slack.SLACK_API = sim.SlackAPI()
+	confbot.DigitalOceanURL = sim.DigitalOceanURL()
+	confbot.CurrentHostExecutor = sim.Hosts
+
+	return sim
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"confbot"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+// projectView is a project with its hostname and cloud resources.
+type projectView struct {
+	confbot.Project
+	Hostname  string                    `json:"hostname"`
+	Resources *confbot.ProjectResources `json:"resources,omitempty"`
+}
+
+func projectsList(c *ctl, args []string) error {
+	if err := requireArgs(args, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	projects, err := confbot.ListProjects(c.repo)
+	if err != nil {
+		return err
+	}
+
+	// resources are looked up when the master token is available, so the
+	// list works without DigitalOcean access.
+	var resources map[string]*confbot.ProjectResources
+	if c.spec.MasterToken != "" {
+		var ids []string
+		for _, p := range projects {
+			ids = append(ids, p.ID)
+		}
+
+		resources, err = confbot.FindProjectResources(c.repo, c.spec.MasterToken, ids)
+		if err != nil {
+			c.log.WithError(err).Warn("unable to find project resources")
+		}
+	}
+
+	views := []projectView{}
+	var rows [][]string
+	for _, p := range sortedProjects(projects) {
+		v := projectView{Project: p, Hostname: p.Hostname(), Resources: resources[p.ID]}
+		views = append(views, v)
+
+		droplets := "-"
+		if v.Resources != nil {
+			droplets = strconv.Itoa(len(v.Resources.Droplets))
+		}
+
+		rows = append(rows, []string{
+			p.ID,
+			orDash(p.UserID),
+			p.Status,
+			orDash(p.Step),
+			orDash(p.Region),
+			droplets,
+			formatTime(p.UpdatedAt),
+		})
+	}
+
+	return c.out.table(views, []string{"PROJECT", "USER", "STATUS", "STEP", "REGION", "DROPLETS", "UPDATED"}, rows)
+}
+
+func projectsShow(c *ctl, args []string) error {
+	if err := requireArgs(args, 1, "a project ID"); err != nil {
+		return err
+	}
+
+	p, err := c.loadProject(args[0])
+	if err != nil {
+		return err
+	}
+
+	v := projectView{Project: *p, Hostname: p.Hostname()}
+	if c.spec.MasterToken != "" {
+		resources, err := confbot.FindProjectResources(c.repo, c.spec.MasterToken, []string{p.ID})
+		if err != nil {
+			return err
+		}
+		v.Resources = resources[p.ID]
+	}
+
+	pairs := [][2]string{
+		{"Project", p.ID},
+		{"User", orDash(p.UserID)},
+		{"Hostname", v.Hostname},
+		{"Status", p.Status},
+		{"Step", orDash(p.Step)},
+		{"Error", orDash(p.Error)},
+		{"Region", orDash(p.Region)},
+		{"Created", formatTime(p.CreatedAt)},
+		{"Updated", formatTime(p.UpdatedAt)},
+	}
+
+	if v.Resources != nil {
+		for _, d := range v.Resources.Droplets {
+			pairs = append(pairs, [2]string{"Droplet", fmt.Sprintf("%s (%d, %s, %s, %s)", d.Name, d.ID, d.Region, d.Status, orDash(d.IP))})
+		}
+		for _, r := range v.Resources.Records {
+			pairs = append(pairs, [2]string{"Record", fmt.Sprintf("%s %s %s (%d)", r.Name, r.Type, r.Data, r.ID)})
+		}
+	}
+
+	return c.out.fields(v, pairs)
+}
+
+func projectsDelete(c *ctl, args []string) error {
+	if err := requireArgs(args, 1, "a project ID"); err != nil {
+		return err
+	}
+	if err := requireSetting("master_token", c.spec.MasterToken); err != nil {
+		return err
+	}
+	if err := requireSetting("droplet_domain", c.spec.DropletDomain); err != nil {
+		return err
+	}
+
+	p, err := c.loadProject(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := confbot.DeleteProject(c.eventContext(), c.repo, nil, c.spec.MasterToken, p.ID, p.UserID); err != nil {
+		return err
+	}
+
+	return c.out.fields(
+		map[string]string{"project_id": p.ID, "status": "deleted"},
+		[][2]string{{"Project", p.ID}, {"Status", "deleted"}},
+	)
+}
+
+func projectsReset(c *ctl, args []string) error {
+	if err := requireArgs(args, 1, "a project ID"); err != nil {
+		return err
+	}
+
+	p, err := c.loadProject(args[0])
+	if err != nil {
+		return err
+	}
+
+	c.log.WithFields(logrus.Fields{"project-id": p.ID, "user-id": p.UserID}).Info("resetting project")
+	if err := c.repo.ResetProject(p.UserID); err != nil {
+		return err
+	}
+
+	return c.out.fields(
+		map[string]string{"project_id": p.ID, "status": "reset"},
+		[][2]string{{"Project", p.ID}, {"Status", "reset"}},
+	)
+}
+
+// tokenView is the result of validating a token.
+type tokenView struct {
+	confbot.TokenCapacity
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+func tokensValidate(c *ctl, args []string) error {
+	if err := requireArgs(args, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	poolTokens, err := c.repo.PoolTokens()
+	if err != nil {
+		return err
+	}
+
+	tokens := c.spec.DigitalOceanTokens
+	for _, t := range poolTokens {
+		if !contains(tokens, t) {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("there are no tokens in CONFBOT_DIGITALOCEAN_TOKENS or the token pool")
+	}
+
+	tp := confbot.NewTokenPool(c.ctx, tokens, c.repo)
+	tp.Refresh()
+
+	views := []tokenView{}
+	var rows [][]string
+	invalid := 0
+	for _, tc := range tp.Capacity() {
+		v := tokenView{TokenCapacity: tc, Valid: true}
+		if err := tp.Ping(tc.Fingerprint); err != nil {
+			v.Valid = false
+			v.Error = err.Error()
+			invalid++
+		}
+		views = append(views, v)
+
+		rows = append(rows, []string{
+			tc.Fingerprint,
+			strconv.FormatBool(v.Valid),
+			orDash(tc.Status),
+			fmt.Sprintf("%d/%d", tc.Droplets, tc.DropletLimit),
+			strconv.Itoa(tc.RateRemaining),
+			orDash(v.Error),
+		})
+	}
+
+	if err := c.out.table(views, []string{"FINGERPRINT", "VALID", "STATUS", "DROPLETS", "RATE-REMAINING", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d tokens are invalid", invalid, len(views))
+	}
+
+	return nil
+}
+
+func reconcile(c *ctl, args []string) error {
+	if err := requireArgs(args, 0, "no arguments"); err != nil {
+		return err
+	}
+	if err := requireSetting("master_token", c.spec.MasterToken); err != nil {
+		return err
+	}
+	if err := requireSetting("droplet_domain", c.spec.DropletDomain); err != nil {
+		return err
+	}
+
+	deleted, err := confbot.NewReconciler(c.ctx, c.spec.MasterToken, c.repo).Run()
+	if err != nil {
+		return err
+	}
+
+	remaining, err := c.repo.Cleanups()
+	if err != nil {
+		return err
+	}
+
+	return c.out.fields(
+		map[string]int{"deleted": deleted, "remaining": len(remaining)},
+		[][2]string{{"Deleted", strconv.Itoa(deleted)}, {"Remaining", strconv.Itoa(len(remaining))}},
+	)
+}
+
+// exportData writes the export as JSON whatever the output format, so it
+// can be imported.
+func exportData(c *ctl, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected an optional file name")
+	}
+
+	e, err := confbot.ExportData(c.repo)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		// the export contains tokens and private keys.
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := (&printer{out: w, format: formatJSON}).json(e); err != nil {
+		return err
+	}
+
+	c.log.WithField("projects", len(e.Projects)).Info("exported data")
+	return nil
+}
+
+func importData(c *ctl, args []string) error {
+	if err := requireArgs(args, 1, "a file name"); err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	var e confbot.Export
+	if err := json.Unmarshal(b, &e); err != nil {
+		return fmt.Errorf("invalid export %s: %v", args[0], err)
+	}
+
+	res, err := confbot.ImportData(c.repo, &e)
+	if err != nil {
+		return err
+	}
+
+	return c.out.fields(res, [][2]string{
+		{"Projects", strconv.Itoa(res.Projects)},
+		{"Skipped", orDash(strings.Join(res.Skipped, ", "))},
+		{"Cleanups", strconv.Itoa(res.Cleanups)},
+		{"Pool tokens", strconv.Itoa(res.PoolTokens)},
+		{"Certificates", strconv.Itoa(res.Certificates)},
+	})
+}
+
+// userData prints the user data as is, or as JSON with -o json.
+func userData(c *ctl, args []string) error {
+	if err := requireArgs(args, 1, "a project ID"); err != nil {
+		return err
+	}
+
+	p, err := c.loadProject(args[0])
+	if err != nil {
+		return err
+	}
+
+	// the CA key is created by the bot. Creating one here would make the
+	// user data trust a key the droplets don't.
+	caKey, err := c.repo.CAKey()
+	if err != nil {
+		return err
+	}
+	if caKey == nil {
+		return fmt.Errorf("there is no SSH CA key yet, start confbot to create one")
+	}
+
+	ca, err := confbot.NewCertificateAuthority(c.ctx, c.repo)
+	if err != nil {
+		return err
+	}
+
+	ud, err := confbot.ProjectUserData(c.repo, ca, p.ID)
+	if err != nil {
+		return err
+	}
+
+	if c.out.format == formatJSON {
+		return c.out.json(map[string]string{"project_id": p.ID, "user_data": ud})
+	}
+
+	_, err = io.WriteString(c.out.out, ud)
+	return err
+}
+
+// broadcastResult is the outcome of sending a broadcast to a user.
+type broadcastResult struct {
+	ProjectID string `json:"project_id"`
+	UserID    string `json:"user_id"`
+	Sent      bool   `json:"sent"`
+	Error     string `json:"error,omitempty"`
+}
+
+func broadcast(c *ctl, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a message")
+	}
+	if err := requireSetting("slack_token", c.spec.SlackToken); err != nil {
+		return err
+	}
+
+	msg := strings.Join(args, " ")
+
+	projects, err := confbot.ListProjects(c.repo)
+	if err != nil {
+		return err
+	}
+
+	slackClient := slack.New(c.spec.SlackToken)
+	params := slack.NewPostMessageParameters()
+
+	results := []broadcastResult{}
+	var rows [][]string
+	failed := 0
+	for _, p := range sortedProjects(projects) {
+		r := broadcastResult{ProjectID: p.ID, UserID: p.UserID}
+
+		_, _, channelID, err := slackClient.OpenIMChannel(p.UserID)
+		if err == nil {
+			_, _, err = slackClient.PostMessage(channelID, msg, params)
+		}
+
+		if err != nil {
+			r.Error = err.Error()
+			failed++
+		} else {
+			r.Sent = true
+		}
+
+		results = append(results, r)
+		rows = append(rows, []string{p.ID, p.UserID, strconv.FormatBool(r.Sent), orDash(r.Error)})
+	}
+
+	if err := c.out.table(results, []string{"PROJECT", "USER", "SENT", "ERROR"}, rows); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to send the broadcast to %d of %d users", failed, len(results))
+	}
+
+	return nil
+}
+
+func messages(c *ctl, args []string) error {
+	if err := requireArgs(args, 0, "no arguments"); err != nil {
+		return err
+	}
+
+	return confbot.WriteDefaultMessages(c.out.out)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func contains(vs []string, s string) bool {
+	for _, v := range vs {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+// Command confbotctl administers confbot's projects, tokens and data. It
+// uses the same environment as confbot, and talks to redis and DigitalOcean
+// directly, so it works while the bot is stopped.
+package main
+
+import (
+	"confbot"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// runBy is recorded as the runner of the operations confbotctl starts.
+const runBy = "confbotctl"
+
+// Specification describes the environment used by confbotctl. It is a
+// subset of confbot's; settings are only required by the commands which
+// use them.
+type Specification struct {
+	Env                string   `default:"development"`
+	SlackToken         string   `envconfig:"slack_token"`
+	DigitalOceanTokens []string `envconfig:"digitalocean_tokens"`
+	MasterToken        string   `envconfig:"master_token"`
+	DropletDomain      string   `envconfig:"droplet_domain"`
+	WebhookURL         string   `envconfig:"webhook_url"`
+	WorkshopConfig     string   `envconfig:"workshop_config"`
+	MasterKeys         []string `envconfig:"master_keys"`
+}
+
+// ctl is the state shared by commands.
+type ctl struct {
+	ctx  context.Context
+	spec Specification
+	repo confbot.Repo
+	log  *logrus.Entry
+	out  *printer
+}
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(c *ctl, args []string) error
+	// offline commands don't use redis.
+	offline bool
+}
+
+var commands = []command{
+	{"projects list", "", "list projects and their droplets", projectsList, false},
+	{"projects show", "<project-id>", "show a project", projectsShow, false},
+	{"projects delete", "<project-id>", "delete a project's cloud resources and forget it", projectsDelete, false},
+	{"projects reset", "<project-id>", "forget a project, leaving its cloud resources", projectsReset, false},
+	{"tokens validate", "", "check the DigitalOcean tokens in the environment and the token pool", tokensValidate, false},
+	{"reconcile", "", "delete resources left behind by failed boots", reconcile, false},
+	{"export", "[file]", "write projects, tokens and the SSH CA as JSON", exportData, false},
+	{"import", "<file>", "read data written by export", importData, false},
+	{"user-data", "<project-id>", "render the cloud-init user data for a project's shell droplet", userData, false},
+	{"broadcast", "<message>", "send a message to every project's owner", broadcast, false},
+	{"messages", "", "write the built in messages as a message file to translate", messages, true},
+}
+
+func main() {
+	flags := flag.NewFlagSet("confbotctl", flag.ExitOnError)
+	format := flags.String("o", formatTable, "output format, table or json")
+	verbose := flags.Bool("v", false, "log progress")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if *format != formatTable && *format != formatJSON {
+		fail(fmt.Errorf("unknown output format %q", *format))
+	}
+
+	cmd, args, ok := findCommand(flags.Args())
+	if !ok {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	rootLog := logrus.New()
+	rootLog.Out = os.Stderr
+	rootLog.Level = logrus.WarnLevel
+	if *verbose {
+		rootLog.Level = logrus.InfoLevel
+	}
+
+	var spec Specification
+	if err := envconfig.Process("confbot", &spec); err != nil {
+		fail(err)
+	}
+
+	log := rootLog.WithFields(logrus.Fields{"env": spec.Env, "run-by": runBy})
+	ctx := confbot.ContextWithLog(context.Background(), log)
+	ctx = confbot.ContextWithRequestID(ctx, confbot.NewRequestID())
+
+	if err := configure(spec); err != nil {
+		fail(err)
+	}
+
+	c := &ctl{
+		ctx:  ctx,
+		spec: spec,
+		log:  confbot.LogFromContext(ctx),
+		out:  &printer{out: os.Stdout, format: *format},
+	}
+
+	if !cmd.offline {
+		repo, err := connect(ctx, spec)
+		if err != nil {
+			fail(err)
+		}
+		c.repo = repo
+	}
+
+	if err := cmd.run(c, args); err != nil {
+		fail(err)
+	}
+}
+
+// connect creates the repo from REDIS_URL.
+func connect(ctx context.Context, spec Specification) (confbot.Repo, error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return nil, fmt.Errorf("must supply REDIS_URL env var")
+	}
+
+	repo, err := confbot.NewRepo(ctx, redisURL, spec.Env)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create repo: %v", err)
+	}
+
+	return repo, nil
+}
+
+// configure sets the package settings used to render hostnames and user
+// data, the same way confbot does.
+func configure(spec Specification) error {
+	confbot.DropletDomain = spec.DropletDomain
+	confbot.WebhookURL = spec.WebhookURL
+
+	workshop := confbot.DefaultWorkshop()
+	if spec.WorkshopConfig != "" {
+		w, err := confbot.LoadWorkshop(spec.WorkshopConfig)
+		if err != nil {
+			return err
+		}
+		workshop = w
+	}
+
+	if len(spec.MasterKeys) > 0 {
+		workshop.MasterKeys = spec.MasterKeys
+	}
+	confbot.CurrentWorkshop = workshop
+
+	return nil
+}
+
+// findCommand matches the longest command name at the start of args.
+func findCommand(args []string) (command, []string, bool) {
+	var found command
+	var n int
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(words) > len(args) || len(words) <= n {
+			continue
+		}
+
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			found, n = cmd, len(words)
+		}
+	}
+
+	return found, args[n:], n > 0
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "usage: confbotctl [flags] <command> [args]\n\ncommands:\n")
+
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = strings.TrimSpace(cmd.name + " " + cmd.args)
+	}
+
+	width := 0
+	for _, n := range names {
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+
+	for i, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-*s  %s\n", width, names[i], cmd.usage)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flags.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "confbotctl: %v\n", err)
+	os.Exit(1)
+}
+
+// requireArgs returns an error unless there are n args.
+func requireArgs(args []string, n int, names string) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %s", names)
+	}
+	return nil
+}
+
+// requireSetting returns an error if an environment setting is empty.
+func requireSetting(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("CONFBOT_%s is required for this command", strings.ToUpper(name))
+	}
+	return nil
+}
+
+// loadProject returns a project. It returns an error if the project doesn't
+// exist.
+func (c *ctl) loadProject(id string) (*confbot.Project, error) {
+	ids, err := c.repo.ProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, projectID := range ids {
+		if projectID == id {
+			return confbot.LoadProject(c.repo, id)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown project %s", id)
+}
+
+// eventContext returns a context whose event bus records project state and
+// history, like the bot's does, so operations run here show up in both.
+func (c *ctl) eventContext() context.Context {
+	events := confbot.NewEventBus(c.log)
+	events.Subscribe(confbot.ProjectStateRecorder(c.repo, c.log))
+	events.Subscribe(confbot.HistoryRecorder(c.repo, c.log))
+	events.Subscribe(func(ev confbot.Event) {
+		c.log.WithFields(logrus.Fields{
+			"event":      ev.Type,
+			"project-id": ev.ProjectID,
+			"step":       ev.Step,
+		}).Info("event")
+	})
+
+	return confbot.ContextWithEventBus(c.ctx, events)
+}
+
+func sortedProjects(projects []confbot.Project) []confbot.Project {
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].CreatedAt.Before(projects[j].CreatedAt) ||
+			(projects[i].CreatedAt.Equal(projects[j].CreatedAt) && projects[i].ID < projects[j].ID)
+	})
+	return projects
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// printer writes command output as a table or as JSON.
+type printer struct {
+	out    io.Writer
+	format string
+}
+
+// table prints v as JSON, or header and rows as a table.
+func (p *printer) table(v interface{}, header []string, rows [][]string) error {
+	if p.format == formatJSON {
+		return p.json(v)
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// fields prints v as JSON, or name and value pairs one per line.
+func (p *printer) fields(v interface{}, pairs [][2]string) error {
+	if p.format == formatJSON {
+		return p.json(v)
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	for _, kv := range pairs {
+		fmt.Fprintf(tw, "%s:\t%s\n", kv[0], kv[1])
+	}
+
+	return tw.Flush()
+}
+
+func (p *printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// orDash returns "-" for empty values, so table columns line up.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
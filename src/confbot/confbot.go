@@ -1,8 +1,11 @@
 package confbot
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -10,6 +13,11 @@ import (
 	"github.com/nlopes/slack"
 )
 
+var (
+	// Admins are the Slack user IDs of the workshop instructors.
+	Admins = []string{}
+)
+
 // Confbot is a conference workshop bot.
 type Confbot struct {
 	repo   Repo
@@ -18,6 +26,9 @@ type Confbot struct {
 
 	textActions   []textAction
 	validChannels []string
+
+	// connected is 1 while the RTM connection is up.
+	connected int32
 }
 
 // New creates an instance of Confbot.
@@ -33,8 +44,6 @@ func New(ctx context.Context, s *slack.Client, repo Repo) *Confbot {
 
 // Listen listens for slack events.
 func (c *Confbot) Listen() {
-	log := logFromContext(c.ctx)
-
 	c.client.SetDebug(false)
 	rtm := c.client.NewRTM()
 	go rtm.ManageConnection()
@@ -43,21 +52,22 @@ func (c *Confbot) Listen() {
 		select {
 		case msg := <-rtm.IncomingEvents:
 			switch ev := msg.Data.(type) {
+			case *slack.ConnectedEvent:
+				atomic.StoreInt32(&c.connected, 1)
+				slackConnected.Set(1)
+				slackConnectionEvents.Inc("connected")
+			case *slack.DisconnectedEvent:
+				atomic.StoreInt32(&c.connected, 0)
+				slackConnected.Set(0)
+				slackConnectionEvents.Inc("disconnected")
+			case *slack.ConnectionErrorEvent:
+				slackConnectionEvents.Inc("connection_error")
+			case *slack.InvalidAuthEvent:
+				atomic.StoreInt32(&c.connected, 0)
+				slackConnected.Set(0)
+				slackConnectionEvents.Inc("invalid_auth")
 			case *slack.MessageEvent:
-				log.WithField("raw-event", fmt.Sprintf("%#v", ev)).Info("incoming message")
-
-				go func() {
-					for _, ta := range c.textActions {
-						matches := ta.re.FindAllStringSubmatch(ev.Text, -1)
-						if len(matches) > 0 {
-							if err := ta.fn(c.ctx, ev, c.client, matches); err != nil {
-								log.WithError(err).
-									WithField("action", ev.Text).
-									Error("could not run action")
-							}
-						}
-					}
-				}()
+				c.HandleMessage(ev)
 
 			case *slack.RTMError:
 				fmt.Printf("Error: %s\n", ev.Error())
@@ -67,32 +77,148 @@ func (c *Confbot) Listen() {
 
 }
 
+// HandleMessage runs the actions whose trigger matches a message in the
+// background. Listen calls it for each message from Slack.
+func (c *Confbot) HandleMessage(ev *slack.MessageEvent) {
+	ctx := ContextWithRequestID(c.ctx, NewRequestID())
+	log := LogFromContext(ctx)
+	log.WithField("raw-event", fmt.Sprintf("%#v", ev)).Info("incoming message")
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(logrus.Fields{
+					"action": ev.Text,
+					"panic":  fmt.Sprintf("%v", r),
+				}).Error("action panicked")
+			}
+		}()
+
+		for _, ta := range c.textActions {
+			matches := ta.re.FindAllStringSubmatch(ev.Text, -1)
+			if len(matches) > 0 {
+				c.runAction(ctx, ta, ev, matches, "")
+			}
+		}
+	}()
+}
+
+// runAction runs an action and records it in the audit log. ctx carries the
+// command's request ID. runBy is set when the action is run on the user's
+// behalf. The user's language is looked up once for the whole action.
+func (c *Confbot) runAction(ctx context.Context, ta textAction, ev *slack.MessageEvent, matches [][]string, runBy string) {
+	ctx = ContextWithUserLocale(ctx, c.repo, ev.User)
+	log := LogFromContext(ctx)
+
+	start := time.Now()
+	err := ta.fn(ctx, ev, c.client, matches)
+	commandDuration.Observe(time.Since(start).Seconds(), ta.name)
+	commandsTotal.Inc(ta.name, outcome(err))
+
+	audit(c.repo, log, newAuditEntry(ctx, ta.name, runBy, ev, matches, start, err))
+
+	if err != nil {
+		log.WithError(err).
+			WithField("action", ev.Text).
+			Error("could not run action")
+	}
+}
+
+// RunAction runs an action on behalf of a user, as if they had sent text to
+// the bot. The action runs in the background. runBy identifies who ran it in
+// the audit log. The action uses ctx's request ID, or a new one if ctx
+// doesn't have one.
+func (c *Confbot) RunAction(ctx context.Context, name, userID, text, runBy string) error {
+	for _, ta := range c.textActions {
+		if ta.name != name {
+			continue
+		}
+
+		matches := ta.re.FindAllStringSubmatch(text, -1)
+		if len(matches) == 0 {
+			return fmt.Errorf("%q doesn't match action %s", text, name)
+		}
+
+		ev := &slack.MessageEvent{}
+		ev.User = userID
+		ev.Name = userID
+		ev.Text = text
+
+		requestID := RequestIDFromContext(ctx)
+		if requestID == "" {
+			requestID = NewRequestID()
+		}
+		actionCtx := ContextWithRequestID(c.ctx, requestID)
+		log := LogFromContext(actionCtx)
+
+		log.WithFields(logrus.Fields{
+			"action":  name,
+			"user-id": userID,
+		}).Info("running action for user")
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.WithFields(logrus.Fields{
+						"action": name,
+						"panic":  fmt.Sprintf("%v", r),
+					}).Error("action panicked")
+				}
+			}()
+
+			c.runAction(actionCtx, ta, ev, matches, runBy)
+		}()
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown action %s", name)
+}
+
+// CheckRTM returns an error if the Slack RTM connection is down.
+func (c *Confbot) CheckRTM() error {
+	if atomic.LoadInt32(&c.connected) != 1 {
+		return errors.New("slack rtm is not connected")
+	}
+
+	return nil
+}
+
+// CheckAuth returns an error if the bot can't authenticate with Slack.
+func (c *Confbot) CheckAuth() error {
+	_, err := c.client.AuthTest()
+	return err
+}
+
 // ActionFn is an action func.
 type ActionFn func(context.Context, *slack.MessageEvent, *slack.Client, [][]string) error
 
 type textAction struct {
-	re *regexp.Regexp
-	fn ActionFn
+	name string
+	re   *regexp.Regexp
+	fn   ActionFn
 }
 
-// AddTextAction adds a TextAction to the bot.
-func (c *Confbot) AddTextAction(trigger string, fn ActionFn) error {
+// AddTextAction adds a TextAction to the bot. name identifies the action in
+// logs and metrics.
+func (c *Confbot) AddTextAction(name, trigger string, fn ActionFn) error {
 	re, err := regexp.Compile(trigger)
 	if err != nil {
 		return err
 	}
 
-	log := logFromContext(c.ctx)
+	log := LogFromContext(c.ctx)
 	log.WithFields(logrus.Fields{
+		"action":  name,
 		"trigger": trigger,
 	}).Info("adding text action to bot")
 
-	c.textActions = append(c.textActions, textAction{re: re, fn: fn})
+	c.textActions = append(c.textActions, textAction{name: name, re: re, fn: fn})
 	return nil
 }
 
-func logFromContext(ctx context.Context) *logrus.Entry {
-	return ctx.Value("log").(*logrus.Entry)
+func isAdmin(userID string) bool {
+	return any(Admins, func(s string) bool { return s == userID })
 }
 
 func any(vs []string, f func(string) bool) bool {
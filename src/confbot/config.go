@@ -0,0 +1,34 @@
+package confbot
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FieldErr is returned when a setting in a config file is invalid.
+type FieldErr struct {
+	Field  string
+	Reason string
+}
+
+var _ error = (*FieldErr)(nil)
+
+func (e *FieldErr) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// DecodeConfig decodes a TOML document into v. Keys which don't match a
+// field of v are an error, so misspelt settings aren't silently ignored.
+func DecodeConfig(data []byte, v interface{}) (toml.MetaData, error) {
+	md, err := toml.Decode(string(data), v)
+	if err != nil {
+		return md, err
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return md, &FieldErr{Field: undecoded[0].String(), Reason: "unknown key"}
+	}
+
+	return md, nil
+}
@@ -1,11 +1,9 @@
 package confbot
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/nlopes/slack"
@@ -21,7 +19,7 @@ func CreateConfigureSSHAction(ctx context.Context, repo Repo) ActionFn {
 
 		userID := m.User
 
-		log := logFromContext(ctx).WithField("user-id", userID)
+		log := LogFromContext(ctx).WithField("user-id", userID)
 
 		_, _, channelID, err := slackClient.OpenIMChannel(userID)
 		if err != nil {
@@ -38,120 +36,123 @@ func CreateConfigureSSHAction(ctx context.Context, repo Repo) ActionFn {
 			return err
 		}
 
+		keyName := keyFileName(privKey)
+		host := fmt.Sprintf("shell.%s.%s", projectID, DropletDomain)
+
+		t := userMessages(ctx, repo, log, userID)
+		vars := MessageVars{"ProjectID": projectID, "Host": host, "Key": keyName}
+
+		var msg string
+
 		subject := matches[0][1]
 		switch subject {
-		case "windows":
-			if err := uploadPPK(log, slackClient, channelID, privKey); err != nil {
+		case "windows", "putty2":
+			version := 3
+			if subject == "putty2" {
+				version = 2
+			}
+
+			if err := deliverPPK(ctx, log, repo, slackClient, channelID, userID, projectID, privKey, version); err != nil {
+				params := slack.NewPostMessageParameters()
+				slackClient.PostMessage(channelID, t("ssh_ppk_failed", MessageVars{"Error": err.Error()}), params)
 				return err
 			}
 
-			msg := fmt.Sprintf("Download the Putty key file oscon2016.ppk. "+
-				"Launch Putty and enter `shell.%s.%s` as your "+
-				"Host Name. Next, navigate to the SSH / Auth Category, and "+
-				"browse for your oscon2016.ppk in the `Private key file for authentication` "+
-				"text box. Afterwards, click open, and enter `workshop` as your user name.",
-				projectID, DropletDomain)
+			msg = t("ssh_windows", MessageVars{"Host": host, "PPKFile": CurrentWorkshop.PPKFileName})
 
-			params := slack.NewPostMessageParameters()
-			slackClient.PostMessage(channelID, msg, params)
+			if version == 3 {
+				msg += t("ssh_windows_putty2", nil)
+			}
 
-		case "mac":
-			if err := uploadPrivateKey(log, slackClient, channelID, privKey); err != nil {
+		case "windows-openssh":
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, keyName, privKey); err != nil {
 				return err
 			}
 
-			msg := fmt.Sprintf("Download the SSH private key id_rsa. Most likely, "+
-				"id_rsa will be download to $HOME/Downloads. In your terminal, "+
-				"run `chmod 600 id_rsa`. You can SSH to your shell Droplet by running "+
-				"`ssh -i id_rsa workshop@shell.%s.%s`.",
-				projectID, DropletDomain)
+			msg = t("ssh_windows_openssh", vars)
+
+		case "mac":
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, keyName, privKey); err != nil {
+				return err
+			}
 
-			params := slack.NewPostMessageParameters()
-			slackClient.PostMessage(channelID, msg, params)
+			msg = t("ssh_mac", vars)
 
 		case "linux":
-			if err := uploadPrivateKey(log, slackClient, channelID, privKey); err != nil {
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, keyName, privKey); err != nil {
 				return err
 			}
 
-			msg := fmt.Sprintf("Download the SSH private key id_rsa. In your terminal, "+
-				"navigate to your download folder and run `chmod 600 id_rsa`. "+
-				"You can SSH to your shell Droplet by running "+
-				"`ssh -i id_rsa workshop@shell.%s.%s`. ",
-				projectID, DropletDomain)
+			msg = t("ssh_linux", vars)
+
+		case "config":
+			identity := fmt.Sprintf("confbot_%s", projectID)
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, identity, privKey); err != nil {
+				return err
+			}
+
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, "confbot.config", sshConfigSnippet(projectID, identity)); err != nil {
+				return err
+			}
+
+			msg = t("ssh_config", MessageVars{"ProjectID": projectID, "Key": identity, "Alias": sshHostAlias(projectID)})
+
+		case "agent":
+			if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, keyName, privKey); err != nil {
+				return err
+			}
 
-			params := slack.NewPostMessageParameters()
-			slackClient.PostMessage(channelID, msg, params)
+			msg = t("ssh_agent", vars)
 
 		default:
-			params := slack.NewPostMessageParameters()
-			msg := fmt.Sprintf("I don't know how to configure ssh for *%s*, but I bet it's very similar to linux. Try `./configure ssh linux`", subject)
-			slackClient.PostMessage(channelID, msg, params)
+			msg = t("ssh_unknown", MessageVars{"Subject": subject})
 		}
 
+		params := slack.NewPostMessageParameters()
+		slackClient.PostMessage(channelID, msg, params)
+
 		return nil
 	}
 }
 
-func uploadPrivateKey(log *logrus.Entry, slackClient *slack.Client, channelID string, privKey []byte) error {
-	uploadParams := slack.FileUploadParameters{
-		Title:    "id_rsa",
-		Filename: "id_rsa",
-		Content:  string(privKey),
-		Channels: []string{channelID},
-	}
-
-	if _, err := slackClient.UploadFile(uploadParams); err != nil {
-		log.WithError(err).
-			WithFields(logrus.Fields{
-				"filename": "id_rsa"}).
-			Error("key upload failed")
-
-		return err
+// keyFileName returns the conventional OpenSSH file name for a private key.
+func keyFileName(privKey []byte) string {
+	if bytes.Contains(privKey, []byte("EC PRIVATE KEY")) {
+		return "id_ecdsa"
 	}
 
-	return nil
+	return "id_rsa"
 }
 
-func uploadPPK(log *logrus.Entry, slackClient *slack.Client, channelID string, privKey []byte) error {
-	dir, err := ioutil.TempDir("", channelID)
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(dir)
-
-	privKeyFilePath := filepath.Join(dir, "id_rsa")
-	ppkFilePath := filepath.Join(dir, "oscon2016.ppk")
+func sshHostAlias(projectID string) string {
+	return fmt.Sprintf("workshop-%s", projectID)
+}
 
-	if err := ioutil.WriteFile(privKeyFilePath, privKey, 0600); err != nil {
-		return err
+// sshConfigSnippet creates an OpenSSH client config for the hosts of a project.
+func sshConfigSnippet(projectID, identity string) []byte {
+	lines := []string{
+		fmt.Sprintf("Host %s", sshHostAlias(projectID)),
+		fmt.Sprintf("    HostName shell.%s.%s", projectID, DropletDomain),
+		"    User workshop",
+		fmt.Sprintf("    IdentityFile ~/.ssh/%s", identity),
+		"    IdentitiesOnly yes",
+		"",
+		fmt.Sprintf("Host *.%s.%s", projectID, DropletDomain),
+		"    User workshop",
+		fmt.Sprintf("    IdentityFile ~/.ssh/%s", identity),
+		"    IdentitiesOnly yes",
+		"",
 	}
 
-	cmd := exec.Command("puttygen", privKeyFilePath, "-o", ppkFilePath)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+	return []byte(strings.Join(lines, "\n"))
+}
 
-	b, err := ioutil.ReadFile(ppkFilePath)
+func deliverPPK(ctx context.Context, log *logrus.Entry, repo Repo, slackClient *slack.Client, channelID, userID, projectID string, privKey []byte, version int) error {
+	b, err := encodePPK(privKey, version, fmt.Sprintf("workshop@%s", projectID))
 	if err != nil {
+		log.WithError(err).Error("unable to encode ppk")
 		return err
 	}
 
-	uploadParams := slack.FileUploadParameters{
-		Title:    "oscon2016.ppk",
-		Filename: "oscon2016.ppk",
-		Content:  string(b),
-		Channels: []string{channelID},
-	}
-
-	if _, err := slackClient.UploadFile(uploadParams); err != nil {
-		log.WithError(err).
-			WithFields(logrus.Fields{
-				"filename": "oscon2016.ppk"}).
-			Error("ppk upload failed")
-
-		return err
-	}
-
-	return nil
+	return deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, CurrentWorkshop.PPKFileName, b)
 }
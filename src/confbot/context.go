@@ -0,0 +1,76 @@
+package confbot
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+// RequestIDHeader is the HTTP header which carries a request ID.
+const RequestIDHeader = "X-Request-Id"
+
+type contextKey int
+
+const (
+	logKey contextKey = iota
+	eventsKey
+	requestIDKey
+	localeKey
+)
+
+// userLocale is the language a user has chosen.
+type userLocale struct {
+	userID string
+	locale string
+}
+
+// ContextWithLog returns a context which carries a logger.
+func ContextWithLog(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, logKey, log)
+}
+
+// LogFromContext returns the context's logger.
+func LogFromContext(ctx context.Context) *logrus.Entry {
+	return ctx.Value(logKey).(*logrus.Entry)
+}
+
+// NewRequestID creates an ID for a command or webhook.
+func NewRequestID() string {
+	return uuid.NewV4().String()
+}
+
+// ContextWithRequestID returns a context which carries a request ID. Log
+// entries and events from the context's logger and event bus include it.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return ContextWithLog(ctx, LogFromContext(ctx).WithField("request-id", id))
+}
+
+// ContextWithLocale returns a context which carries the language userID
+// has chosen, so messages and events for them don't have to look it up.
+func ContextWithLocale(ctx context.Context, userID, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, userLocale{userID: userID, locale: locale})
+}
+
+// ContextWithUserLocale looks up the language userID has chosen, and
+// returns a context which carries it.
+func ContextWithUserLocale(ctx context.Context, repo Repo, userID string) context.Context {
+	return ContextWithLocale(ctx, userID, lookupLocale(repo, LogFromContext(ctx), userID))
+}
+
+// localeFromContext returns the language carried by the context for userID.
+func localeFromContext(ctx context.Context, userID string) (string, bool) {
+	ul, ok := ctx.Value(localeKey).(userLocale)
+	if !ok || ul.userID != userID {
+		return "", false
+	}
+
+	return ul.locale, true
+}
+
+// RequestIDFromContext returns the context's request ID, or an empty string
+// if it doesn't have one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/digitalocean/godo"
 	"github.com/nlopes/slack"
 
@@ -11,12 +12,11 @@ import (
 )
 
 // CreateDeleteAction returns a function that deletes a project.
-func CreateDeleteAction(ctx context.Context, masterClientToken string, repo Repo) ActionFn {
+func CreateDeleteAction(ctx context.Context, masterClientToken string, tokenPool *TokenPool, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
-		var err error
 		userID := m.User
 
-		log := logFromContext(ctx).WithField("user-id", userID)
+		log := LogFromContext(ctx).WithField("user-id", userID)
 
 		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
 		if err != nil {
@@ -30,69 +30,79 @@ func CreateDeleteAction(ctx context.Context, masterClientToken string, repo Repo
 
 		params := slack.PostMessageParameters{}
 
-		defer func() {
-			if err != nil {
-				log.WithError(err).Error("unable to delete project")
-				params = slack.PostMessageParameters{}
-				slackClient.PostMessage(channelID, fmt.Sprintf("unable to delete project _%s_", projectID), params)
-			}
-		}()
-
 		if len(projectID) == 0 {
-			slackClient.PostMessage(channelID, "No project ID, so there is nothing to delete.", params)
+			slackClient.PostMessage(channelID, userMessages(ctx, repo, log, userID)("delete_no_project", nil), params)
 			return fmt.Errorf("No project ID, so there is nothing to delete.")
 		}
 
-		if _, _, err = slackClient.PostMessage(channelID, fmt.Sprintf("Deleting project _%s_ and it's associated resources", projectID), params); err != nil {
-			return err
-		}
+		return DeleteProject(ctx, repo, tokenPool, masterClientToken, projectID, userID)
+	}
+}
 
-		doToken, err := repo.Token(projectID)
-		if err != nil {
-			return err
-		}
+// DeleteProject deletes a project's DNS records, SSH keys and droplets, and
+// removes it from the repo. Its progress is published on ctx's event bus.
+// The clients come from tokenPool, which can be nil outside of the bot.
+func DeleteProject(ctx context.Context, repo Repo, tokenPool *TokenPool, masterToken, projectID, userID string) (err error) {
+	log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID, "project-id": projectID})
+	events := EventBusFromContext(ctx)
 
-		client := buildDoClient(doToken)
-		masterClient := buildDoClient(masterClientToken)
+	base := Event{ProjectID: projectID, UserID: userID, Operation: operationDelete}
 
-		if _, _, err = slackClient.PostMessage(channelID, "*... Deleting DNS records*", params); err != nil {
-			return err
-		}
-
-		if err = deleteRecords(masterClient, projectID, DropletDomain); err != nil {
-			return err
-		}
-		if _, _, err = slackClient.PostMessage(channelID, "*... Deleting SSH Keys*", params); err != nil {
-			return err
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("unable to delete project")
+			ev := base
+			ev.Type = EventDeleteFailed
+			ev.Error = err.Error()
+			events.Publish(ev)
 		}
+	}()
 
-		if err = deleteKeys(client, projectID); err != nil {
-			return err
-		}
+	ev := base
+	ev.Type = EventDeleteStarted
+	events.Publish(ev)
 
-		if _, _, err = slackClient.PostMessage(channelID, "*... Deleting Droplets*", params); err != nil {
-			return err
-		}
+	doToken, err := repo.Token(projectID)
+	if err != nil {
+		return err
+	}
 
-		if err = deleteDroplets(client, projectID); err != nil {
-			return err
-		}
+	client := tokenPool.Client(doToken)
+	masterClient := tokenPool.Client(masterToken)
 
-		if _, _, err = slackClient.PostMessage(channelID, fmt.Sprintf("*... Resetting project for _%s_ *", m.Name), params); err != nil {
-			return err
-		}
+	err = events.Step(base, "dns_records", func() error {
+		return deleteRecords(masterClient, projectID, DropletDomain)
+	})
+	if err != nil {
+		return err
+	}
 
-		if err = repo.ResetProject(userID); err != nil {
-			return err
-		}
+	err = events.Step(base, "ssh_keys", func() error {
+		return deleteKeys(client, projectID)
+	})
+	if err != nil {
+		return err
+	}
 
-		params = slack.PostMessageParameters{}
-		if _, _, err = slackClient.PostMessage(channelID, fmt.Sprintf("Project _%s_ has been deleted. Send command `./boot shell` to start a new project.", projectID), params); err != nil {
-			return err
-		}
+	err = events.Step(base, "droplets", func() error {
+		return deleteDroplets(client, projectID)
+	})
+	if err != nil {
+		return err
+	}
 
-		return nil
+	err = events.Step(base, "reset", func() error {
+		return repo.ResetProject(userID)
+	})
+	if err != nil {
+		return err
 	}
+
+	ev = base
+	ev.Type = EventProjectDeleted
+	events.Publish(ev)
+
+	return nil
 }
 
 func deleteRecords(client *godo.Client, projectID, domain string) error {
@@ -148,7 +158,7 @@ func deleteKeys(client *godo.Client, projectID string) error {
 	}
 
 	for _, key := range keys {
-		if key.Name == fmt.Sprintf("oscon-%s", projectID) {
+		if key.Name == CurrentWorkshop.KeyName(projectID) {
 			_, err := client.Keys.DeleteByID(key.ID)
 			if err != nil {
 				return err
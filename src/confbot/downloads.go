@@ -0,0 +1,217 @@
+package confbot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Download audit events.
+	DownloadCreated    = "created"
+	DownloadDownloaded = "downloaded"
+	DownloadRejected   = "rejected"
+)
+
+var (
+	// PublicURL is the URL the bot's API can be reached at.
+	PublicURL = ""
+
+	// DownloadSecret signs download links.
+	DownloadSecret = ""
+
+	// DownloadTTL is how long a download link is valid.
+	DownloadTTL = 15 * time.Minute
+
+	// maxDownloadAudits is the amount of download audit events kept.
+	maxDownloadAudits = 1000
+
+	// ErrDownloadInvalid is returned when a download link has been tampered with,
+	// has expired, or has already been used.
+	ErrDownloadInvalid = errors.New("download link is invalid, expired or has already been used")
+)
+
+// downloadRejectedErr is returned when a download link can't be used.
+type downloadRejectedErr struct {
+	reason string
+}
+
+var _ error = (*downloadRejectedErr)(nil)
+
+func (e *downloadRejectedErr) Error() string {
+	return e.reason
+}
+
+// Download is a credential which can be downloaded once.
+type Download struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	ProjectID string    `json:"project_id"`
+	Filename  string    `json:"filename"`
+	Content   []byte    `json:"content"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DownloadAudit is an entry in the download audit trail.
+type DownloadAudit struct {
+	DownloadID string    `json:"download_id"`
+	Event      string    `json:"event"`
+	UserID     string    `json:"user_id,omitempty"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// CreateDownload stores content so it can be downloaded once, and returns
+// a signed URL for it.
+func CreateDownload(repo Repo, userID, projectID, filename string, content []byte) (string, error) {
+	if DownloadSecret == "" {
+		return "", errors.New("download secret is not configured")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	d := Download{
+		ID:        hex.EncodeToString(b),
+		UserID:    userID,
+		ProjectID: projectID,
+		Filename:  filename,
+		Content:   content,
+		ExpiresAt: time.Now().Add(DownloadTTL),
+	}
+
+	if err := repo.SaveDownload(d); err != nil {
+		return "", err
+	}
+
+	audit := DownloadAudit{
+		DownloadID: d.ID,
+		Event:      DownloadCreated,
+		UserID:     userID,
+		ProjectID:  projectID,
+		Filename:   filename,
+		At:         time.Now(),
+	}
+
+	if err := repo.AddDownloadAudit(audit); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(d.ExpiresAt.Unix(), 10)
+
+	v := url.Values{}
+	v.Set("expires", expires)
+	v.Set("sig", signDownload(d.ID, expires))
+
+	return fmt.Sprintf("%s/download/%s?%s", PublicURL, d.ID, v.Encode()), nil
+}
+
+// TakeDownload verifies a download link and returns the download. The download
+// is removed, so the link can't be used again. Downloads and rejected links
+// are audited. Links which can't be used return ErrDownloadInvalid.
+func TakeDownload(repo Repo, id, expires, sig, remote, userAgent string) (*Download, error) {
+	audit := DownloadAudit{
+		DownloadID: id,
+		Remote:     remote,
+		UserAgent:  userAgent,
+		At:         time.Now(),
+	}
+
+	d, err := takeDownload(repo, id, expires, sig)
+	if _, ok := err.(*downloadRejectedErr); ok {
+		audit.Event = DownloadRejected
+		audit.Reason = err.Error()
+		if aerr := repo.AddDownloadAudit(audit); aerr != nil {
+			return nil, aerr
+		}
+		return nil, ErrDownloadInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Event = DownloadDownloaded
+	audit.UserID = d.UserID
+	audit.ProjectID = d.ProjectID
+	audit.Filename = d.Filename
+	if err := repo.AddDownloadAudit(audit); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func takeDownload(repo Repo, id, expires, sig string) (*Download, error) {
+	if DownloadSecret == "" {
+		return nil, errors.New("download secret is not configured")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signDownload(id, expires))) {
+		return nil, &downloadRejectedErr{reason: "invalid signature"}
+	}
+
+	ts, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, &downloadRejectedErr{reason: "invalid expiry"}
+	}
+
+	if time.Now().After(time.Unix(ts, 0)) {
+		return nil, &downloadRejectedErr{reason: "expired"}
+	}
+
+	d, err := repo.TakeDownload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		return nil, &downloadRejectedErr{reason: "already downloaded"}
+	}
+
+	return d, nil
+}
+
+func signDownload(id, expires string) string {
+	mac := hmac.New(sha256.New, []byte(DownloadSecret))
+	mac.Write([]byte(id + "." + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverFile sends a one time download link for a file to a channel.
+func deliverFile(ctx context.Context, log *logrus.Entry, repo Repo, slackClient *slack.Client, channelID, userID, projectID, name string, content []byte) error {
+	link, err := CreateDownload(repo, userID, projectID, name, content)
+	if err != nil {
+		log.WithError(err).
+			WithFields(logrus.Fields{
+				"filename": name}).
+			Error("unable to create download")
+
+		return err
+	}
+
+	msg := userMessages(ctx, repo, log, userID)("download_link", MessageVars{"Name": name, "URL": link, "TTL": DownloadTTL})
+	params := slack.NewPostMessageParameters()
+	params.UnfurlLinks = false
+	params.UnfurlMedia = false
+	if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package confbot
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// downloadRepo fails to take downloads and records audits. Other Repo methods
+// panic.
+type downloadRepo struct {
+	Repo
+
+	takeErr error
+	audits  []DownloadAudit
+}
+
+func (r *downloadRepo) TakeDownload(id string) (*Download, error) {
+	return nil, r.takeErr
+}
+
+func (r *downloadRepo) AddDownloadAudit(a DownloadAudit) error {
+	r.audits = append(r.audits, a)
+	return nil
+}
+
+func TestTakeDownloadStorageError(t *testing.T) {
+	defer func(secret string) { DownloadSecret = secret }(DownloadSecret)
+	DownloadSecret = "secret"
+
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	sig := signDownload("id", expires)
+
+	storageErr := errors.New("connection refused")
+	repo := &downloadRepo{takeErr: storageErr}
+	if _, err := TakeDownload(repo, "id", expires, sig, "remote", "agent"); err != storageErr {
+		t.Fatalf("TakeDownload() = %v, want %v", err, storageErr)
+	}
+	if len(repo.audits) != 0 {
+		t.Fatalf("audited %v, want nothing", repo.audits)
+	}
+
+	repo = &downloadRepo{}
+	if _, err := TakeDownload(repo, "id", expires, sig, "remote", "agent"); err != ErrDownloadInvalid {
+		t.Fatalf("TakeDownload() of a used link = %v, want %v", err, ErrDownloadInvalid)
+	}
+	if len(repo.audits) != 1 || repo.audits[0].Event != DownloadRejected {
+		t.Fatalf("audited %v, want a rejection", repo.audits)
+	}
+}
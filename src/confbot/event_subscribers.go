@@ -0,0 +1,180 @@
+package confbot
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+// stepMessages are sent to the project's owner when a step starts, and
+// when it finishes. They are keyed by operation and step, and name messages
+// in the catalog.
+var stepMessages = map[string]struct {
+	started  string
+	finished string
+}{
+	"provision/infraState":       {started: "step_infra_started", finished: "step_infra_finished"},
+	"provision/certState":        {started: "step_cert_started"},
+	"provision/ansibleState":     {started: "step_ansible_started", finished: "step_ansible_finished"},
+	"provision/esState":          {started: "step_es_started", finished: "step_es_finished"},
+	"provision/esTemplatesState": {started: "step_es_templates_started", finished: "step_es_templates_finished"},
+	"delete/dns_records":         {started: "step_dns_records_started"},
+	"delete/ssh_keys":            {started: "step_ssh_keys_started"},
+	"delete/droplets":            {started: "step_droplets_started"},
+	"delete/reset":               {started: "step_reset_started"},
+}
+
+// SlackNotifier sends project events to the project's owner, in the
+// language they have chosen.
+func SlackNotifier(slackClient *slack.Client, repo Repo, log *logrus.Entry) EventHandler {
+	return func(ev Event) {
+		key, vars := slackMessage(ev)
+		if key == "" || ev.UserID == "" {
+			return
+		}
+
+		log := log.WithFields(logrus.Fields{
+			"event":   ev.Type,
+			"user-id": ev.UserID,
+		})
+
+		locale := ev.Locale
+		if locale == "" {
+			locale = lookupLocale(repo, log, ev.UserID)
+		}
+		msg := localeMessages(log, locale)(key, vars)
+
+		_, _, channelID, err := slackClient.OpenIMChannel(ev.UserID)
+		if err != nil {
+			log.WithError(err).Error("unable to open channel for notification")
+			return
+		}
+
+		params := slack.NewPostMessageParameters()
+		if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
+			log.WithError(err).Error("unable to send notification")
+		}
+	}
+}
+
+// slackMessage returns the message for an event and its variables, or ""
+// if the event isn't sent to the project's owner.
+func slackMessage(ev Event) (string, MessageVars) {
+	vars := MessageVars{"ProjectID": ev.ProjectID, "Error": ev.Error}
+
+	switch ev.Type {
+	case EventProjectRegistered:
+		return "boot_started", vars
+	case EventBootFailed:
+		if ev.Data["cleanup"] == "pending" {
+			return "boot_failed_cleanup", vars
+		}
+		return "boot_failed", vars
+	case EventWebhookReceived:
+		if ev.Data["type"] == "install_complete" {
+			return "install_complete", vars
+		}
+	case EventProvisionStarted:
+		return "provision_started", vars
+	case EventProvisionFailed:
+		return "provision_failed", vars
+	case EventProvisionFinished:
+		return "provision_complete", vars
+	case EventStepStarted:
+		return stepMessages[ev.Operation+"/"+ev.Step].started, vars
+	case EventStepFinished:
+		return stepMessages[ev.Operation+"/"+ev.Step].finished, vars
+	case EventDeleteStarted:
+		return "delete_started", vars
+	case EventProjectDeleted:
+		return "delete_finished", vars
+	case EventDeleteFailed:
+		return "delete_failed", vars
+	}
+
+	return "", nil
+}
+
+// RegionUsageRecorder counts the shells booted in each region, for the
+// spread region policy.
+func RegionUsageRecorder(rs *RegionSelector) EventHandler {
+	return func(ev Event) {
+		switch ev.Type {
+		case EventBootFinished:
+			rs.Used(ev.ProjectID, ev.Data["region"])
+		case EventProjectDeleted:
+			rs.Released(ev.ProjectID)
+		}
+	}
+}
+
+// TokenUsageRecorder stops counting projects against their token once their
+// droplets have been removed.
+func TokenUsageRecorder(tp *TokenPool) EventHandler {
+	return func(ev Event) {
+		switch ev.Type {
+		case EventBootFailed, EventProjectDeleted:
+			tp.Unassign(ev.ProjectID)
+		}
+	}
+}
+
+// MetricsRecorder records step durations and operation outcomes.
+func MetricsRecorder() EventHandler {
+	return func(ev Event) {
+		switch ev.Type {
+		case EventStepFinished, EventStepFailed:
+			result := outcomeSuccess
+			if ev.Type == EventStepFailed {
+				result = outcomeFailure
+			}
+			operationStepDuration.Observe(ev.Duration.Seconds(), ev.Operation, ev.Step, result)
+		case EventBootFinished:
+			operationsTotal.Inc(operationBoot, outcomeSuccess)
+		case EventBootFailed:
+			operationsTotal.Inc(operationBoot, outcomeFailure)
+		case EventProvisionFinished:
+			operationsTotal.Inc(operationProvision, outcomeSuccess)
+		case EventProvisionFailed:
+			operationsTotal.Inc(operationProvision, outcomeFailure)
+		case EventProjectDeleted:
+			operationsTotal.Inc(operationDelete, outcomeSuccess)
+		case EventDeleteFailed:
+			operationsTotal.Inc(operationDelete, outcomeFailure)
+		}
+	}
+}
+
+// ProjectStateRecorder keeps the project state shown by the admin API and
+// dashboard up to date.
+func ProjectStateRecorder(repo Repo, log *logrus.Entry) EventHandler {
+	return func(ev Event) {
+		switch ev.Type {
+		case EventProjectRegistered:
+			updateProject(repo, log, ev.ProjectID, func(p *Project) {
+				p.UserID = ev.UserID
+				p.Status = ProjectBooting
+			})
+		case EventBootFinished:
+			updateProject(repo, log, ev.ProjectID, func(p *Project) {
+				p.Region = ev.Data["region"]
+				p.Status = ProjectInstalling
+			})
+		case EventBootFailed:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectFailed, operationBoot, ev.Error)
+		case EventProvisionStarted:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectProvisioning, "", "")
+		case EventStepStarted:
+			if ev.Operation == operationProvision {
+				setProjectStatus(repo, log, ev.ProjectID, ProjectProvisioning, ev.Step, "")
+			}
+		case EventProvisionFailed:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectFailed, ev.Step, ev.Error)
+		case EventProvisionFinished:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectReady, "", "")
+		case EventDeleteStarted:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectDeleting, "", "")
+		case EventDeleteFailed:
+			setProjectStatus(repo, log, ev.ProjectID, ProjectFailed, "", ev.Error)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package confbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// EventType is the type of a project event.
+type EventType string
+
+const (
+	EventProjectRegistered EventType = "project_registered"
+	EventDropletCreated    EventType = "droplet_created"
+	EventDNSRecordCreated  EventType = "dns_record_created"
+	EventBootFinished      EventType = "boot_finished"
+	EventBootFailed        EventType = "boot_failed"
+	EventWebhookReceived   EventType = "webhook_received"
+	EventProvisionStarted  EventType = "provision_started"
+	EventProvisionFinished EventType = "provision_finished"
+	EventProvisionFailed   EventType = "provision_failed"
+	EventStepStarted       EventType = "step_started"
+	EventStepFinished      EventType = "step_finished"
+	EventStepFailed        EventType = "step_failed"
+	EventDeleteStarted     EventType = "delete_started"
+	EventProjectDeleted    EventType = "project_deleted"
+	EventDeleteFailed      EventType = "delete_failed"
+)
+
+// Event is something that happened to a project.
+type Event struct {
+	Type      EventType `json:"type"`
+	ProjectID string    `json:"project_id"`
+	UserID    string    `json:"user_id,omitempty"`
+	// Operation is the operation the event is part of: boot, provision or delete.
+	Operation string `json:"operation,omitempty"`
+	Step      string `json:"step,omitempty"`
+	// Duration is how long a step took.
+	Duration time.Duration     `json:"duration,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+	// RequestID is the ID of the command or webhook which caused the event.
+	RequestID string `json:"request_id,omitempty"`
+	// Locale is the language of the event's user, if it is known.
+	Locale string    `json:"locale,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// EventHandler receives events.
+type EventHandler func(Event)
+
+// EventBus delivers project events to subscribers. Events are delivered in
+// the order they are published, and Publish returns after every subscriber
+// has received the event.
+type EventBus struct {
+	*subscriberList
+	log *logrus.Entry
+
+	// requestID is added to events which don't have one.
+	requestID string
+	// locale is added to the events of its user.
+	locale userLocale
+}
+
+type subscriberList struct {
+	mu          sync.RWMutex
+	subscribers []EventHandler
+}
+
+// NewEventBus creates an instance of EventBus.
+func NewEventBus(log *logrus.Entry) *EventBus {
+	return &EventBus{
+		subscriberList: &subscriberList{},
+		log:            log.WithField("component", "event-bus"),
+	}
+}
+
+// WithRequestID returns a bus which shares b's subscribers, and adds id to
+// the events published through it.
+func (b *EventBus) WithRequestID(id string) *EventBus {
+	return &EventBus{
+		subscriberList: b.subscriberList,
+		log:            b.log.WithField("request-id", id),
+		requestID:      id,
+		locale:         b.locale,
+	}
+}
+
+// withLocale returns a bus which shares b's subscribers, and adds a user's
+// language to their events.
+func (b *EventBus) withLocale(ul userLocale) *EventBus {
+	return &EventBus{
+		subscriberList: b.subscriberList,
+		log:            b.log,
+		requestID:      b.requestID,
+		locale:         ul,
+	}
+}
+
+// Subscribe adds a subscriber.
+func (b *EventBus) Subscribe(fn EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish sends an event to the subscribers. A subscriber which panics
+// doesn't stop the event from reaching the others.
+func (b *EventBus) Publish(ev Event) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	if ev.RequestID == "" {
+		ev.RequestID = b.requestID
+	}
+	if ev.Locale == "" && ev.UserID != "" && ev.UserID == b.locale.userID {
+		ev.Locale = b.locale.locale
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		b.deliver(fn, ev)
+	}
+}
+
+func (b *EventBus) deliver(fn EventHandler, ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.log.WithFields(logrus.Fields{
+				"event":      ev.Type,
+				"project-id": ev.ProjectID,
+				"panic":      fmt.Sprintf("%v", r),
+			}).Error("event subscriber panicked")
+		}
+	}()
+
+	fn(ev)
+}
+
+// Step runs a step of an operation, and publishes its start and outcome.
+// base supplies the project, user and operation for the events.
+func (b *EventBus) Step(base Event, step string, fn func() error) error {
+	ev := base
+	ev.Type = EventStepStarted
+	ev.Step = step
+	ev.At = time.Time{}
+	b.Publish(ev)
+
+	start := time.Now()
+	err := fn()
+
+	ev.Type = EventStepFinished
+	ev.Duration = time.Since(start)
+	ev.At = time.Time{}
+	if err != nil {
+		ev.Type = EventStepFailed
+		ev.Error = err.Error()
+	}
+	b.Publish(ev)
+
+	return err
+}
+
+// ContextWithEventBus returns a context which carries an event bus.
+func ContextWithEventBus(ctx context.Context, b *EventBus) context.Context {
+	return context.WithValue(ctx, eventsKey, b)
+}
+
+// EventBusFromContext returns the context's event bus. A bus without
+// subscribers is returned if there isn't one. Events published through the
+// bus carry the context's request ID and user's language.
+func EventBusFromContext(ctx context.Context) *EventBus {
+	b, ok := ctx.Value(eventsKey).(*EventBus)
+	if !ok {
+		b = NewEventBus(LogFromContext(ctx))
+	}
+
+	if id := RequestIDFromContext(ctx); id != "" {
+		b = b.WithRequestID(id)
+	}
+
+	if ul, ok := ctx.Value(localeKey).(userLocale); ok {
+		b = b.withLocale(ul)
+	}
+
+	return b
+}
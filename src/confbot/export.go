@@ -0,0 +1,209 @@
+package confbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// exportVersion is the version of the export format.
+const exportVersion = 1
+
+// ErrCAKeyMismatch is returned when an import has a different CA key than
+// the repo. Certificates issued by one key aren't trusted by droplets which
+// trust the other, so the import is refused.
+var ErrCAKeyMismatch = errors.New("the import has a different CA key than the repo")
+
+// Export is a snapshot of the bot's data, used to move it to another redis
+// or environment. It contains DigitalOcean tokens and private keys.
+type Export struct {
+	Version      int                 `json:"version"`
+	ExportedAt   time.Time           `json:"exported_at"`
+	Projects     []ExportedProject   `json:"projects"`
+	Cleanups     []Resource          `json:"cleanups"`
+	PoolTokens   []string            `json:"pool_tokens"`
+	CAKey        string              `json:"ca_key,omitempty"`
+	Certificates []IssuedCertificate `json:"certificates"`
+}
+
+// ExportedProject is a project and its secrets.
+type ExportedProject struct {
+	Project
+	Token         string  `json:"token"`
+	Key           string  `json:"key"`
+	WebhookSecret string  `json:"webhook_secret,omitempty"`
+	History       []Event `json:"history,omitempty"`
+}
+
+// ImportResult describes what an import did.
+type ImportResult struct {
+	Projects     int `json:"projects"`
+	Cleanups     int `json:"cleanups"`
+	PoolTokens   int `json:"pool_tokens"`
+	Certificates int `json:"certificates"`
+	// Skipped are projects whose owner already has a project.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// ExportData exports the repo's projects, cleanups, pool tokens and SSH
+// certificate authority.
+func ExportData(repo Repo) (*Export, error) {
+	e := &Export{
+		Version:    exportVersion,
+		ExportedAt: time.Now().UTC(),
+		Projects:   []ExportedProject{},
+	}
+
+	projects, err := ListProjects(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range projects {
+		ep := ExportedProject{Project: p}
+
+		if ep.Token, err = repo.Token(p.ID); err != nil {
+			return nil, err
+		}
+
+		key, err := repo.GetKey(p.ID)
+		if err != nil {
+			return nil, err
+		}
+		ep.Key = string(key)
+
+		if ep.WebhookSecret, err = repo.WebhookSecret(p.ID); err != nil {
+			return nil, err
+		}
+
+		if ep.History, err = repo.History(p.ID); err != nil {
+			return nil, err
+		}
+
+		e.Projects = append(e.Projects, ep)
+	}
+
+	sort.Slice(e.Projects, func(i, j int) bool { return e.Projects[i].ID < e.Projects[j].ID })
+
+	if e.Cleanups, err = repo.Cleanups(); err != nil {
+		return nil, err
+	}
+
+	if e.PoolTokens, err = repo.PoolTokens(); err != nil {
+		return nil, err
+	}
+
+	caKey, err := repo.CAKey()
+	if err != nil {
+		return nil, err
+	}
+	e.CAKey = string(caKey)
+
+	if e.Certificates, err = repo.Certificates(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// ImportData imports an export into the repo. Projects whose owner already
+// has a project are skipped, everything else is merged with the repo's data.
+func ImportData(repo Repo, e *Export) (*ImportResult, error) {
+	if e.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", e.Version)
+	}
+
+	if e.CAKey != "" {
+		caKey, err := repo.CAKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if caKey != nil && !bytes.Equal(caKey, []byte(e.CAKey)) {
+			return nil, ErrCAKeyMismatch
+		}
+	}
+
+	res := &ImportResult{}
+
+	for _, p := range e.Projects {
+		if err := repo.RegisterProject(p.ID, p.UserID, p.Token); err != nil {
+			if _, ok := err.(*ProjectExistsErr); ok {
+				res.Skipped = append(res.Skipped, p.ID)
+				continue
+			}
+			return res, err
+		}
+
+		if err := repo.SaveKey(p.ID, []byte(p.Key)); err != nil {
+			return res, err
+		}
+
+		if p.WebhookSecret != "" {
+			if err := repo.SaveWebhookSecret(p.ID, p.WebhookSecret); err != nil {
+				return res, err
+			}
+		}
+
+		if p.Status != ProjectUnknown {
+			if err := repo.SaveProject(p.Project); err != nil {
+				return res, err
+			}
+		}
+
+		for _, ev := range p.History {
+			if err := repo.AddHistory(ev); err != nil {
+				return res, err
+			}
+		}
+
+		res.Projects++
+	}
+
+	for _, r := range e.Cleanups {
+		if err := repo.AddCleanup(r); err != nil {
+			return res, err
+		}
+		res.Cleanups++
+	}
+
+	for _, t := range e.PoolTokens {
+		if err := repo.AddPoolToken(t); err != nil {
+			return res, err
+		}
+		res.PoolTokens++
+	}
+
+	if e.CAKey != "" {
+		if err := repo.SaveCAKey([]byte(e.CAKey)); err != nil {
+			return res, err
+		}
+	}
+
+	var maxSerial uint64
+	for _, c := range e.Certificates {
+		if err := repo.SaveCertificate(c); err != nil {
+			return res, err
+		}
+		if c.Serial > maxSerial {
+			maxSerial = c.Serial
+		}
+		res.Certificates++
+	}
+
+	// new certificates can't reuse the serials of imported ones, which
+	// would make revoking one revoke the other.
+	for maxSerial > 0 {
+		serial, err := repo.NextCertificateSerial()
+		if err != nil {
+			return res, err
+		}
+		if serial >= maxSerial {
+			break
+		}
+	}
+
+	return res, nil
+}
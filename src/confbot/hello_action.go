@@ -1,8 +1,6 @@
 package confbot
 
 import (
-	"fmt"
-
 	"github.com/Sirupsen/logrus"
 	"github.com/go-errors/errors"
 	"github.com/nlopes/slack"
@@ -13,7 +11,7 @@ import (
 // CreateHelloAction creates a hello action.
 func CreateHelloAction(ctx context.Context, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
-		log := logFromContext(ctx).WithFields(logrus.Fields{"user-id": m.User})
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": m.User})
 
 		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
 		if err != nil {
@@ -26,7 +24,9 @@ func CreateHelloAction(ctx context.Context, repo Repo) ActionFn {
 			log.WithError(err).Error("unable to fetch user info")
 		}
 
-		msg := fmt.Sprintf(helloResponse, user.Name, "confbot")
+		t := userMessages(ctx, repo, log, m.User)
+
+		msg := t("hello", MessageVars{"User": user.Name})
 		params := slack.NewPostMessageParameters()
 		if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
 			return err
@@ -39,11 +39,11 @@ func CreateHelloAction(ctx context.Context, repo Repo) ActionFn {
 
 		if id == "" {
 			params := slack.NewPostMessageParameters()
-			if _, _, err := slackClient.PostMessage(channelID, projecIsNotDefined, params); err != nil {
+			if _, _, err := slackClient.PostMessage(channelID, t("project_not_defined", nil), params); err != nil {
 				return errors.Wrap(err, 1)
 			}
 		} else {
-			msg = fmt.Sprintf(projectIsDefined, id)
+			msg = t("project_defined", MessageVars{"ProjectID": id})
 			params := slack.NewPostMessageParameters()
 			if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
 				return err
@@ -54,11 +54,3 @@ func CreateHelloAction(ctx context.Context, repo Repo) ActionFn {
 	}
 
 }
-
-var (
-	helloResponse = "Hello, *%s*, I'm %s, and I will be working with you during the OSCON AppOps tutorial. " +
-		"I can help you create your enviroment, and offer help when I can. To get started, you will have to issue me a command."
-
-	projecIsNotDefined = "It doesn't look like you have a project defined. To start a new project, tell me to `./boot shell`"
-	projectIsDefined   = "It looks like you already have a project (_%s_) defined. If you require help or want to know what to do next, ask me for help with `./help`"
-)
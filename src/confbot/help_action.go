@@ -10,7 +10,7 @@ import (
 // CreateHelpAction creates a help action.
 func CreateHelpAction(ctx context.Context, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
-		log := logFromContext(ctx).WithFields(logrus.Fields{"user-id": m.User, "action": "hello"})
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": m.User, "action": "help"})
 
 		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
 		if err != nil {
@@ -23,16 +23,16 @@ func CreateHelpAction(ctx context.Context, repo Repo) ActionFn {
 			return errors.Wrap(err, 1)
 		}
 
+		t := userMessages(ctx, repo, log, m.User)
+
 		if id == "" {
-			msg := "Hello, it looks like you don't currently have a workshop environment created. " +
-				"To get started run `./boot shell`."
+			msg := t("help_no_project", nil)
 			params := slack.NewPostMessageParameters()
 			if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
 				return errors.Wrap(err, 1)
 			}
 		} else {
-			msg := "Hello, it looks like your workshop environment is in the process of, or has been booted. " +
-				"The instructur will inform you of the next course of actions."
+			msg := t("help_project", nil)
 			params := slack.NewPostMessageParameters()
 			if _, _, err := slackClient.PostMessage(channelID, msg, params); err != nil {
 				return errors.Wrap(err, 1)
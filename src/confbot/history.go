@@ -0,0 +1,142 @@
+package confbot
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+var (
+	// HistoryTTL is how long a project's history is kept after its last event.
+	// History outlives the project, so failed boots and deletes can be
+	// looked into.
+	HistoryTTL = 7 * 24 * time.Hour
+
+	// maxHistory is the amount of events kept for a project.
+	maxHistory = 500
+
+	// historyMessageEvents is the amount of events shown in Slack.
+	historyMessageEvents = 40
+)
+
+// HistoryRecorder saves every project event in the project's history.
+func HistoryRecorder(repo Repo, log *logrus.Entry) EventHandler {
+	return func(ev Event) {
+		if ev.ProjectID == "" {
+			return
+		}
+
+		if err := repo.AddHistory(ev); err != nil {
+			log.WithError(err).
+				WithFields(logrus.Fields{
+					"event":      ev.Type,
+					"project-id": ev.ProjectID,
+				}).Error("unable to save project history")
+		}
+	}
+}
+
+// CreateHistoryAction creates an action which shows a user their project's history.
+func CreateHistoryAction(ctx context.Context, repo Repo) ActionFn {
+	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
+		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
+		if err != nil {
+			return err
+		}
+
+		projectID, err := repo.ProjectID(m.User)
+		if err != nil {
+			return err
+		}
+
+		t := userMessages(ctx, repo, LogFromContext(ctx), m.User)
+		params := slack.NewPostMessageParameters()
+		if projectID == "" {
+			slackClient.PostMessage(channelID, t("history_no_project", nil), params)
+			return nil
+		}
+
+		return postHistory(repo, t, slackClient, channelID, projectID)
+	}
+}
+
+// CreateAdminHistoryAction creates an action which shows an instructor the
+// history of any project.
+func CreateAdminHistoryAction(ctx context.Context, repo Repo) ActionFn {
+	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
+		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
+		if err != nil {
+			return err
+		}
+
+		t := userMessages(ctx, repo, LogFromContext(ctx), m.User)
+		params := slack.NewPostMessageParameters()
+		if !isAdmin(m.User) {
+			slackClient.PostMessage(channelID, t("history_admin_only", nil), params)
+			return nil
+		}
+
+		return postHistory(repo, t, slackClient, channelID, matches[0][1])
+	}
+}
+
+func postHistory(repo Repo, t messageFn, slackClient *slack.Client, channelID, projectID string) error {
+	events, err := repo.History(projectID)
+	if err != nil {
+		return err
+	}
+
+	params := slack.NewPostMessageParameters()
+	if len(events) == 0 {
+		_, _, err := slackClient.PostMessage(channelID, t("history_empty", MessageVars{"ProjectID": projectID}), params)
+		return err
+	}
+
+	msg := t("history", MessageVars{"ProjectID": projectID, "History": FormatHistory(events, historyMessageEvents)})
+	_, _, err = slackClient.PostMessage(channelID, msg, params)
+	return err
+}
+
+// FormatHistory formats the last limit events, one per line.
+func FormatHistory(events []Event, limit int) string {
+	var buf bytes.Buffer
+
+	if len(events) > limit {
+		fmt.Fprintf(&buf, "(%d earlier events not shown)\n", len(events)-limit)
+		events = events[len(events)-limit:]
+	}
+
+	for _, ev := range events {
+		fmt.Fprintf(&buf, "%s %s", ev.At.UTC().Format("2006-01-02 15:04:05"), ev.Type)
+
+		if ev.Operation != "" || ev.Step != "" {
+			fmt.Fprintf(&buf, " %s", strings.Trim(ev.Operation+"/"+ev.Step, "/"))
+		}
+		if ev.Duration > 0 {
+			fmt.Fprintf(&buf, " (%s)", ev.Duration.Round(time.Second))
+		}
+
+		var keys []string
+		for k := range ev.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %s=%s", k, ev.Data[k])
+		}
+
+		if ev.Error != "" {
+			fmt.Fprintf(&buf, " error=%q", ev.Error)
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
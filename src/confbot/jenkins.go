@@ -0,0 +1,219 @@
+package confbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// JenkinsTimeout is the timeout for a single request to Jenkins.
+	JenkinsTimeout = 10 * time.Second
+
+	// JenkinsPollInterval is how often a running build is checked.
+	JenkinsPollInterval = 15 * time.Second
+
+	// JenkinsBuildTimeout is how long to wait for a build to finish.
+	JenkinsBuildTimeout = 30 * time.Minute
+
+	jenkinsRetries    = 3
+	jenkinsRetryDelay = 2 * time.Second
+
+	// ErrJenkinsBuildTimeout is returned when a build doesn't finish in time.
+	ErrJenkinsBuildTimeout = errors.New("timed out waiting for jenkins build to finish")
+)
+
+// JenkinsBuild is a Jenkins build.
+type JenkinsBuild struct {
+	Number          int    `json:"number"`
+	FullDisplayName string `json:"fullDisplayName"`
+	URL             string `json:"url"`
+	Building        bool   `json:"building"`
+	// Result is empty while the build is running.
+	Result    string `json:"result"`
+	Duration  int64  `json:"duration"`
+	Timestamp int64  `json:"timestamp"`
+	Actions   []struct {
+		LastBuiltRevision *struct {
+			SHA1   string `json:"SHA1"`
+			Branch []struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"lastBuiltRevision"`
+	} `json:"actions"`
+}
+
+// Finished returns true if the build has a result.
+func (b *JenkinsBuild) Finished() bool {
+	return !b.Building && b.Result != ""
+}
+
+// BuildDuration is how long the build took.
+func (b *JenkinsBuild) BuildDuration() time.Duration {
+	return time.Duration(b.Duration) * time.Millisecond
+}
+
+// Commit returns the commit and branch the build was built from.
+func (b *JenkinsBuild) Commit() (sha, branch string) {
+	for _, a := range b.Actions {
+		if r := a.LastBuiltRevision; r != nil {
+			if len(r.Branch) > 0 {
+				branch = r.Branch[0].Name
+			}
+			return r.SHA1, branch
+		}
+	}
+
+	return "", ""
+}
+
+// JenkinsTestReport is the test results for a build.
+type JenkinsTestReport struct {
+	FailCount int `json:"failCount"`
+	PassCount int `json:"passCount"`
+	SkipCount int `json:"skipCount"`
+}
+
+// jenkinsStatusErr is returned when Jenkins responds with an unexpected status.
+type jenkinsStatusErr struct {
+	url    string
+	status int
+}
+
+var _ error = (*jenkinsStatusErr)(nil)
+
+func (e *jenkinsStatusErr) Error() string {
+	return fmt.Sprintf("jenkins returned %d for %s", e.status, e.url)
+}
+
+// JenkinsClient is a client for the Jenkins API.
+type JenkinsClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewJenkinsClient creates an instance of JenkinsClient.
+func NewJenkinsClient(baseURL string) *JenkinsClient {
+	return &JenkinsClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: JenkinsTimeout},
+	}
+}
+
+// BuildURL is the URL for a build.
+func (jc *JenkinsClient) BuildURL(job string, number int) string {
+	return fmt.Sprintf("%s/job/%s/%d", jc.baseURL, url.PathEscape(job), number)
+}
+
+// Build retrieves a build.
+func (jc *JenkinsClient) Build(job string, number int) (*JenkinsBuild, error) {
+	b, err := jc.get(jc.BuildURL(job, number) + "/api/json")
+	if err != nil {
+		return nil, err
+	}
+
+	var build JenkinsBuild
+	if err := json.Unmarshal(b, &build); err != nil {
+		return nil, err
+	}
+
+	return &build, nil
+}
+
+// WaitForBuild polls a build until it has finished.
+func (jc *JenkinsClient) WaitForBuild(job string, number int) (*JenkinsBuild, error) {
+	deadline := time.Now().Add(JenkinsBuildTimeout)
+
+	for {
+		build, err := jc.Build(job, number)
+		if err != nil {
+			return nil, err
+		}
+
+		if build.Finished() {
+			return build, nil
+		}
+
+		if time.Now().After(deadline) {
+			return build, ErrJenkinsBuildTimeout
+		}
+
+		time.Sleep(JenkinsPollInterval)
+	}
+}
+
+// TestReport retrieves the test results for a build. It returns nil if
+// the build has no test results.
+func (jc *JenkinsClient) TestReport(job string, number int) (*JenkinsTestReport, error) {
+	b, err := jc.get(jc.BuildURL(job, number) + "/testReport/api/json")
+	if se, ok := err.(*jenkinsStatusErr); ok && se.status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tr JenkinsTestReport
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return nil, err
+	}
+
+	return &tr, nil
+}
+
+// ConsoleTail returns the last lines of a build's console log.
+func (jc *JenkinsClient) ConsoleTail(job string, number, lines int) (string, error) {
+	b, err := jc.get(jc.BuildURL(job, number) + "/consoleText")
+	if err != nil {
+		return "", err
+	}
+
+	out := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(out) > lines {
+		out = out[len(out)-lines:]
+	}
+
+	return strings.Join(out, "\n"), nil
+}
+
+// get fetches a URL. Network errors and server errors are retried.
+func (jc *JenkinsClient) get(u string) ([]byte, error) {
+	var lastErr error
+
+	for i := 0; i < jenkinsRetries; i++ {
+		if i > 0 {
+			time.Sleep(jenkinsRetryDelay * time.Duration(i))
+		}
+
+		res, err := jc.client.Get(u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if res.StatusCode >= http.StatusInternalServerError {
+			lastErr = &jenkinsStatusErr{url: u, status: res.StatusCode}
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return nil, &jenkinsStatusErr{url: u, status: res.StatusCode}
+		}
+
+		return b, nil
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,62 @@
+package confbot
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+// CreateLanguageAction creates an action which shows or sets the language
+// the bot uses with a user. It handles `./language` and `./language <code>`.
+func CreateLanguageAction(ctx context.Context, repo Repo) ActionFn {
+	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
+		userID := m.User
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID, "action": "language"})
+
+		_, _, channelID, err := slackClient.OpenIMChannel(userID)
+		if err != nil {
+			return err
+		}
+
+		locales := strings.Join(CurrentMessages.Locales(), ", ")
+		params := slack.NewPostMessageParameters()
+
+		var locale string
+		if len(matches) > 0 && len(matches[0]) > 1 {
+			locale = NormalizeLocale(matches[0][1])
+		}
+
+		if locale == "" {
+			current, err := repo.Locale(userID)
+			if err != nil {
+				return err
+			}
+			if current == "" {
+				current = DefaultLocale
+			}
+
+			t := userMessages(ctx, repo, log, userID)
+			_, _, err = slackClient.PostMessage(channelID, t("language_current", MessageVars{"Locale": current, "Locales": locales}), params)
+			return err
+		}
+
+		if !validLocale.MatchString(locale) || !CurrentMessages.Has(locale) {
+			t := userMessages(ctx, repo, log, userID)
+			_, _, err = slackClient.PostMessage(channelID, t("language_unknown", MessageVars{"Locale": locale, "Locales": locales}), params)
+			return err
+		}
+
+		if err := repo.SetLocale(userID, locale); err != nil {
+			return err
+		}
+
+		log.WithField("locale", locale).Info("set language")
+
+		ctx = ContextWithLocale(ctx, userID, locale)
+		t := userMessages(ctx, repo, log, userID)
+		_, _, err = slackClient.PostMessage(channelID, t("language_set", MessageVars{"Locale": locale}), params)
+		return err
+	}
+}
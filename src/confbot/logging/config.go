@@ -0,0 +1,239 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// Sink types.
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+	SinkHTTP   = "http"
+
+	// Formats.
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config describes how logs are formatted and where they are sent.
+type Config struct {
+	// Format is FormatText or FormatJSON.
+	Format string `json:"format"`
+	// Level is the minimum level logged.
+	Level string       `json:"level"`
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// SinkConfig describes a sink. Which fields are used depends on the type.
+type SinkConfig struct {
+	Type string `json:"type"`
+	// Level is the minimum level sent to the sink. It defaults to the
+	// config's level.
+	Level string `json:"level,omitempty"`
+	// Format overrides the config's format.
+	Format string `json:"format,omitempty"`
+
+	// file
+	Path       string `json:"path,omitempty"`
+	MaxSize    int64  `json:"max_size,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+
+	// syslog
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Network  string `json:"network,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Appname  string `json:"appname,omitempty"`
+
+	// http
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// syslog and http
+	BufferSize int `json:"buffer_size,omitempty"`
+}
+
+// LoadConfig reads a JSON config file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid log config %s: %v", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Router is a hook which removes secrets from entries and sends them to
+// each sink which accepts their level. It replaces the logger's output.
+type Router struct {
+	redactor *Redactor
+	sinks    []routedSink
+}
+
+type routedSink struct {
+	level logrus.Level
+	sink  Sink
+}
+
+var _ logrus.Hook = (*Router)(nil)
+
+// Setup configures logger from cfg. Values of secrets are redacted from
+// every entry.
+func Setup(logger *logrus.Logger, cfg Config, secrets ...string) (*Router, error) {
+	level, err := parseLevel(cfg.Level, logrus.InfoLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter, err := newFormatter(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []SinkConfig{{Type: SinkStdout}}
+	}
+
+	r := &Router{redactor: NewRedactor(secrets...)}
+	for _, sc := range cfg.Sinks {
+		rs, err := newRoutedSink(sc, level, formatter)
+		if err != nil {
+			r.Close(time.Second)
+			return nil, err
+		}
+
+		r.sinks = append(r.sinks, rs)
+	}
+
+	logger.Level = level
+	logger.Formatter = formatter
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(r)
+
+	return r, nil
+}
+
+// Fire sends an entry to the sinks.
+func (r *Router) Fire(entry *logrus.Entry) error {
+	entry = r.redactor.Entry(entry)
+
+	var firstErr error
+	for _, rs := range r.sinks {
+		if entry.Level > rs.level {
+			continue
+		}
+
+		if err := rs.sink.Fire(entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Levels returns the available logging levels.
+func (r *Router) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Redact adds secrets which are removed from entries.
+func (r *Router) Redact(secrets ...string) {
+	r.redactor.Add(secrets...)
+}
+
+// Close sends buffered entries and closes the sinks.
+func (r *Router) Close(timeout time.Duration) error {
+	var firstErr error
+	for _, rs := range r.sinks {
+		if err := rs.sink.Close(timeout); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func newRoutedSink(sc SinkConfig, level logrus.Level, formatter logrus.Formatter) (routedSink, error) {
+	sinkLevel, err := parseLevel(sc.Level, level)
+	if err != nil {
+		return routedSink{}, err
+	}
+
+	if sc.Format != "" {
+		if formatter, err = newFormatter(sc.Format); err != nil {
+			return routedSink{}, err
+		}
+	}
+
+	sink, err := newSink(sc, formatter)
+	if err != nil {
+		return routedSink{}, err
+	}
+
+	return routedSink{level: sinkLevel, sink: sink}, nil
+}
+
+func newSink(sc SinkConfig, formatter logrus.Formatter) (Sink, error) {
+	switch sc.Type {
+	case SinkStdout:
+		return NewWriterSink(os.Stdout, formatter), nil
+	case SinkFile:
+		if sc.Path == "" {
+			return nil, fmt.Errorf("file sink requires a path")
+		}
+
+		rf, err := NewRotatingFile(sc.Path, sc.MaxSize, sc.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterSink(rf, formatter), nil
+	case SinkSyslog:
+		return NewPapertrailHook(&Hook{
+			Host:       sc.Host,
+			Port:       sc.Port,
+			Network:    sc.Network,
+			Appname:    sc.Appname,
+			Hostname:   sc.Hostname,
+			BufferSize: sc.BufferSize,
+			Formatter:  formatter,
+		})
+	case SinkHTTP:
+		if sc.URL == "" {
+			return nil, fmt.Errorf("http sink requires a url")
+		}
+		return NewHTTPSink(sc.URL, sc.Headers, sc.BufferSize), nil
+	default:
+		return nil, fmt.Errorf("unknown log sink %q", sc.Type)
+	}
+}
+
+func newFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", FormatText:
+		return &logrus.TextFormatter{}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func parseLevel(s string, def logrus.Level) (logrus.Level, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	return logrus.ParseLevel(s)
+}
@@ -1,53 +1,149 @@
 package logging
 
 import (
+	"confbot/metrics"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
 const (
-	format = "Jan 2 15:04:05"
+	// Networks the hook can send logs over.
+	NetworkTLS = "tls"
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
+
+	// DefaultBufferSize is the amount of entries queued while the hook
+	// can't keep up or is reconnecting.
+	DefaultBufferSize = 1000
+
+	// FacilityUser is the syslog user-level messages facility.
+	FacilityUser = 1
+
+	minBackoff   = 500 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+
+	nilValue = "-"
+)
+
+var _ Sink = (*Hook)(nil)
+
+var (
+	entriesDropped = metrics.NewCounter(
+		"confbot_log_entries_dropped_total",
+		"Log entries dropped because a sink's buffer was full, by sink.",
+		"sink")
+
+	// severities maps logrus levels to syslog severities.
+	severities = map[logrus.Level]int{
+		logrus.PanicLevel: 0, // emergency
+		logrus.FatalLevel: 2, // critical
+		logrus.ErrorLevel: 3, // error
+		logrus.WarnLevel:  4, // warning
+		logrus.InfoLevel:  6, // informational
+		logrus.DebugLevel: 7, // debug
+	}
+
+	errHookClosed = errors.New("log hook is closed")
 )
 
-// Hook to send logs to a logging service compatible with the Papertrail API.
+// Hook to send logs to a logging service compatible with the Papertrail API,
+// or any syslog server which accepts RFC 5424 messages. Entries are queued
+// and sent by a background writer, which reconnects when the connection
+// fails. If the queue is full, entries are dropped.
 type Hook struct {
 	// Connection Details
 	Host string
 	Port int
+	// Network is NetworkTLS, NetworkTCP or NetworkUDP. It defaults to NetworkTLS.
+	Network string
 
 	// App Details
 	Appname  string
 	Hostname string
+	// Facility is the syslog facility. It defaults to FacilityUser.
+	Facility int
 
-	tlsConn *tls.Conn
+	// BufferSize is the amount of entries which can be queued. It defaults
+	// to DefaultBufferSize.
+	BufferSize int
+
+	// Formatter formats the message. It defaults to the logger's formatter.
+	Formatter logrus.Formatter
+
+	queue   chan []byte
+	flushes chan chan struct{}
+	done    chan struct{}
+	conn    net.Conn
+	dropped uint64
+
+	closeOnce sync.Once
 }
 
-// NewPapertrailHook creates a hook to be added to an instance of logger.
+// NewPapertrailHook creates a hook to be added to an instance of logger. The
+// connection is made in the background, so a syslog server which is down
+// doesn't stop the app from starting.
 func NewPapertrailHook(hook *Hook) (*Hook, error) {
-	var err error
+	if hook.Network == "" {
+		hook.Network = NetworkTLS
+	}
+	if hook.Facility == 0 {
+		hook.Facility = FacilityUser
+	}
+	if hook.BufferSize <= 0 {
+		hook.BufferSize = DefaultBufferSize
+	}
 
-	hook.tlsConn, err = tls.Dial("tcp", fmt.Sprintf("%s:%d", hook.Host, hook.Port), nil)
-	return hook, err
-}
+	switch hook.Network {
+	case NetworkTLS, NetworkTCP, NetworkUDP:
+	default:
+		return nil, fmt.Errorf("unknown syslog network %q", hook.Network)
+	}
 
-// Fire is called when a log event is fired.
-func (hook *Hook) Fire(entry *logrus.Entry) error {
-	date := time.Now().Format(format)
-	msg, _ := entry.String()
-	payload := fmt.Sprintf("<22> %s %s %s: %s", date, hook.Hostname, hook.Appname, msg)
+	if hook.Host == "" || hook.Port == 0 {
+		return nil, errors.New("syslog host and port are required")
+	}
+
+	hook.queue = make(chan []byte, hook.BufferSize)
+	hook.flushes = make(chan chan struct{})
+	hook.done = make(chan struct{})
 
-	bytesWritten, err := hook.tlsConn.Write([]byte(payload))
+	go hook.run()
 
-	// bytesWritten, err := hook.udpConn.Write([]byte(payload))
+	return hook, nil
+}
+
+// Fire is called when a log event is fired. It never blocks.
+func (hook *Hook) Fire(entry *logrus.Entry) error {
+	msg, err := hook.format(entry)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to send log line to Papertrail via TCP over TLS. Wrote %d bytes before error: %v", bytesWritten, err)
 		return err
 	}
 
+	select {
+	case <-hook.done:
+		return errHookClosed
+	default:
+	}
+
+	select {
+	case hook.queue <- hook.frame(entry, msg):
+	default:
+		atomic.AddUint64(&hook.dropped, 1)
+		entriesDropped.Inc(SinkSyslog)
+	}
+
 	return nil
 }
 
@@ -62,3 +158,175 @@ func (hook *Hook) Levels() []logrus.Level {
 		logrus.DebugLevel,
 	}
 }
+
+// Dropped returns the amount of entries dropped because the queue was full.
+func (hook *Hook) Dropped() uint64 {
+	return atomic.LoadUint64(&hook.dropped)
+}
+
+// Flush waits until the entries queued before it was called are sent, or
+// until timeout.
+func (hook *Hook) Flush(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	flushed := make(chan struct{})
+	select {
+	case hook.flushes <- flushed:
+	case <-hook.done:
+		return errHookClosed
+	case <-timer.C:
+		return errors.New("timed out flushing logs")
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-timer.C:
+		return errors.New("timed out flushing logs")
+	}
+}
+
+// Close flushes the queue and stops the hook.
+func (hook *Hook) Close(timeout time.Duration) error {
+	err := hook.Flush(timeout)
+	hook.closeOnce.Do(func() { close(hook.done) })
+	return err
+}
+
+func (hook *Hook) format(entry *logrus.Entry) (string, error) {
+	if hook.Formatter == nil {
+		return entry.String()
+	}
+
+	b, err := hook.Formatter.Format(entry)
+	return string(b), err
+}
+
+// frame formats an entry as an RFC 5424 message. Messages sent over a
+// stream are framed with their length, as described in RFC 5425.
+func (hook *Hook) frame(entry *logrus.Entry, msg string) []byte {
+	severity, ok := severities[entry.Level]
+	if !ok {
+		severity = severities[logrus.InfoLevel]
+	}
+
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		hook.Facility*8+severity,
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		headerValue(hook.Hostname),
+		headerValue(hook.Appname),
+		os.Getpid(),
+		nilValue,
+		nilValue,
+		strings.TrimRight(msg, "\n"))
+
+	if hook.Network == NetworkUDP {
+		return []byte(line)
+	}
+
+	return []byte(fmt.Sprintf("%d %s", len(line), line))
+}
+
+// run sends queued entries until the hook is closed.
+func (hook *Hook) run() {
+	defer func() {
+		if hook.conn != nil {
+			hook.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-hook.queue:
+			if !hook.send(msg) {
+				return
+			}
+		case flushed := <-hook.flushes:
+			// entries queued before the flush are sent first.
+			for n := len(hook.queue); n > 0; n-- {
+				if !hook.send(<-hook.queue) {
+					return
+				}
+			}
+			close(flushed)
+		case <-hook.done:
+			return
+		}
+	}
+}
+
+// send writes a message, reconnecting with backoff until it is written. It
+// returns false if the hook was closed first.
+func (hook *Hook) send(msg []byte) bool {
+	backoff := minBackoff
+
+	for {
+		err := hook.write(msg)
+		if err == nil {
+			return true
+		}
+
+		fmt.Fprintf(os.Stderr, "Unable to send log line to %s:%d via %s, retrying in %s: %v\n",
+			hook.Host, hook.Port, hook.Network, backoff, err)
+
+		if hook.conn != nil {
+			hook.conn.Close()
+			hook.conn = nil
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-hook.done:
+			return false
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (hook *Hook) write(msg []byte) error {
+	if hook.conn == nil {
+		conn, err := hook.dial()
+		if err != nil {
+			return err
+		}
+		hook.conn = conn
+	}
+
+	hook.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := hook.conn.Write(msg)
+	return err
+}
+
+func (hook *Hook) dial() (net.Conn, error) {
+	addr := net.JoinHostPort(hook.Host, strconv.Itoa(hook.Port))
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	switch hook.Network {
+	case NetworkTLS:
+		return tls.DialWithDialer(dialer, "tcp", addr, nil)
+	case NetworkUDP:
+		return dialer.Dial("udp", addr)
+	default:
+		return dialer.Dial("tcp", addr)
+	}
+}
+
+// headerValue returns a value suitable for an RFC 5424 header field.
+func headerValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+
+	if s == "" {
+		return nilValue
+	}
+	return s
+}
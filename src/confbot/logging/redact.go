@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// Redacted replaces secrets in log entries.
+	Redacted = "[REDACTED]"
+
+	// minSecretLength is the length of the shortest secret which is
+	// redacted. Shorter values would redact too much of the logs.
+	minSecretLength = 8
+)
+
+var (
+	// secretFields are the names, or name suffixes, of fields which always
+	// hold secrets.
+	secretFields = []string{"token", "secret", "password", "private-key", "private_key"}
+
+	// secretPatterns match secrets whose values aren't known ahead of time.
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+		regexp.MustCompile(`xox[abpr]-[\w-]+`),
+	}
+)
+
+// Redactor removes secrets from log entries.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewRedactor creates an instance of Redactor. secrets are values which are
+// removed wherever they appear.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(secrets...)
+	return r
+}
+
+// Add adds secrets which are found after the redactor is created.
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range secrets {
+		if len(s) >= minSecretLength {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+}
+
+// String removes secrets from s.
+func (r *Redactor) String(s string) string {
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	r.mu.RUnlock()
+
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+
+	return s
+}
+
+// Entry returns a copy of entry without secrets. Fields are only replaced if
+// they held a secret, so other values keep their type.
+func (r *Redactor) Entry(entry *logrus.Entry) *logrus.Entry {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if isSecretField(k) {
+			data[k] = Redacted
+			continue
+		}
+
+		s := fmt.Sprint(v)
+		if redacted := r.String(s); redacted != s {
+			data[k] = redacted
+			continue
+		}
+
+		data[k] = v
+	}
+
+	return &logrus.Entry{
+		Logger:  entry.Logger,
+		Data:    data,
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: r.String(entry.Message),
+	}
+}
+
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range secretFields {
+		if name == f || strings.HasSuffix(name, "-"+f) || strings.HasSuffix(name, "_"+f) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,309 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxFileSize is the size, in bytes, a log file grows to before
+	// it is rotated.
+	DefaultMaxFileSize = 100 << 20
+
+	// DefaultMaxBackups is the amount of rotated log files kept.
+	DefaultMaxBackups = 5
+
+	httpBatchSize     = 100
+	httpBatchInterval = 2 * time.Second
+	httpTimeout       = 10 * time.Second
+)
+
+// Sink receives log entries.
+type Sink interface {
+	logrus.Hook
+
+	// Close sends any buffered entries and releases the sink.
+	Close(timeout time.Duration) error
+}
+
+// WriterSink writes formatted entries to a writer.
+type WriterSink struct {
+	mu        sync.Mutex
+	w         io.Writer
+	formatter logrus.Formatter
+}
+
+var _ Sink = (*WriterSink)(nil)
+
+// NewWriterSink creates an instance of WriterSink.
+func NewWriterSink(w io.Writer, formatter logrus.Formatter) *WriterSink {
+	return &WriterSink{w: w, formatter: formatter}
+}
+
+// Fire writes an entry.
+func (s *WriterSink) Fire(entry *logrus.Entry) error {
+	b, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(b)
+	return err
+}
+
+// Levels returns the available logging levels.
+func (s *WriterSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Close closes the writer if it can be closed.
+func (s *WriterSink) Close(timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout && s.w != os.Stderr {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// RotatingFile is a file which is rotated when it reaches a size. Rotated
+// files are named path.1, path.2, and so on, with path.1 the newest.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+var _ io.WriteCloser = (*RotatingFile)(nil)
+
+// NewRotatingFile opens a rotating file. It isn't safe for concurrent use.
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(b []byte) (int, error) {
+	if rf.size+int64(len(b)) > rf.maxSize && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	return rf.f.Close()
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		os.Rename(rf.backup(i), rf.backup(i+1))
+	}
+
+	if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *RotatingFile) backup(n int) string {
+	return rf.path + "." + strconv.Itoa(n)
+}
+
+// HTTPSink posts entries to a log ingest endpoint. Entries are sent in
+// batches as newline separated JSON. Like Hook, it queues entries and drops
+// them when the queue is full.
+type HTTPSink struct {
+	URL     string
+	Headers map[string]string
+
+	formatter logrus.Formatter
+	client    *http.Client
+	queue     chan []byte
+	flushes   chan chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Sink = (*HTTPSink)(nil)
+
+// NewHTTPSink creates an instance of HTTPSink.
+func NewHTTPSink(url string, headers map[string]string, bufferSize int) *HTTPSink {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	s := &HTTPSink{
+		URL:       url,
+		Headers:   headers,
+		formatter: &logrus.JSONFormatter{},
+		client:    &http.Client{Timeout: httpTimeout},
+		queue:     make(chan []byte, bufferSize),
+		flushes:   make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// Fire queues an entry. It never blocks.
+func (s *HTTPSink) Fire(entry *logrus.Entry) error {
+	b, err := s.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.queue <- b:
+	default:
+		entriesDropped.Inc(SinkHTTP)
+	}
+
+	return nil
+}
+
+// Levels returns the available logging levels.
+func (s *HTTPSink) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Close sends the queued entries and stops the sink.
+func (s *HTTPSink) Close(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	flushed := make(chan struct{})
+	select {
+	case s.flushes <- flushed:
+		select {
+		case <-flushed:
+		case <-timer.C:
+			err = fmt.Errorf("timed out flushing logs to %s", s.URL)
+		}
+	case <-timer.C:
+		err = fmt.Errorf("timed out flushing logs to %s", s.URL)
+	}
+
+	s.closeOnce.Do(func() { close(s.done) })
+	return err
+}
+
+func (s *HTTPSink) run() {
+	ticker := time.NewTicker(httpBatchInterval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	n := 0
+	send := func() {
+		if n == 0 {
+			return
+		}
+		if err := s.post(batch.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to send %d log lines to %s: %v\n", n, s.URL, err)
+		}
+		batch.Reset()
+		n = 0
+	}
+
+	for {
+		select {
+		case b := <-s.queue:
+			batch.Write(b)
+			n++
+			if n >= httpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-s.flushes:
+			for i := len(s.queue); i > 0; i-- {
+				batch.Write(<-s.queue)
+				n++
+			}
+			send()
+			close(flushed)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *HTTPSink) post(body []byte) error {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package confbot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// builtinLocale is the language of the built in messages. Every message
+// exists in it, so it is the last fallback.
+const builtinLocale = "en"
+
+var (
+	// DefaultLocale is the language used for users who haven't chosen one,
+	// and for messages which aren't translated to a user's language.
+	DefaultLocale = builtinLocale
+
+	// CurrentMessages is the message catalog the bot uses.
+	CurrentMessages = DefaultMessages()
+
+	validLocale = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+)
+
+// defaultMessages are the built in messages, and the only copy of the
+// English catalog. Templates use text/template, and are passed Domain,
+// Workshop and Bot along with their own variables. `confbotctl messages`
+// writes them as a message file to start a translation from.
+var defaultMessages = map[string]string{
+	"hello": "Hello, *{{.User}}*, I'm {{.Bot}}, and I will be working with you during the {{.Workshop}}. " +
+		"I can help you create your enviroment, and offer help when I can. To get started, you will have to issue me a command.",
+	"project_not_defined": "It doesn't look like you have a project defined. To start a new project, tell me to `./boot shell`",
+	"project_defined": "It looks like you already have a project (_{{.ProjectID}}_) defined. " +
+		"If you require help or want to know what to do next, ask me for help with `./help`",
+
+	"help_no_project": "Hello, it looks like you don't currently have a workshop environment created. " +
+		"To get started run `./boot shell`.",
+	"help_project": "Hello, it looks like your workshop environment is in the process of, or has been booted. " +
+		"The instructur will inform you of the next course of actions.",
+
+	"boot_out_of_capacity": "Can't boot shell: I'm out of capacity for new environments right now. " +
+		"Please let the instructor know, and try `./boot shell` again in a few minutes.",
+	"boot_error":    "Can't boot shell: {{.Error}}",
+	"boot_existing": "You already have an existing shell at *{{.ProjectID}}*",
+	"boot_started": "I'm currently booting a server named _shell.{{.ProjectID}}.{{.Domain}}_. " +
+		"After it has booted, I will use it to provision the rest of your environment. " +
+		"This process will take a few minutes, and I'll let you know when it is completed.",
+	"boot_failed": "I couldn't boot your shell: _{{.Error}}_. I've cleaned up what I had created, " +
+		"so you can try again with `./boot shell`.",
+	"boot_failed_cleanup": "I couldn't boot your shell: _{{.Error}}_. Some of what I had created couldn't be removed yet, " +
+		"I'll keep trying in the background. You can try again with `./boot shell`.",
+	"install_complete": "I've booted the shell Droplet for _{{.ProjectID}}_. Next, I will run the provisioner which will create the full " +
+		"environment. This process will take a few more minutes.",
+
+	"provision_started": "*Provisioning process started*",
+	"provision_failed": "*Provisioning process Failed* All was not well with the provisioning process. " +
+		"This is expected as the cloud is a chaotic environment. To restart the provision process " +
+		"issue the `./provision` command. You can also log into your shell and run ansible by hand. " +
+		"Issue the `./configure ssh <type>` command substituting <type> with *linux*, *mac, or *windows*. " +
+		"After SSH is setup, ssh to `workshop@shell.{{.ProjectID}}.{{.Domain}}.",
+	"provision_complete": "Your environment is ready to go. Before you can use it, you will " +
+		"need to configure your ssh client. I can assist you with directions " +
+		"for Linux, Mac, or Windows. To start this process, issue the `./configure ssh <type>` " +
+		"command substituting <type> with *linux*, *mac*, or *windows*.",
+
+	"step_infra_started":         "*... Creating hosts and certificates*",
+	"step_infra_finished":        "*... Hosts and certificats are up to date*",
+	"step_cert_started":          "*...Making sure root certificates are up to date*",
+	"step_ansible_started":       "*... Provisioning services with Ansible. This may take some time*",
+	"step_ansible_finished":      "*... Ansible provisioning is complete*",
+	"step_es_started":            "*... Waiting for ElasticSearch to become available*",
+	"step_es_finished":           "*... ElasticSearch is up and listening*",
+	"step_es_templates_started":  "*... Uploading ElasticSearch templates*",
+	"step_es_templates_finished": "* ... ElasticSearch templates have been uploaded*",
+	"step_dns_records_started":   "*... Deleting DNS records*",
+	"step_ssh_keys_started":      "*... Deleting SSH Keys*",
+	"step_droplets_started":      "*... Deleting Droplets*",
+	"step_reset_started":         "*... Resetting project*",
+
+	"delete_no_project": "No project ID, so there is nothing to delete.",
+	"delete_started":    "Deleting project _{{.ProjectID}}_ and it's associated resources",
+	"delete_finished":   "Project _{{.ProjectID}}_ has been deleted. Send command `./boot shell` to start a new project.",
+	"delete_failed":     "unable to delete project _{{.ProjectID}}_",
+	"reset":             "resetting your projects",
+
+	"ssh_ppk_failed": "I couldn't create your Putty key file: _{{.Error}}_. Try `./configure ssh windows-openssh` instead.",
+	"ssh_windows": "Download the Putty key file {{.PPKFile}}. Launch Putty and enter `{{.Host}}` as your " +
+		"Host Name. Next, navigate to the SSH / Auth Category, and " +
+		"browse for your {{.PPKFile}} in the `Private key file for authentication` " +
+		"text box. Afterwards, click open, and enter `workshop` as your user name.",
+	"ssh_windows_putty2": " If your Putty is older than 0.75 and can't read the key file, use `./configure ssh putty2`.",
+	"ssh_windows_openssh": "Download the SSH private key {{.Key}}. OpenSSH for Windows requires that only you can read it. " +
+		"In PowerShell, navigate to your download folder and run " +
+		"`icacls {{.Key}} /inheritance:r /grant:r \"$($env:USERNAME):(R)\"`. " +
+		"You can SSH to your shell Droplet by running `ssh -i {{.Key}} workshop@{{.Host}}`.",
+	"ssh_mac": "Download the SSH private key {{.Key}}. Most likely, " +
+		"{{.Key}} will be download to $HOME/Downloads. In your terminal, " +
+		"run `chmod 600 {{.Key}}`. You can SSH to your shell Droplet by running " +
+		"`ssh -i {{.Key}} workshop@{{.Host}}`.",
+	"ssh_linux": "Download the SSH private key {{.Key}}. In your terminal, " +
+		"navigate to your download folder and run `chmod 600 {{.Key}}`. " +
+		"You can SSH to your shell Droplet by running " +
+		"`ssh -i {{.Key}} workshop@{{.Host}}`. ",
+	"ssh_config": "Download the SSH private key {{.Key}} to `~/.ssh/{{.Key}}` and run `chmod 600 ~/.ssh/{{.Key}}`. " +
+		"Next, append confbot.config to `~/.ssh/config`. You can SSH to your shell Droplet by running " +
+		"`ssh {{.Alias}}`, and to your other hosts with `ssh <host>.{{.ProjectID}}.{{.Domain}}`.",
+	"ssh_agent": "Download the SSH private key {{.Key}}. In your terminal, " +
+		"navigate to your download folder and run `chmod 600 {{.Key}}`. " +
+		"Add it to your ssh-agent for the rest of the day with `ssh-add -t 12h {{.Key}}`, " +
+		"then SSH to your shell Droplet with `ssh -A workshop@{{.Host}}`. " +
+		"Forwarding the agent lets you hop from your shell to your other hosts.",
+	"ssh_unknown": "I don't know how to configure ssh for *{{.Subject}}*, but I bet it's very similar to linux. " +
+		"Try `./configure ssh linux`. I can also configure *mac*, *windows*, *putty2*, " +
+		"*windows-openssh*, *config* and *agent*.",
+
+	"rotate_started":  "*Rotating SSH key for _{{.ProjectID}}_*",
+	"rotate_failed":   "I couldn't rotate your SSH key: _{{.Error}}_. Your existing key still works.",
+	"rotate_finished": "Your SSH key has been rotated on {{.Hosts}}. Download the new SSH private key {{.Key}} and replace your old one. Your old key no longer works.",
+	"rotate_partial":  "Your SSH key has been rotated on {{.Hosts}}. Download the new SSH private key {{.Key}} and replace your old one. I couldn't remove your old key from {{.Failed}}, so it still works there.",
+
+	"cert_instructor_only": "Only instructors can request instructor certificates.",
+	"cert_unknown":         "I don't know how to issue a *{{.Kind}}* certificate. Try `./ssh cert`",
+	"cert_issued": "Download id_confbot and id_confbot-cert.pub to the same folder, " +
+		"and run `chmod 600 id_confbot`. You can SSH to your shell Droplet by running " +
+		"`ssh -i id_confbot workshop@{{.Host}}`. The certificate expires in {{.TTL}}, ask me for a new one with `./ssh cert`.",
+	"cert_none":           "You don't have any valid certificates.",
+	"cert_list":           "Your certificates",
+	"cert_revoke_usage":   "Tell me which certificate to revoke with `./ssh revoke <serial>`",
+	"cert_not_found":      "You don't have a certificate with serial *{{.Serial}}*",
+	"cert_revoke_partial": "Certificate *{{.Serial}}* is revoked, but I couldn't tell every host: _{{.Error}}_",
+	"cert_revoked":        "Certificate *{{.Serial}}* has been revoked",
+
+	"settings":      "List of URLs for your environment",
+	"download_link": "Download *{{.Name}}* from {{.URL}} . The link works once and expires in {{.TTL}}.",
+
+	"history_no_project": "You don't have a project. Run `./boot shell` to create one.",
+	"history_admin_only": "Only instructors can view the history of other projects.",
+	"history_empty":      "There is no history for _{{.ProjectID}}_.",
+	"history":            "History for _{{.ProjectID}}_:\n```{{.History}}```",
+
+	"language_current": "I'm talking to you in *{{.Locale}}*. I can also speak {{.Locales}}. Change it with `./language <code>`.",
+	"language_set":     "I'll talk to you in *{{.Locale}}* from now on.",
+	"language_unknown": "I don't speak *{{.Locale}}*. I can speak {{.Locales}}.",
+}
+
+// MessageVars are the variables passed to a message template.
+type MessageVars map[string]interface{}
+
+// Messages is a catalog of message templates by locale and key.
+type Messages struct {
+	locales map[string]map[string]*template.Template
+}
+
+// MessagesConfigErr is returned when a message file is invalid.
+type MessagesConfigErr struct {
+	Path string
+	Err  error
+}
+
+var _ error = (*MessagesConfigErr)(nil)
+
+func (e *MessagesConfigErr) Error() string {
+	return fmt.Sprintf("invalid messages %s: %v", e.Path, e.Err)
+}
+
+// DefaultMessages returns a catalog with the built in messages.
+func DefaultMessages() *Messages {
+	m := &Messages{locales: map[string]map[string]*template.Template{}}
+	for key, text := range defaultMessages {
+		m.add(builtinLocale, key, template.Must(parseMessage(key, text)))
+	}
+
+	return m
+}
+
+// LoadMessages reads the message files in dir on top of the built in
+// messages. Files are named after their locale, e.g. pt-br.toml, and contain
+// `key = "template"` lines. Messages which are left out of a file fall back
+// to DefaultLocale. An en.toml file overrides the built in messages.
+func LoadMessages(dir string) (*Messages, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := DefaultMessages()
+	for _, path := range paths {
+		locale := NormalizeLocale(strings.TrimSuffix(filepath.Base(path), ".toml"))
+		if !validLocale.MatchString(locale) {
+			return nil, &MessagesConfigErr{Path: path, Err: fmt.Errorf("%q is not a locale", locale)}
+		}
+
+		if err := m.load(locale, path); err != nil {
+			return nil, &MessagesConfigErr{Path: path, Err: err}
+		}
+	}
+
+	return m, nil
+}
+
+// messagesFileHeader explains a message file written by WriteDefaultMessages.
+const messagesFileHeader = `# The built in English messages. They don't need to be deployed; this
+# file is the starting point for a translation or an override.
+#
+# Save it as <locale>.toml in the directory in CONFBOT_MESSAGES_DIR, e.g.
+# de.toml or pt-br.toml, and translate the messages, or as en.toml to
+# change the English ones. Messages which are left out fall back to the
+# language without its region (pt for pt-br), then CONFBOT_DEFAULT_LOCALE,
+# then the built in messages.
+#
+# Messages are Go templates. Every message can use {{.Domain}},
+# {{.Workshop}} and {{.Bot}}; the others use the variables they already
+# contain, e.g. {{.ProjectID}} or {{.Host}}.
+
+`
+
+// WriteDefaultMessages writes the built in messages as a message file.
+func WriteDefaultMessages(w io.Writer) error {
+	if _, err := io.WriteString(w, messagesFileHeader); err != nil {
+		return err
+	}
+
+	return toml.NewEncoder(w).Encode(defaultMessages)
+}
+
+func (m *Messages) load(locale, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]interface{}{}
+	if _, err := toml.Decode(string(b), &doc); err != nil {
+		return err
+	}
+
+	for key, v := range doc {
+		if _, ok := defaultMessages[key]; !ok {
+			return &FieldErr{Field: key, Reason: "unknown message"}
+		}
+
+		text, ok := v.(string)
+		if !ok {
+			return &FieldErr{Field: key, Reason: "must be a string"}
+		}
+
+		t, err := parseMessage(key, text)
+		if err != nil {
+			return &FieldErr{Field: key, Reason: err.Error()}
+		}
+
+		m.add(locale, key, t)
+	}
+
+	return nil
+}
+
+func (m *Messages) add(locale, key string, t *template.Template) {
+	if m.locales[locale] == nil {
+		m.locales[locale] = map[string]*template.Template{}
+	}
+	m.locales[locale][key] = t
+}
+
+func parseMessage(key, text string) (*template.Template, error) {
+	return template.New(key).Option("missingkey=error").Parse(text)
+}
+
+// Locales returns the locales in the catalog.
+func (m *Messages) Locales() []string {
+	var locales []string
+	for l := range m.locales {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+
+	return locales
+}
+
+// Has returns true if the catalog has messages for a locale, or for its
+// language, e.g. pt for pt-br.
+func (m *Messages) Has(locale string) bool {
+	for _, l := range fallbackLocales(NormalizeLocale(locale)) {
+		if _, ok := m.locales[l]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Render renders a message in a locale. It falls back to the locale's
+// language, then DefaultLocale, then the built in message. The message is
+// always usable; err reports a template which couldn't be rendered.
+func (m *Messages) Render(locale, key string, vars MessageVars) (string, error) {
+	data := MessageVars{
+		"Domain":   DropletDomain,
+		"Workshop": CurrentWorkshop.Name,
+		"Bot":      "confbot",
+	}
+	for k, v := range vars {
+		data[k] = v
+	}
+
+	var firstErr error
+	candidates := append(fallbackLocales(NormalizeLocale(locale)), fallbackLocales(DefaultLocale)...)
+	for _, l := range append(candidates, builtinLocale) {
+		t, ok := m.locales[l][key]
+		if !ok {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("message %s (%s): %v", key, l, err)
+			}
+			continue
+		}
+
+		return buf.String(), firstErr
+	}
+
+	if firstErr == nil {
+		firstErr = fmt.Errorf("unknown message %s", key)
+	}
+
+	return key, firstErr
+}
+
+// NormalizeLocale lower cases a locale and uses - as its separator, so
+// pt_BR becomes pt-br.
+func NormalizeLocale(locale string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(locale)), "_", "-", -1)
+}
+
+// fallbackLocales returns a locale followed by its less specific forms,
+// e.g. pt-br, pt.
+func fallbackLocales(locale string) []string {
+	var out []string
+	for locale != "" {
+		out = append(out, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return out
+}
+
+// messageFn renders a message for a user.
+type messageFn func(key string, vars MessageVars) string
+
+// userMessages returns a messageFn which renders messages in the language
+// userID has chosen. The language is looked up unless ctx carries it.
+func userMessages(ctx context.Context, repo Repo, log *logrus.Entry, userID string) messageFn {
+	locale, ok := localeFromContext(ctx, userID)
+	if !ok {
+		locale = lookupLocale(repo, log, userID)
+	}
+
+	return localeMessages(log, locale)
+}
+
+// lookupLocale returns the language userID has chosen, or DefaultLocale if
+// they haven't chosen one.
+func lookupLocale(repo Repo, log *logrus.Entry, userID string) string {
+	locale, err := repo.Locale(userID)
+	if err != nil {
+		log.WithError(err).WithField("user-id", userID).Warn("unable to load user's language")
+	}
+	if locale == "" {
+		locale = DefaultLocale
+	}
+
+	return locale
+}
+
+// localeMessages returns a messageFn which renders messages in locale.
+func localeMessages(log *logrus.Entry, locale string) messageFn {
+	return func(key string, vars MessageVars) string {
+		msg, err := CurrentMessages.Render(locale, key, vars)
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"locale":  locale,
+				"message": key,
+			}).Warn("unable to render message")
+		}
+		return msg
+	}
+}
@@ -0,0 +1,94 @@
+package confbot
+
+import (
+	"confbot/metrics"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// Operations which have their steps measured.
+	operationBoot      = "boot"
+	operationProvision = "provision"
+	operationDelete    = "delete"
+
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+var (
+	commandsTotal = metrics.NewCounter(
+		"confbot_commands_total",
+		"Commands handled by action and outcome.",
+		"action", "outcome")
+
+	commandDuration = metrics.NewHistogram(
+		"confbot_command_duration_seconds",
+		"Time taken to handle a command.",
+		nil, "action")
+
+	operationsTotal = metrics.NewCounter(
+		"confbot_operations_total",
+		"Boot, provision and delete operations by outcome.",
+		"operation", "outcome")
+
+	operationStepDuration = metrics.NewHistogram(
+		"confbot_operation_step_duration_seconds",
+		"Time taken by each step of a boot, provision or delete operation.",
+		nil, "operation", "step", "outcome")
+
+	sshCommandDuration = metrics.NewHistogram(
+		"confbot_ssh_command_duration_seconds",
+		"Time taken to run a command over SSH.",
+		nil, "host", "outcome")
+
+	doRequestsTotal = metrics.NewCounter(
+		"confbot_digitalocean_requests_total",
+		"DigitalOcean API requests by token fingerprint and status code.",
+		"token", "code")
+
+	doErrorsTotal = metrics.NewCounter(
+		"confbot_digitalocean_errors_total",
+		"DigitalOcean API requests which failed or returned an error status, by token fingerprint.",
+		"token")
+
+	slackConnected = metrics.NewGauge(
+		"confbot_slack_rtm_connected",
+		"1 if the Slack RTM connection is up.")
+
+	slackConnectionEvents = metrics.NewCounter(
+		"confbot_slack_rtm_connection_events_total",
+		"Slack RTM connection events by type.",
+		"event")
+)
+
+func outcome(err error) string {
+	if err != nil {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// instrumentedTransport counts DigitalOcean API requests.
+type instrumentedTransport struct {
+	base        http.RoundTripper
+	fingerprint string
+}
+
+var _ http.RoundTripper = (*instrumentedTransport)(nil)
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		doRequestsTotal.Inc(t.fingerprint, "error")
+		doErrorsTotal.Inc(t.fingerprint)
+		return nil, err
+	}
+
+	doRequestsTotal.Inc(t.fingerprint, strconv.Itoa(res.StatusCode))
+	if res.StatusCode >= http.StatusBadRequest {
+		doErrorsTotal.Inc(t.fingerprint)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,327 @@
+// Package metrics is a small metrics library which exposes metrics in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used if none are supplied. They
+// are in seconds.
+var DefaultBuckets = []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// DefaultRegistry is the registry metrics are added to when they are created.
+var DefaultRegistry = NewRegistry()
+
+// collector is a metric which can be written in the text format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry is a collection of metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an instance of Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]collector{},
+	}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.collectors[c.name()]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", c.name()))
+	}
+
+	r.collectors[c.name()] = c
+}
+
+// WriteTo writes all metrics in the text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	var names []string
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		r.collectors[name].write(&buf)
+	}
+	r.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// Handler returns a http.Handler which serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+// Handler returns a http.Handler which serves the default registry's metrics.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// desc describes a metric.
+type desc struct {
+	fqName string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.fqName, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.fqName, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.fqName, d.kind)
+}
+
+// labelValueEscaper escapes label values the way the text format expects.
+// Only backslashes, double quotes and line feeds are escaped, other
+// characters, including non-ASCII ones, are written as they are.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats label pairs. extra is appended as-is.
+func (d *desc) labelString(values []string, extra string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, labelValueEscaper.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type series struct {
+	values []string
+	value  float64
+}
+
+// vec holds a value per set of label values.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (v *vec) get(values []string) *series {
+	k := v.key(values)
+	s, ok := v.series[k]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[k] = s
+	}
+
+	return s
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w)
+	for _, k := range sortedKeys(v.series) {
+		s := v.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.fqName, v.labelString(s.values, ""), formatFloat(s.value))
+	}
+}
+
+// Counter is a value which only goes up.
+type Counter struct {
+	vec
+}
+
+// NewCounter creates a counter and registers it with the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{
+		desc:   desc{fqName: name, help: help, kind: "counter", labels: labels},
+		series: map[string]*series{},
+	}}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n float64, values ...string) {
+	if n < 0 {
+		panic("counters can't decrease")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(values).value += n
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	vec
+}
+
+// NewGauge creates a gauge and registers it with the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{
+		desc:   desc{fqName: name, help: help, kind: "gauge", labels: labels},
+		series: map[string]*series{},
+	}}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// Set sets the gauge.
+func (g *Gauge) Set(n float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(values).value = n
+}
+
+// Add adds n to the gauge. n can be negative.
+func (g *Gauge) Add(n float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(values).value += n
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// NewHistogram creates a histogram and registers it with the default
+// registry. If buckets is nil, DefaultBuckets is used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{
+		desc:    desc{fqName: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(n float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := h.key(values)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[k] = s
+	}
+
+	for i, b := range h.buckets {
+		if n <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += n
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+
+	var keys []string
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+		for i, b := range h.buckets {
+			le := fmt.Sprintf("le=%q", formatFloat(b))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelString(s.values, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelString(s.values, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, h.labelString(s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, h.labelString(s.values, ""), s.count)
+	}
+}
+
+func sortedKeys(m map[string]*series) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
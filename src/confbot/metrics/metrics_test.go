@@ -0,0 +1,13 @@
+package metrics
+
+import "testing"
+
+func TestLabelStringEscaping(t *testing.T) {
+	d := desc{fqName: "test", labels: []string{"value"}}
+
+	got := d.labelString([]string{"a\\b \"c\"\nd é\t"}, "")
+	want := `{value="a\\b \"c\"\nd é` + "\t" + `"}`
+	if got != want {
+		t.Fatalf("labelString() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package confbot
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ppkLineLength is the length of base64 lines in a PPK file.
+	ppkLineLength = 64
+
+	ppkV2MACKey = "putty-private-key-file-mac-key"
+)
+
+// encodePPK converts a PEM encoded private key to an unencrypted PuTTY
+// private key file. Version 2 is read by every PuTTY release, version 3 is
+// the default since PuTTY 0.75.
+func encodePPK(pemBytes []byte, version int, comment string) ([]byte, error) {
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key failed: %v", err)
+	}
+
+	var pub ssh.PublicKey
+	var private []byte
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if pub, err = ssh.NewPublicKey(&k.PublicKey); err != nil {
+			return nil, err
+		}
+
+		k.Precompute()
+		private = ssh.Marshal(struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+		}{k.D, k.Primes[0], k.Primes[1], k.Precomputed.Qinv})
+	case *ecdsa.PrivateKey:
+		if pub, err = ssh.NewPublicKey(&k.PublicKey); err != nil {
+			return nil, err
+		}
+
+		private = ssh.Marshal(struct {
+			D *big.Int
+		}{k.D})
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for ppk", key)
+	}
+
+	var mac hash.Hash
+	switch version {
+	case 2:
+		macKey := sha1.Sum([]byte(ppkV2MACKey))
+		mac = hmac.New(sha1.New, macKey[:])
+	case 3:
+		// unencrypted version 3 files use an empty MAC key.
+		mac = hmac.New(sha256.New, []byte{})
+	default:
+		return nil, fmt.Errorf("unsupported ppk version %d", version)
+	}
+
+	algorithm := pub.Type()
+	encryption := "none"
+	public := pub.Marshal()
+
+	for _, field := range [][]byte{[]byte(algorithm), []byte(encryption), []byte(comment), public, private} {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(field)))
+		mac.Write(l[:])
+		mac.Write(field)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PuTTY-User-Key-File-%d: %s\n", version, algorithm)
+	fmt.Fprintf(&buf, "Encryption: %s\n", encryption)
+	fmt.Fprintf(&buf, "Comment: %s\n", comment)
+	writePPKLines(&buf, "Public-Lines", public)
+	writePPKLines(&buf, "Private-Lines", private)
+	fmt.Fprintf(&buf, "Private-MAC: %x\n", mac.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+func writePPKLines(buf *bytes.Buffer, header string, b []byte) {
+	encoded := base64.StdEncoding.EncodeToString(b)
+
+	var lines []string
+	for len(encoded) > ppkLineLength {
+		lines = append(lines, encoded[:ppkLineLength])
+		encoded = encoded[ppkLineLength:]
+	}
+	lines = append(lines, encoded)
+
+	fmt.Fprintf(buf, "%s: %d\n", header, len(lines))
+	for _, line := range lines {
+		fmt.Fprintf(buf, "%s\n", line)
+	}
+}
@@ -0,0 +1,224 @@
+package confbot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// Project statuses.
+	ProjectBooting      = "booting"
+	ProjectInstalling   = "installing"
+	ProjectProvisioning = "provisioning"
+	ProjectReady        = "ready"
+	ProjectFailed       = "failed"
+	ProjectDeleting     = "deleting"
+	ProjectUnknown      = "unknown"
+
+	// maxProjectTransitions is the amount of status changes kept for a project.
+	maxProjectTransitions = 50
+)
+
+// ProjectTransition is a change in a project's status.
+type ProjectTransition struct {
+	Status string    `json:"status"`
+	Step   string    `json:"step,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Project is the state of an attendee's project.
+type Project struct {
+	ID          string              `json:"id"`
+	UserID      string              `json:"user_id"`
+	Region      string              `json:"region,omitempty"`
+	Status      string              `json:"status"`
+	Step        string              `json:"step,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Transitions []ProjectTransition `json:"transitions,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// Hostname is the hostname of the project's shell droplet.
+func (p *Project) Hostname() string {
+	return "shell." + p.ID + "." + DropletDomain
+}
+
+// BootFailed returns true if the project's shell couldn't be booted. The
+// project is kept so the failure can be seen, until the shell is booted again.
+func (p *Project) BootFailed() bool {
+	return p.Status == ProjectFailed && p.Step == operationBoot
+}
+
+// ProjectDroplet is a droplet which belongs to a project.
+type ProjectDroplet struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Region string `json:"region"`
+	Status string `json:"status"`
+	IP     string `json:"ip,omitempty"`
+}
+
+// ProjectRecord is a DNS record which belongs to a project.
+type ProjectRecord struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+// ProjectResources are the cloud resources which belong to a project.
+type ProjectResources struct {
+	Droplets []ProjectDroplet `json:"droplets"`
+	Records  []ProjectRecord  `json:"records"`
+}
+
+// LoadProject returns a project. Projects created before their state was
+// recorded have the status ProjectUnknown.
+func LoadProject(repo Repo, projectID string) (*Project, error) {
+	p, err := repo.Project(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil {
+		p = &Project{ID: projectID, Status: ProjectUnknown}
+	}
+
+	if p.UserID == "" {
+		if p.UserID, err = repo.User(projectID); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// ListProjects returns all projects.
+func ListProjects(repo Repo) ([]Project, error) {
+	ids, err := repo.ProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []Project
+	for _, id := range ids {
+		p, err := LoadProject(repo, id)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, *p)
+	}
+
+	return projects, nil
+}
+
+// FindProjectResources looks up the droplets and DNS records for projects.
+// DNS records are listed once with the master token, and droplets are
+// listed once for each token used by the projects.
+func FindProjectResources(repo Repo, masterToken string, projectIDs []string) (map[string]*ProjectResources, error) {
+	out := map[string]*ProjectResources{}
+	byToken := map[string][]string{}
+	for _, id := range projectIDs {
+		out[id] = &ProjectResources{}
+
+		token, err := repo.Token(id)
+		if err != nil {
+			return nil, err
+		}
+		byToken[token] = append(byToken[token], id)
+	}
+
+	recs, err := listRecords(buildDoClient(masterToken))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range recs {
+		for _, id := range projectIDs {
+			if strings.HasSuffix(rec.Name, id) {
+				out[id].Records = append(out[id].Records, ProjectRecord{
+					ID:   rec.ID,
+					Type: rec.Type,
+					Name: rec.Name,
+					Data: rec.Data,
+				})
+			}
+		}
+	}
+
+	for token, ids := range byToken {
+		if token == "" {
+			continue
+		}
+
+		droplets, err := listDroplets(buildDoClient(token))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range droplets {
+			for _, id := range ids {
+				if strings.HasSuffix(d.Name, id) {
+					ip, _ := d.PublicIPv4()
+					region := ""
+					if d.Region != nil {
+						region = d.Region.Slug
+					}
+
+					out[id].Droplets = append(out[id].Droplets, ProjectDroplet{
+						ID:     d.ID,
+						Name:   d.Name,
+						Region: region,
+						Status: d.Status,
+						IP:     ip,
+					})
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// updateProject changes a project's state. Failing to record state isn't
+// fatal, so errors are logged.
+func updateProject(repo Repo, log *logrus.Entry, projectID string, fn func(*Project)) {
+	p, err := repo.Project(projectID)
+	if err != nil {
+		log.WithError(err).WithField("project-id", projectID).Error("unable to load project state")
+		return
+	}
+
+	now := time.Now()
+	if p == nil {
+		p = &Project{ID: projectID, CreatedAt: now}
+	}
+
+	status, step := p.Status, p.Step
+	fn(p)
+	p.UpdatedAt = now
+
+	if p.Status != status || p.Step != step {
+		p.Transitions = append(p.Transitions, ProjectTransition{Status: p.Status, Step: p.Step, At: now})
+		if len(p.Transitions) > maxProjectTransitions {
+			p.Transitions = p.Transitions[len(p.Transitions)-maxProjectTransitions:]
+		}
+	}
+
+	if err := repo.SaveProject(*p); err != nil {
+		log.WithError(err).WithField("project-id", projectID).Error("unable to save project state")
+	}
+}
+
+// setProjectStatus changes a project's status. errMsg is recorded if the
+// project failed.
+func setProjectStatus(repo Repo, log *logrus.Entry, projectID, status, step, errMsg string) {
+	updateProject(repo, log, projectID, func(p *Project) {
+		p.Status = status
+		p.Step = step
+		p.Error = errMsg
+	})
+}
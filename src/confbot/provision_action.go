@@ -1,6 +1,8 @@
 package confbot
 
 import (
+	"time"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/nlopes/slack"
 
@@ -8,25 +10,64 @@ import (
 )
 
 type provision struct {
-	ctx       context.Context
-	log       *logrus.Entry
-	repo      Repo
-	userID    string
-	projectID string
-	slack     *slack.Client
-	channel   string
+	ctx         context.Context
+	log         *logrus.Entry
+	repo        Repo
+	masterToken string
+	userID      string
+	projectID   string
+	slack       *slack.Client
+	channel     string
+	events      *EventBus
+
+	// state is the running state and when it started.
+	state      string
+	stateStart time.Time
 }
 
-func NewProvision(ctx context.Context, userID, projectID, channel string, repo Repo, s *slack.Client) *provision {
+func NewProvision(ctx context.Context, userID, projectID, channel, masterToken string, repo Repo, s *slack.Client) *provision {
 	return &provision{
-		log:       logFromContext(ctx),
-		repo:      repo,
-		userID:    userID,
-		projectID: projectID,
-		ctx:       ctx,
-		slack:     s,
-		channel:   channel,
+		log:         LogFromContext(ctx),
+		repo:        repo,
+		masterToken: masterToken,
+		userID:      userID,
+		projectID:   projectID,
+		ctx:         ctx,
+		slack:       s,
+		channel:     channel,
+		events:      EventBusFromContext(ctx),
+	}
+}
+
+// publish sends an event for the project.
+func (p *provision) publish(ev Event) {
+	ev.ProjectID = p.projectID
+	ev.UserID = p.userID
+	ev.Operation = operationProvision
+	p.events.Publish(ev)
+}
+
+// enterState records the end of the previous state, and returns a logger for
+// the new state.
+func (p *provision) enterState(name string) *logrus.Entry {
+	p.finishState(nil)
+	p.state = name
+	p.stateStart = time.Now()
+	p.publish(Event{Type: EventStepStarted, Step: name})
+
+	return p.log.WithField("provision-state", name)
+}
+
+func (p *provision) finishState(err error) {
+	if p.state != "" {
+		ev := Event{Type: EventStepFinished, Step: p.state, Duration: time.Since(p.stateStart)}
+		if err != nil {
+			ev.Type = EventStepFailed
+			ev.Error = err.Error()
+		}
+		p.publish(ev)
 	}
+	p.state = ""
 }
 
 func (p *provision) Run() {
@@ -36,7 +77,7 @@ func (p *provision) Run() {
 }
 
 // CreateProvisionAction creates a provision action.
-func CreateProvisionAction(ctx context.Context, repo Repo) ActionFn {
+func CreateProvisionAction(ctx context.Context, masterToken string, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
 		userID := m.User
 		projectID, err := repo.ProjectID(userID)
@@ -49,10 +90,10 @@ func CreateProvisionAction(ctx context.Context, repo Repo) ActionFn {
 			return err
 		}
 
-		log := logFromContext(ctx).WithFields(logrus.Fields{"user-id": userID})
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID})
 		log.Info("creating provisioner")
 
-		p := NewProvision(ctx, userID, projectID, channelID, repo, slackClient)
+		p := NewProvision(ctx, userID, projectID, channelID, masterToken, repo, slackClient)
 		p.Run()
 
 		return nil
@@ -3,7 +3,6 @@ package confbot
 import (
 	"errors"
 	"fmt"
-	"net"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -14,23 +13,14 @@ type provisionStateFn func(*provision) provisionStateFn
 
 func provisionInitState(p *provision) provisionStateFn {
 	p.log.WithField("provision-state", "initState").Info("running initState")
-	params := slack.NewPostMessageParameters()
-	msg := "*Provisioning process started*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
+	p.publish(Event{Type: EventProvisionStarted})
 	return provisionInfraState
 }
 
 func provisionInfraState(p *provision) provisionStateFn {
-	log := p.log.WithField("provision-state", "infraState")
+	log := p.enterState("infraState")
 	sshClient := NewSSHClient(p.ctx, p.projectID, p.repo)
 
-	params := slack.NewPostMessageParameters()
-	msg := "*... Creating hosts and certificates*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
 	out, err := sshClient.Execute("shell", "cd /home/workshop/infra && ./setup.sh")
 	if err != nil {
 		log.WithError(err).Error("execute ssh")
@@ -41,8 +31,17 @@ func provisionInfraState(p *provision) provisionStateFn {
 		return provisionErrorStateGen(err)
 	}
 
-	msg = "*... Hosts and certificats are up to date*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
+	return provisionSSHCAState
+}
+
+// provisionSSHCAState makes the hosts created by the infra setup trust the
+// confbot CA like the shell droplet does, so certificates work on all of
+// the project's hosts.
+func provisionSSHCAState(p *provision) provisionStateFn {
+	log := p.enterState("sshCAState")
+
+	if err := configureHostsSSHCA(p.ctx, p.repo, p.masterToken, p.projectID); err != nil {
+		log.WithError(err).Error("configure ssh ca")
 		return provisionErrorStateGen(err)
 	}
 
@@ -50,15 +49,9 @@ func provisionInfraState(p *provision) provisionStateFn {
 }
 
 func provisionCertsState(p *provision) provisionStateFn {
-	log := p.log.WithField("provision-state", "certState")
+	log := p.enterState("certState")
 	sshClient := NewSSHClient(p.ctx, p.projectID, p.repo)
 
-	params := slack.NewPostMessageParameters()
-	msg := "*...Making sure root certificates are up to date*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
-
 	_, err := sshClient.Execute("shell", `sudo perl -pi -e 's/^\!//' /etc/ca-certificates.conf`)
 	if err != nil {
 		log.WithError(err).Error("verify ca certificates")
@@ -79,15 +72,9 @@ func provisionCertsState(p *provision) provisionStateFn {
 }
 
 func provisionAnsibleState(p *provision) provisionStateFn {
-	log := p.log.WithField("provision-state", "ansibleState")
+	log := p.enterState("ansibleState")
 	sshClient := NewSSHClient(p.ctx, p.projectID, p.repo)
 
-	params := slack.NewPostMessageParameters()
-	msg := "*... Provisioning services with Ansible. This may take some time*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
-
 	out, err := sshClient.Execute("shell", "cd /home/workshop/ansible && ./setup.sh")
 	if err != nil {
 		log.WithError(err).Error("execute ssh")
@@ -98,23 +85,11 @@ func provisionAnsibleState(p *provision) provisionStateFn {
 		return provisionErrorStateGen(err)
 	}
 
-	msg = "*... Ansible provisioning is complete*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
-
 	return provisionEsState
 }
 
 func provisionEsState(p *provision) provisionStateFn {
-	log := p.log.WithField("provision-state", "esState")
-	sshClient := NewSSHClient(p.ctx, p.projectID, p.repo)
-
-	params := slack.NewPostMessageParameters()
-	msg := "*... Waiting for ElasticSearch to become available*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
+	log := p.enterState("esState")
 
 	c := 1
 	for {
@@ -124,10 +99,9 @@ func provisionEsState(p *provision) provisionStateFn {
 
 		host := fmt.Sprintf("app.%s.%s:9200", p.projectID, DropletDomain)
 		log.WithField("count", c).Info("check to see if elasticsearch is up")
-		conn, err := net.DialTimeout("tcp", host, time.Minute*1)
+		err := CurrentHostExecutor.Probe(host, time.Minute*1)
 		if err == nil {
 			log.WithField("count", c).Info("elasticsearch is up")
-			conn.Close()
 			break
 		}
 
@@ -135,17 +109,14 @@ func provisionEsState(p *provision) provisionStateFn {
 		c++
 	}
 
-	msg = "*... ElasticSearch is up and listening*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
+	return provisionEsTemplatesState
+}
 
-	msg = "*... Uploading ElasticSearch templates*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
+func provisionEsTemplatesState(p *provision) provisionStateFn {
+	log := p.enterState("esTemplatesState")
+	sshClient := NewSSHClient(p.ctx, p.projectID, p.repo)
 
-	out, err := sshClient.Execute("shell", "curl https://s3.pifft.com/oscon2016/create-beats.sh | bash")
+	out, err := sshClient.Execute("shell", fmt.Sprintf("curl %s | bash", CurrentWorkshop.BeatsScriptURL))
 	if err != nil {
 		log.WithError(err).Error("create beats")
 		return provisionErrorStateGen(err)
@@ -155,38 +126,26 @@ func provisionEsState(p *provision) provisionStateFn {
 		return provisionErrorStateGen(err)
 	}
 
-	msg = "* ... ElasticSearch templates have been uploaded*"
-	if _, _, err := p.slack.PostMessage(p.channel, msg, params); err != nil {
-		return provisionErrorStateGen(err)
-	}
-
 	return provisionCompleteState
 }
 
 func provisionErrorStateGen(err error) provisionStateFn {
 	return func(p *provision) provisionStateFn {
-		params := slack.NewPostMessageParameters()
-		p.log.WithField("provision-state", "errorState").WithError(err).Error("provision failed")
-		msg := "*Provisioning process Failed* All was not well with the provisioning process. " +
-			"This is expected as the cloud is a chaotic environment. To restart the provision process " +
-			"issue the `./provision` command. You can also log into your shell and run ansible by hand. " +
-			"Issue the `./configure ssh <type>` command substituting <type> with *linux*, *mac, or *windows*. " +
-			"After SSH is setup, ssh to `workshop@shell." + p.projectID + "." + DropletDomain + "."
+		step := p.state
+		p.finishState(err)
 
-		_, _, _ = p.slack.PostMessage(p.channel, msg, params)
+		p.log.WithField("provision-state", "errorState").WithError(err).Error("provision failed")
+		p.publish(Event{Type: EventProvisionFailed, Step: step, Error: err.Error()})
 		return nil
 	}
 }
 
 func provisionCompleteState(p *provision) provisionStateFn {
+	p.finishState(nil)
+
 	log := p.log.WithField("provision-state", "completeState")
 	log.Info("provision complete")
-	params := slack.NewPostMessageParameters()
-	msg := "Your environment is ready to go. Before you can use it, you will " +
-		"need to configure your ssh client. I can assist you with directions " +
-		"for Linux, Mac, or Windows. To start this process, issue the `./configure ssh <type>` " +
-		"command substituting <type> with *linux*, *mac*, or *windows*."
-	_, _, _ = p.slack.PostMessage(p.channel, msg, params)
+	p.publish(Event{Type: EventProvisionFinished})
 	return nil
 }
 
@@ -0,0 +1,312 @@
+package confbot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+	"golang.org/x/net/context"
+)
+
+const (
+	// regionRefreshInterval is how long the list of available regions is
+	// trusted before it is fetched again.
+	regionRefreshInterval = 10 * time.Minute
+
+	// Region selection policies.
+	RegionPolicyRandom  = "random"
+	RegionPolicySpread  = "spread"
+	RegionPolicyNearest = "nearest"
+	RegionPolicyPinned  = "pinned"
+)
+
+var (
+	// ErrNoRegions is returned when there are no regions that can boot shell droplets.
+	ErrNoRegions = errors.New("no regions are available for shell droplets")
+
+	// regionLocations are the approximate locations of DigitalOcean regions.
+	regionLocations = map[string]Location{
+		"nyc1": {40.71, -74.01},
+		"nyc2": {40.71, -74.01},
+		"nyc3": {40.71, -74.01},
+		"tor1": {43.65, -79.38},
+		"sfo1": {37.77, -122.42},
+		"sfo2": {37.77, -122.42},
+		"fra1": {50.11, 8.68},
+		"lon1": {51.51, -0.13},
+		"ams2": {52.37, 4.90},
+		"ams3": {52.37, 4.90},
+		"sgp1": {1.35, 103.82},
+		"blr1": {12.97, 77.59},
+	}
+)
+
+// Location is a point on the globe.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// ParseLocation parses a location in the form of "latitude,longitude".
+func ParseLocation(s string) (Location, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Location{}, fmt.Errorf("location %q is not in the form of latitude,longitude", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid latitude in location %q: %v", s, err)
+	}
+
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("invalid longitude in location %q: %v", s, err)
+	}
+
+	return Location{Latitude: lat, Longitude: lng}, nil
+}
+
+// distance returns the great circle distance between two locations in kilometers.
+func (l Location) distance(o Location) float64 {
+	const earthRadius = 6371.0
+
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := rad(o.Latitude - l.Latitude)
+	dLng := rad(o.Longitude - l.Longitude)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(l.Latitude))*math.Cos(rad(o.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// RegionPolicy orders available regions from most to least preferred.
+type RegionPolicy interface {
+	Order(regions []string, usage map[string]int) []string
+}
+
+// NewRegionPolicy creates a RegionPolicy by name. Pinned regions are used by the
+// pinned policy, and location is used by the nearest policy.
+func NewRegionPolicy(name string, pinned []string, location string) (RegionPolicy, error) {
+	switch name {
+	case RegionPolicyRandom, "":
+		return randomRegionPolicy{}, nil
+	case RegionPolicySpread:
+		return spreadRegionPolicy{}, nil
+	case RegionPolicyNearest:
+		loc, err := ParseLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		return nearestRegionPolicy{location: loc}, nil
+	case RegionPolicyPinned:
+		if len(pinned) == 0 {
+			return nil, errors.New("pinned region policy requires at least one region")
+		}
+		return pinnedRegionPolicy{regions: pinned}, nil
+	default:
+		return nil, fmt.Errorf("unknown region policy %q", name)
+	}
+}
+
+type randomRegionPolicy struct{}
+
+func (randomRegionPolicy) Order(regions []string, usage map[string]int) []string {
+	out := make([]string, len(regions))
+	for i, j := range rand.Perm(len(regions)) {
+		out[i] = regions[j]
+	}
+	return out
+}
+
+// spreadRegionPolicy prefers the regions with the least shells booted.
+type spreadRegionPolicy struct{}
+
+func (spreadRegionPolicy) Order(regions []string, usage map[string]int) []string {
+	out := randomRegionPolicy{}.Order(regions, usage)
+	sort.SliceStable(out, func(i, j int) bool {
+		return usage[out[i]] < usage[out[j]]
+	})
+	return out
+}
+
+// nearestRegionPolicy prefers the regions closest to the conference.
+type nearestRegionPolicy struct {
+	location Location
+}
+
+func (p nearestRegionPolicy) Order(regions []string, usage map[string]int) []string {
+	var known, unknown []string
+	for _, r := range regions {
+		if _, ok := regionLocations[r]; ok {
+			known = append(known, r)
+		} else {
+			unknown = append(unknown, r)
+		}
+	}
+
+	sort.SliceStable(known, func(i, j int) bool {
+		return p.location.distance(regionLocations[known[i]]) < p.location.distance(regionLocations[known[j]])
+	})
+
+	return append(known, unknown...)
+}
+
+// pinnedRegionPolicy only uses the configured regions in the configured order.
+type pinnedRegionPolicy struct {
+	regions []string
+}
+
+func (p pinnedRegionPolicy) Order(regions []string, usage map[string]int) []string {
+	var out []string
+	for _, pinned := range p.regions {
+		for _, r := range regions {
+			if r == pinned {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// RegionSelector selects regions for shell droplets using the regions
+// DigitalOcean reports as available for the droplet size.
+type RegionSelector struct {
+	mu          sync.Mutex
+	client      *godo.Client
+	policy      RegionPolicy
+	available   []string
+	usage       map[string]int
+	projects    map[string]string
+	refreshedAt time.Time
+	log         *logrus.Entry
+}
+
+// NewRegionSelector creates an instance of RegionSelector. client lists
+// the available regions.
+func NewRegionSelector(ctx context.Context, client *godo.Client, policy RegionPolicy) *RegionSelector {
+	return &RegionSelector{
+		client:   client,
+		policy:   policy,
+		usage:    map[string]int{},
+		projects: map[string]string{},
+		log:      LogFromContext(ctx).WithField("component", "region-selector"),
+	}
+}
+
+// Regions returns the regions a shell droplet can be booted in, from most
+// to least preferred.
+func (rs *RegionSelector) Regions() ([]string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if time.Since(rs.refreshedAt) > regionRefreshInterval {
+		available, err := listAvailableRegions(rs.client, CurrentWorkshop.Droplet.Size)
+		if err != nil {
+			rs.log.WithError(err).Error("unable to list regions")
+			if len(rs.available) == 0 {
+				return nil, err
+			}
+		} else {
+			rs.available = available
+			rs.refreshedAt = time.Now()
+		}
+	}
+
+	regions := rs.policy.Order(rs.available, rs.usage)
+	if len(regions) == 0 {
+		return nil, ErrNoRegions
+	}
+
+	return regions, nil
+}
+
+// Used records a project's shell droplet was booted in region.
+func (rs *RegionSelector) Used(projectID, region string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if _, ok := rs.projects[projectID]; ok {
+		return
+	}
+
+	rs.projects[projectID] = region
+	rs.usage[region]++
+}
+
+// Released records a project's shell droplet was deleted.
+func (rs *RegionSelector) Released(projectID string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	region, ok := rs.projects[projectID]
+	if !ok {
+		return
+	}
+
+	delete(rs.projects, projectID)
+	if rs.usage[region] > 0 {
+		rs.usage[region]--
+	}
+}
+
+// Failed records region could not boot a shell droplet. It will not be
+// used until the list of available regions is fetched again, which is
+// right away once every region has failed.
+func (rs *RegionSelector) Failed(region string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var available []string
+	for _, r := range rs.available {
+		if r != region {
+			available = append(available, r)
+		}
+	}
+	rs.available = available
+
+	if len(available) == 0 {
+		rs.log.Warn("every region has failed, fetching regions again")
+		rs.refreshedAt = time.Time{}
+	}
+}
+
+func listAvailableRegions(client *godo.Client, size string) ([]string, error) {
+	list := []string{}
+	opt := &godo.ListOptions{}
+	for {
+		regions, resp, err := client.Regions.List(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, region := range regions {
+			if region.Available && any(region.Sizes, func(s string) bool { return s == size }) {
+				list = append(list, region.Slug)
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
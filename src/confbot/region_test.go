@@ -0,0 +1,65 @@
+package confbot
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+)
+
+func TestIsRegionUnavailable(t *testing.T) {
+	response := func(status int, message string) error {
+		return &godo.ErrorResponse{
+			Response: &http.Response{StatusCode: status},
+			Message:  message,
+		}
+	}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{response(http.StatusUnprocessableEntity, "Region is not available"), true},
+		{response(http.StatusUnprocessableEntity, "You have reached your droplet limit"), false},
+		{response(http.StatusUnauthorized, "Unable to authenticate you"), false},
+		{response(http.StatusTooManyRequests, "Too many requests"), false},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isRegionUnavailable(tt.err); got != tt.want {
+			t.Errorf("isRegionUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRegionSelectorUsage(t *testing.T) {
+	rs := &RegionSelector{
+		policy:      spreadRegionPolicy{},
+		available:   []string{"nyc3", "lon1"},
+		usage:       map[string]int{},
+		projects:    map[string]string{},
+		refreshedAt: time.Now(),
+		log:         logrus.NewEntry(logrus.New()),
+	}
+
+	rs.Used("a", "nyc3")
+	rs.Used("a", "nyc3")
+	if got := rs.usage["nyc3"]; got != 1 {
+		t.Fatalf("usage after booting one project twice = %d, want 1", got)
+	}
+
+	rs.Released("a")
+	if got := rs.usage["nyc3"]; got != 0 {
+		t.Fatalf("usage after deleting the project = %d, want 0", got)
+	}
+
+	rs.Failed("nyc3")
+	rs.Failed("lon1")
+	if !rs.refreshedAt.IsZero() {
+		t.Fatalf("regions aren't fetched again once every region has failed")
+	}
+}
@@ -1,9 +1,11 @@
 package confbot
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -36,6 +38,40 @@ type Repo interface {
 	Token(projectID string) (string, error)
 	GetKey(projectID string) ([]byte, error)
 	SaveKey(projectID string, privateKey []byte) error
+	AddCleanup(r Resource) error
+	RemoveCleanup(r Resource) error
+	Cleanups() ([]Resource, error)
+	ProjectIDs() ([]string, error)
+	CAKey() ([]byte, error)
+	SaveCAKey(key []byte) error
+	NextCertificateSerial() (uint64, error)
+	SaveCertificate(c IssuedCertificate) error
+	RemoveCertificate(serial uint64) error
+	Certificates() ([]IssuedCertificate, error)
+	SaveDownload(d Download) error
+	TakeDownload(id string) (*Download, error)
+	AddDownloadAudit(a DownloadAudit) error
+	DownloadAudits() ([]DownloadAudit, error)
+	SaveWebhookSecret(projectID, secret string) error
+	WebhookSecret(projectID string) (string, error)
+	MarkWebhookSeen(signature string, ttl time.Duration) (bool, error)
+	UnmarkWebhookSeen(signature string) error
+	WebhookEvent(projectID, key string) (*WebhookEvent, error)
+	CreateWebhookEvent(ev WebhookEvent) (*WebhookEvent, error)
+	UpdateWebhookEvent(ev WebhookEvent) error
+	UnfinishedWebhookEvents() ([]WebhookEvent, error)
+	RemoveWebhookEvent(projectID, key string) error
+	Project(projectID string) (*Project, error)
+	SaveProject(p Project) error
+	PoolTokens() ([]string, error)
+	AddPoolToken(token string) error
+	RemovePoolToken(token string) error
+	AddHistory(ev Event) error
+	History(projectID string) ([]Event, error)
+	AddAuditEntry(e AuditEntry) error
+	AuditEntries(f AuditFilter) ([]AuditEntry, error)
+	Locale(userID string) (string, error)
+	SetLocale(userID, locale string) error
 }
 
 // NewRepo creates an instance of Repo. Repo is currently
@@ -56,7 +92,7 @@ func NewRepo(ctx context.Context, redisURL, env string) (Repo, error) {
 	repo := &redisRepo{
 		pool:      p,
 		namespace: strings.Join([]string{baseNamespace, env}, keySeperator),
-		log:       logFromContext(ctx),
+		log:       LogFromContext(ctx),
 	}
 
 	if err := repo.Ping(); err != nil {
@@ -126,6 +162,16 @@ func (rr *redisRepo) ResetProject(userID string) error {
 				log.WithField("items-deleted", i).WithError(err).Error("unable to delete project")
 				return err
 			}
+
+			if _, err := conn.Cmd("HDEL", rr.key("webhook-secrets"), k).Int(); err != nil {
+				log.WithError(err).Error("unable to delete webhook secret")
+				return err
+			}
+
+			if _, err := conn.Cmd("HDEL", rr.key("project-state"), k).Int(); err != nil {
+				log.WithError(err).Error("unable to delete project state")
+				return err
+			}
 		}
 	}
 
@@ -273,6 +319,714 @@ func (rr *redisRepo) Token(projectID string) (string, error) {
 	return id, nil
 }
 
-func (rr *redisRepo) key(suffix string) string {
-	return strings.Join([]string{rr.namespace, suffix}, keySeperator)
+func (rr *redisRepo) AddCleanup(r Resource) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("cleanup")
+	_, err = conn.Cmd("HSET", k, r.String(), b).Int()
+
+	return err
+}
+
+func (rr *redisRepo) RemoveCleanup(r Resource) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("cleanup")
+	_, err = conn.Cmd("HDEL", k, r.String()).Int()
+
+	return err
+}
+
+func (rr *redisRepo) Cleanups() ([]Resource, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("cleanup")
+	m, err := conn.Cmd("HGETALL", k).Map()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []Resource{}
+	for field, v := range m {
+		var r Resource
+		if err := json.Unmarshal([]byte(v), &r); err != nil {
+			rr.log.WithError(err).WithField("resource", field).Error("unable to decode cleanup resource")
+			continue
+		}
+		resources = append(resources, r)
+	}
+
+	return resources, nil
+}
+
+func (rr *redisRepo) ProjectIDs() ([]string, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("projects")
+	return conn.Cmd("HVALS", k).List()
+}
+
+func (rr *redisRepo) CAKey() ([]byte, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("ca-key")
+	r := conn.Cmd("GET", k)
+	if r.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	return r.Bytes()
+}
+
+// SaveCAKey saves the CA key unless one has already been saved.
+func (rr *redisRepo) SaveCAKey(key []byte) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("ca-key")
+	_, err = conn.Cmd("SETNX", k, key).Int()
+
+	return err
+}
+
+func (rr *redisRepo) NextCertificateSerial() (uint64, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return 0, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("ca-serial")
+	i, err := conn.Cmd("INCR", k).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(i), nil
+}
+
+func (rr *redisRepo) SaveCertificate(c IssuedCertificate) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("certificates")
+	_, err = conn.Cmd("HSET", k, c.Serial, b).Int()
+
+	return err
+}
+
+func (rr *redisRepo) RemoveCertificate(serial uint64) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("certificates")
+	_, err = conn.Cmd("HDEL", k, serial).Int()
+
+	return err
+}
+
+func (rr *redisRepo) Certificates() ([]IssuedCertificate, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("certificates")
+	m, err := conn.Cmd("HGETALL", k).Map()
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []IssuedCertificate{}
+	for field, v := range m {
+		var c IssuedCertificate
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			rr.log.WithError(err).WithField("serial", field).Error("unable to decode certificate")
+			continue
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+func (rr *redisRepo) SaveDownload(d Download) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	remaining := time.Until(d.ExpiresAt)
+	if remaining <= 0 {
+		return fmt.Errorf("download %s has already expired", d.ID)
+	}
+	ttl := int(remaining.Seconds()) + 1
+
+	k := rr.key("downloads", d.ID)
+	return conn.Cmd("SET", k, b, "EX", ttl).Err
+}
+
+// TakeDownload returns a download and removes it. If the download doesn't
+// exist, or another caller took it first, nil is returned.
+func (rr *redisRepo) TakeDownload(id string) (*Download, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("downloads", id)
+	r := conn.Cmd("GET", k)
+	if r.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	// only the caller that deletes the download gets to use it.
+	i, err := conn.Cmd("DEL", k).Int()
+	if err != nil {
+		return nil, err
+	}
+
+	if i == 0 {
+		return nil, nil
+	}
+
+	var d Download
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// AddDownloadAudit appends a download audit event. Only the last
+// maxDownloadAudits events are kept.
+func (rr *redisRepo) AddDownloadAudit(a DownloadAudit) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("downloads-audit")
+	if err := conn.Cmd("RPUSH", k, b).Err; err != nil {
+		return err
+	}
+
+	return conn.Cmd("LTRIM", k, -maxDownloadAudits, -1).Err
+}
+
+// DownloadAudits returns the kept download audit events, oldest first.
+func (rr *redisRepo) DownloadAudits() ([]DownloadAudit, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("downloads-audit")
+	l, err := conn.Cmd("LRANGE", k, -maxDownloadAudits, -1).List()
+	if err != nil {
+		return nil, err
+	}
+
+	audits := []DownloadAudit{}
+	for _, v := range l {
+		var a DownloadAudit
+		if err := json.Unmarshal([]byte(v), &a); err != nil {
+			rr.log.WithError(err).Error("unable to decode download audit")
+			continue
+		}
+		audits = append(audits, a)
+	}
+
+	return audits, nil
+}
+
+func (rr *redisRepo) SaveWebhookSecret(projectID, secret string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("webhook-secrets")
+	_, err = conn.Cmd("HSET", k, projectID, secret).Int()
+
+	return err
+}
+
+func (rr *redisRepo) WebhookSecret(projectID string) (string, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return "", err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("webhook-secrets")
+	r := conn.Cmd("HGET", k, projectID)
+	if r.IsType(redis.Nil) {
+		return "", nil
+	}
+
+	return r.Str()
+}
+
+// MarkWebhookSeen records a webhook signature. It returns false if the
+// signature has been seen before.
+func (rr *redisRepo) MarkWebhookSeen(signature string, ttl time.Duration) (bool, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return false, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("webhook-signatures", signature)
+	r := conn.Cmd("SET", k, 1, "EX", int(ttl.Seconds()), "NX")
+	if r.Err != nil {
+		return false, r.Err
+	}
+
+	return !r.IsType(redis.Nil), nil
+}
+
+// UnmarkWebhookSeen forgets a webhook signature, so it can be used again.
+func (rr *redisRepo) UnmarkWebhookSeen(signature string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("webhook-signatures", signature)
+	return conn.Cmd("DEL", k).Err
+}
+
+// WebhookEvent returns a project's event with a key. It returns nil if there
+// is no such event.
+func (rr *redisRepo) WebhookEvent(projectID, key string) (*WebhookEvent, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	r := conn.Cmd("GET", rr.key("webhook-events", projectID, key))
+	if r.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var ev WebhookEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return nil, err
+	}
+
+	return &ev, nil
+}
+
+// CreateWebhookEvent saves ev unless the project has an event with the same
+// key. The existing event is returned if there is one.
+func (rr *redisRepo) CreateWebhookEvent(ev WebhookEvent) (*WebhookEvent, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	k := rr.key("webhook-events", ev.ProjectID, ev.Key)
+	r := conn.Cmd("SET", k, b, "EX", int(WebhookEventTTL.Seconds()), "NX")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	if !r.IsType(redis.Nil) {
+		if err := conn.Cmd("SADD", rr.key("webhook-unfinished"), k).Err; err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	existing, err := conn.Cmd("GET", k).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var out WebhookEvent
+	if err := json.Unmarshal(existing, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func (rr *redisRepo) UpdateWebhookEvent(ev WebhookEvent) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("webhook-events", ev.ProjectID, ev.Key)
+	if err := conn.Cmd("SET", k, b, "EX", int(WebhookEventTTL.Seconds())).Err; err != nil {
+		return err
+	}
+
+	if ev.Status == WebhookDone || ev.Status == WebhookFailed {
+		return conn.Cmd("SREM", rr.key("webhook-unfinished"), k).Err
+	}
+
+	return nil
+}
+
+// UnfinishedWebhookEvents returns the events which are queued or running.
+func (rr *redisRepo) UnfinishedWebhookEvents() ([]WebhookEvent, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	unfinishedKey := rr.key("webhook-unfinished")
+	keys, err := conn.Cmd("SMEMBERS", unfinishedKey).List()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []WebhookEvent
+	for _, k := range keys {
+		r := conn.Cmd("GET", k)
+		if r.IsType(redis.Nil) {
+			// the event has expired.
+			if err := conn.Cmd("SREM", unfinishedKey, k).Err; err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		b, err := r.Bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var ev WebhookEvent
+		if err := json.Unmarshal(b, &ev); err != nil {
+			return nil, err
+		}
+
+		out = append(out, ev)
+	}
+
+	return out, nil
+}
+
+func (rr *redisRepo) RemoveWebhookEvent(projectID, key string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("webhook-events", projectID, key)
+	if err := conn.Cmd("SREM", rr.key("webhook-unfinished"), k).Err; err != nil {
+		return err
+	}
+
+	return conn.Cmd("DEL", k).Err
+}
+
+// Project returns a project's state. It returns nil if no state has been
+// recorded.
+func (rr *redisRepo) Project(projectID string) (*Project, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("project-state")
+	r := conn.Cmd("HGET", k, projectID)
+	if r.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	b, err := r.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var p Project
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (rr *redisRepo) SaveProject(p Project) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("project-state")
+	return conn.Cmd("HSET", k, p.ID, b).Err
+}
+
+// PoolTokens returns the DigitalOcean tokens added to the token pool at
+// runtime.
+func (rr *redisRepo) PoolTokens() ([]string, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("pool-tokens")
+	return conn.Cmd("SMEMBERS", k).List()
+}
+
+func (rr *redisRepo) AddPoolToken(token string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("pool-tokens")
+	return conn.Cmd("SADD", k, token).Err
+}
+
+func (rr *redisRepo) RemovePoolToken(token string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("pool-tokens")
+	return conn.Cmd("SREM", k, token).Err
+}
+
+// AddHistory appends an event to its project's history.
+func (rr *redisRepo) AddHistory(ev Event) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("history", ev.ProjectID)
+	if err := conn.Cmd("RPUSH", k, b).Err; err != nil {
+		return err
+	}
+
+	if err := conn.Cmd("LTRIM", k, -maxHistory, -1).Err; err != nil {
+		return err
+	}
+
+	return conn.Cmd("EXPIRE", k, int(HistoryTTL.Seconds())).Err
+}
+
+// History returns a project's events, oldest first.
+func (rr *redisRepo) History(projectID string) ([]Event, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	k := rr.key("history", projectID)
+	items, err := conn.Cmd("LRANGE", k, 0, -1).ListBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, item := range items {
+		var ev Event
+		if err := json.Unmarshal(item, &ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// AddAuditEntry appends an entry to the audit log. Entries older than
+// AuditRetention are removed.
+func (rr *redisRepo) AddAuditEntry(e AuditEntry) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	k := rr.key("audit")
+	if err := conn.Cmd("ZADD", k, auditScore(e.At), b).Err; err != nil {
+		return err
+	}
+
+	cutoff := auditScore(time.Now().Add(-AuditRetention))
+	return conn.Cmd("ZREMRANGEBYSCORE", k, "-inf", fmt.Sprintf("(%d", cutoff)).Err
+}
+
+// AuditEntries returns the audit entries selected by a filter, oldest first.
+func (rr *redisRepo) AuditEntries(f AuditFilter) ([]AuditEntry, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.pool.Put(conn)
+
+	min, max := "-inf", "+inf"
+	if !f.Since.IsZero() {
+		min = fmt.Sprintf("%d", auditScore(f.Since))
+	}
+	if !f.Until.IsZero() {
+		max = fmt.Sprintf("%d", auditScore(f.Until))
+	}
+
+	items, err := conn.Cmd("ZRANGEBYSCORE", rr.key("audit"), min, max).ListBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	for _, item := range items {
+		var e AuditEntry
+		if err := json.Unmarshal(item, &e); err != nil {
+			return nil, err
+		}
+
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// auditScore is the sorted set score of an audit entry, in milliseconds.
+func auditScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (rr *redisRepo) key(suffix ...string) string {
+	return strings.Join(append([]string{rr.namespace}, suffix...), keySeperator)
+}
+
+// Locale returns the language a user has chosen, or "" if they haven't.
+func (rr *redisRepo) Locale(userID string) (string, error) {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return "", err
+	}
+	defer rr.pool.Put(conn)
+
+	r := conn.Cmd("HGET", rr.key("locales"), userID)
+	if r.IsType(redis.Nil) {
+		return "", nil
+	}
+
+	return r.Str()
+}
+
+// SetLocale saves the language a user has chosen. It is kept when their
+// project is reset.
+func (rr *redisRepo) SetLocale(userID, locale string) error {
+	conn, err := rr.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer rr.pool.Put(conn)
+
+	return conn.Cmd("HSET", rr.key("locales"), userID, locale).Err
 }
@@ -10,7 +10,7 @@ import (
 func CreateResetAction(ctx context.Context, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
 		userID := m.User
-		log := logFromContext(ctx).WithFields(logrus.Fields{"user-id": userID})
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID})
 
 		log.Info("reseting project")
 
@@ -20,7 +20,7 @@ func CreateResetAction(ctx context.Context, repo Repo) ActionFn {
 		}
 
 		params := slack.PostMessageParameters{}
-		if _, _, err := slackClient.PostMessage(channelID, "resetting your projects", params); err != nil {
+		if _, _, err := slackClient.PostMessage(channelID, userMessages(ctx, repo, log, userID)("reset", nil), params); err != nil {
 			return err
 		}
 
@@ -0,0 +1,159 @@
+package confbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+)
+
+// CreateRotateKeyAction returns a function that replaces the SSH key for a project.
+func CreateRotateKeyAction(ctx context.Context, masterToken string, repo Repo) ActionFn {
+	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
+		userID := m.User
+		log := LogFromContext(ctx).WithFields(logrus.Fields{"user-id": userID, "action": "rotate-key"})
+
+		_, _, channelID, err := slackClient.OpenIMChannel(userID)
+		if err != nil {
+			return err
+		}
+
+		projectID, err := repo.ProjectID(userID)
+		if err != nil {
+			return err
+		}
+
+		t := userMessages(ctx, repo, log, userID)
+		params := slack.NewPostMessageParameters()
+
+		if projectID == "" {
+			slackClient.PostMessage(channelID, t("project_not_defined", nil), params)
+			return nil
+		}
+
+		log = log.WithField("project-id", projectID)
+
+		oldKey, err := repo.GetKey(projectID)
+		if err != nil {
+			return err
+		}
+
+		hosts, err := projectHosts(buildDoClient(masterToken), projectID)
+		if err != nil {
+			return err
+		}
+
+		if _, _, err := slackClient.PostMessage(channelID, t("rotate_started", MessageVars{"ProjectID": projectID}), params); err != nil {
+			return err
+		}
+
+		kp, failed, err := rotateKey(ctx, repo, projectID, hosts, oldKey)
+		if err != nil {
+			log.WithError(err).Error("unable to rotate key")
+			msg := t("rotate_failed", MessageVars{"Error": err.Error()})
+			slackClient.PostMessage(channelID, msg, params)
+			return err
+		}
+
+		log.WithFields(logrus.Fields{"hosts": hosts, "old-key-hosts": failed}).Info("rotated key")
+
+		keyName := keyFileName(kp.private)
+		if err := deliverFile(ctx, log, repo, slackClient, channelID, userID, projectID, keyName, kp.private); err != nil {
+			return err
+		}
+
+		msg := t("rotate_finished", MessageVars{"Hosts": strings.Join(hosts, ", "), "Key": keyName})
+		if len(failed) > 0 {
+			msg = t("rotate_partial", MessageVars{"Hosts": strings.Join(hosts, ", "), "Key": keyName, "Failed": strings.Join(failed, ", ")})
+		}
+		_, _, err = slackClient.PostMessage(channelID, msg, params)
+		return err
+	}
+}
+
+// rotateKey installs a new key pair on hosts, verifies it and removes oldKey.
+// The new key is saved to the repo before the old key is removed, so a failure
+// at any point leaves the project with a key that works. The hosts oldKey
+// couldn't be removed from are returned.
+func rotateKey(ctx context.Context, repo Repo, projectID string, hosts []string, oldKey []byte) (*KeyPair, []string, error) {
+	log := LogFromContext(ctx).WithField("project-id", projectID)
+
+	oldPub, err := authorizedKeyFromPrivate(oldKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kp, err := makeSSHKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newPub := strings.TrimSpace(string(kp.public))
+
+	oldClient := NewSSHClientWithKey(ctx, projectID, oldKey)
+	for _, host := range hosts {
+		cmd := fmt.Sprintf(`grep -qxF '%[1]s' ~/.ssh/authorized_keys || echo '%[1]s' >> ~/.ssh/authorized_keys`, newPub)
+		if _, err := oldClient.Execute(host, cmd); err != nil {
+			return nil, nil, fmt.Errorf("install key on %s: %v", host, err)
+		}
+	}
+
+	newClient := NewSSHClientWithKey(ctx, projectID, kp.private)
+	for _, host := range hosts {
+		if _, err := newClient.Execute(host, "true"); err != nil {
+			return nil, nil, fmt.Errorf("verify key on %s: %v", host, err)
+		}
+	}
+
+	if err := repo.SaveKey(projectID, kp.private); err != nil {
+		return nil, nil, err
+	}
+
+	// match on the encoded key only, authorized_keys entries may have a comment.
+	fields := strings.Fields(oldPub)
+	if len(fields) < 2 {
+		log.Error("unable to find the old key to remove")
+		return kp, hosts, nil
+	}
+
+	var failed []string
+	for _, host := range hosts {
+		cmd := fmt.Sprintf(`grep -vF '%s' ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.new; `+
+			`chmod 600 ~/.ssh/authorized_keys.new && mv ~/.ssh/authorized_keys.new ~/.ssh/authorized_keys`, fields[1])
+		if _, err := newClient.Execute(host, cmd); err != nil {
+			log.WithError(err).WithField("host", host).Error("unable to remove old key")
+			failed = append(failed, host)
+		}
+	}
+
+	return kp, failed, nil
+}
+
+// projectHosts returns the hosts for a project using its DNS records.
+func projectHosts(masterClient *godo.Client, projectID string) ([]string, error) {
+	recs, err := listRecords(masterClient)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := "." + projectID
+
+	var hosts []string
+	for _, rec := range recs {
+		if rec.Type == "A" && strings.HasSuffix(rec.Name, suffix) {
+			host := strings.TrimSuffix(rec.Name, suffix)
+			if !any(hosts, func(h string) bool { return h == host }) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{"shell"}
+	}
+
+	return hosts, nil
+}
@@ -1,8 +1,6 @@
 package confbot
 
 import (
-	"fmt"
-
 	"github.com/nlopes/slack"
 
 	"golang.org/x/net/context"
@@ -22,10 +20,11 @@ func CreateSettingsAction(repo Repo) ActionFn {
 			return err
 		}
 
+		t := userMessages(ctx, repo, LogFromContext(ctx), userID)
 		params := slack.NewPostMessageParameters()
 
 		attachment := slack.Attachment{
-			Pretext: "List of URLs for your environment",
+			Pretext: t("settings", nil),
 			Fields:  createSettings(id),
 		}
 
@@ -40,13 +39,13 @@ func CreateSettingsAction(repo Repo) ActionFn {
 }
 
 func createSettings(id string) []slack.AttachmentField {
-	return []slack.AttachmentField{
+	fields := []slack.AttachmentField{
 		{Title: "Project ID", Value: id},
-		{Title: "Site URL", Value: fmt.Sprintf("http://app.%s.%s:8888", id, DropletDomain), Short: false},
-		{Title: "Consul URL", Value: fmt.Sprintf("http://app.%s.%s", id, DropletDomain), Short: false},
-		{Title: "Jenkins URL", Value: fmt.Sprintf("http://app.%s.%s:8080", id, DropletDomain), Short: false},
-		{Title: "Kibana URL", Value: fmt.Sprintf("http://app.%s.%s:5601", id, DropletDomain), Short: false},
-		{Title: "Grafana URL", Value: fmt.Sprintf("http://app.%s.%s:3000", id, DropletDomain), Short: false},
-		{Title: "Prometheus URL", Value: fmt.Sprintf("http://app.%s.%s:9090", id, DropletDomain), Short: false},
 	}
+
+	for _, s := range CurrentWorkshop.Services {
+		fields = append(fields, slack.AttachmentField{Title: s.Name + " URL", Value: s.URL(id), Short: false})
+	}
+
+	return fields
 }
@@ -1,10 +1,6 @@
 package confbot
 
 import (
-	"fmt"
-	"math/rand"
-
-	"github.com/Sirupsen/logrus"
 	"github.com/nlopes/slack"
 	"golang.org/x/net/context"
 )
@@ -15,84 +11,134 @@ const (
 	reactionReady = "100"
 )
 
-var (
-	// DropletRegions are the droplet regions we will boot droplets in.
-	DropletRegions = []string{"nyc1", "nyc2", "nyc3", "tor1", "sfo1", "fra1", "lon1", "ams2", "ams3"}
-)
-
 // CreateBootShellAction returns a function that boot a new shell.
-func CreateBootShellAction(ctx context.Context, masterToken string, doTokens []string, repo Repo) ActionFn {
-	log := logFromContext(ctx)
-
+func CreateBootShellAction(ctx context.Context, masterToken string, tokenPool *TokenPool, regionSelector *RegionSelector, ca *CertificateAuthority, repo Repo) ActionFn {
 	return func(ctx context.Context, m *slack.MessageEvent, slackClient *slack.Client, matches [][]string) error {
-		doToken := doTokens[rand.Intn(len(doTokens))]
-
 		_, _, channelID, err := slackClient.OpenIMChannel(m.User)
 		if err != nil {
 			return err
 		}
 
-		id := projectID()
-		dropletRegion := DropletRegions[rand.Intn(len(DropletRegions))]
-		sb := NewShellBooter(id, doToken, masterToken, dropletRegion, log)
-
+		log := LogFromContext(ctx).WithField("user-id", m.User)
+		t := userMessages(ctx, repo, log, m.User)
 		userID := m.User
-		if err := repo.RegisterProject(id, userID, doToken); err != nil {
-			params := slack.PostMessageParameters{}
-			msg := fmt.Sprintf("Can't boot shell: %v", err)
-			slackClient.PostMessage(channelID, msg, params)
+
+		existing, err := repo.ProjectID(userID)
+		if err != nil {
 			return err
+		}
 
+		if existing != "" {
+			p, err := LoadProject(repo, existing)
+			if err != nil {
+				return err
+			}
+
+			if !p.BootFailed() {
+				params := slack.PostMessageParameters{}
+				msg := t("boot_existing", MessageVars{"ProjectID": existing})
+				slackClient.PostMessage(channelID, msg, params)
+				return &ProjectExistsErr{}
+			}
+
+			log.WithField("project-id", existing).Info("removing project which failed to boot")
+			if err := repo.ResetProject(userID); err != nil {
+				return err
+			}
 		}
 
-		log.WithFields(logrus.Fields{
-			"user-id":    userID,
-			"project-id": id,
-		}).Info("new shell request")
+		doToken, err := tokenPool.Acquire()
+		if err != nil {
+			params := slack.PostMessageParameters{}
+			msg := t("boot_error", MessageVars{"Error": err.Error()})
+			if err == ErrOutOfCapacity {
+				msg = t("boot_out_of_capacity", nil)
+			}
+			slackClient.PostMessage(channelID, msg, params)
+			return err
+		}
 
+		id := projectID()
+		events := EventBusFromContext(ctx)
+		sb := NewShellBooter(ctx, id, doToken, masterToken, tokenPool, regionSelector, ca)
+
+		// once registered, the project is counted against the token until
+		// it is removed.
+		err = repo.RegisterProject(id, userID, doToken)
+		if err == nil {
+			tokenPool.Assign(id, doToken)
+		}
+		tokenPool.Release(doToken)
 		if err != nil {
 			switch err.(type) {
 			case *ProjectExistsErr:
-				params := slack.PostMessageParameters{}
-
 				id, err = repo.ProjectID(userID)
 				if err != nil {
 					return err
 				}
 
-				params = slack.PostMessageParameters{}
-				msg := fmt.Sprintf("You already have an existing shell at *%s*", id)
+				params := slack.PostMessageParameters{}
+				msg := t("boot_existing", MessageVars{"ProjectID": id})
 				slackClient.PostMessage(channelID, msg, params)
 
 			default:
 				params := slack.PostMessageParameters{}
-				msg := fmt.Sprintf("unknown error: %v", err)
+				msg := t("boot_error", MessageVars{"Error": err.Error()})
 				slackClient.PostMessage(channelID, msg, params)
 			}
 			return err
 		}
 
-		txt := fmt.Sprintf(shellResp1, id, DropletDomain)
-		params := slack.PostMessageParameters{}
-		slackClient.PostMessage(channelID, txt, params)
+		log = log.WithField("project-id", id)
+		log.Info("new shell request")
+
+		events.Publish(Event{Type: EventProjectRegistered, ProjectID: id, UserID: userID, Operation: operationBoot})
+
+		var sc *ShellConfig
+		webhookSecret, err := newWebhookSecret()
+		if err == nil {
+			err = repo.SaveWebhookSecret(id, webhookSecret)
+		}
+		if err == nil {
+			sc, err = sb.Boot(webhookSecret)
+		}
+		if err == nil {
+			err = repo.SaveKey(id, sc.KeyPair.private)
+		}
 
-		sc, err := sb.Boot()
 		if err != nil {
 			log.WithError(err).Error("couldn't boot shell")
-			msg := fmt.Sprintf("couldn't boot shell: %s", err)
 
-			params := slack.PostMessageParameters{}
-			slackClient.PostMessage(channelID, msg, params)
-		}
+			leftovers := sb.Rollback()
+			for _, r := range leftovers {
+				log.WithField("resource", r.String()).Warn("recording resource for cleanup")
+				if cerr := repo.AddCleanup(r); cerr != nil {
+					log.WithError(cerr).WithField("resource", r.String()).Error("unable to record resource for cleanup")
+				}
+			}
+
+			// the project is kept so its failure can be seen, and is
+			// removed when the shell is booted again.
+			ev := Event{Type: EventBootFailed, ProjectID: id, UserID: userID, Operation: operationBoot, Error: err.Error()}
+			if len(leftovers) > 0 {
+				// the reconciler deletes what's left.
+				ev.Data = map[string]string{"cleanup": "pending"}
+			}
+			events.Publish(ev)
 
-		if err := repo.SaveKey(id, sc.KeyPair.private); err != nil {
 			return err
 		}
 
+		log.WithField("region", sc.Region).Info("shell booted")
+
+		events.Publish(Event{
+			Type:      EventBootFinished,
+			ProjectID: id,
+			UserID:    userID,
+			Operation: operationBoot,
+			Data:      map[string]string{"region": sc.Region},
+		})
+
 		return nil
 	}
 }
-
-var shellResp1 = `I'm currently booting a server named _shell.%s.%s_. ` +
-	`After it has booted, I will use it to provision the rest of your environment. ` +
-	`This process will take a few minutes, and I'll let you know when it is completed.`
@@ -3,29 +3,26 @@ package confbot
 import (
 	"bytes"
 	"crypto/md5"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/digitalocean/godo"
 	"github.com/digitalocean/godo/util"
 	"github.com/satori/go.uuid"
-	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
 var (
-	dropletSize  = "4gb"
-	dropletImage = godo.DropletCreateImage{
-		Slug: "ubuntu-14-04-x64",
-	}
 	dropletSSHKeys = []godo.DropletCreateSSHKey{}
 
 	// DropletDomain is the domain for droplets.
@@ -34,7 +31,12 @@ var (
 	// WebhookURL is the URL for the bot.
 	WebhookURL = "https://devconfbot.ngrok.io/webhook"
 
-	masterKey = "ssh-rsa AAAAB3NzaC1yc2EAAAABIwAAAQEA3ntoDAFrpg2wDQnqU3+T4wci5qzTThKaJmivUHIVhDoA91fHX89Crtr5GiSt997uG30xm2y1UNWOnbVLrX8UgCEX4/cYTKDtYyD4uHYOez/TyiJANO0mynWBOufMkt3O3Xz/Vp/bpfWqqQLDUUi0DwfpBHa7ZRDFBdu5IQtBGsMzEAbSnf1VCU5YC86NdRiuvSFAu9xq3QS80hBdfY77x5cge6iHNEnTE0yOnY7X+LpEXxJLqlQq81eX3UYjbhrpBX1konAn0UsNtDPDwzAqKYFZNnIPrLqKI+h1ZK4oAc9YziH9kx4DMB3kq8JgqZCg7ViMQQHZzccU1t4bDDn3QQ== bryan@dmac.local"
+	// DigitalOceanURL, if set, replaces the DigitalOcean API's base URL.
+	DigitalOceanURL *url.URL
+
+	// maxRegionAttempts is the amount of regions a shell droplet will
+	// be tried in before giving up.
+	maxRegionAttempts = 3
 )
 
 // ShellConfig is the generated configuration for a shell.
@@ -42,43 +44,94 @@ type ShellConfig struct {
 	KeyPair   *KeyPair
 	ProjectID string
 	Hostname  string
+	Region    string
 }
 
 // ShellBooter boots shells for demos.
 type ShellBooter struct {
-	id            string
-	doToken       string
-	log           *logrus.Entry
-	dropletRegion string
-	client        *godo.Client
-	masterClient  *godo.Client
+	id             string
+	doToken        string
+	log            *logrus.Entry
+	regionSelector *RegionSelector
+	ca             *CertificateAuthority
+	events         *EventBus
+	requestID      string
+	client         *godo.Client
+	masterClient   *godo.Client
+	masterToken    string
+
+	// resources are the resources created while booting. leftovers are
+	// resources that could not be deleted during a rollback.
+	resources []Resource
+	leftovers []Resource
+}
+
+// regionErr is returned when a shell droplet could not be booted in a region.
+type regionErr struct {
+	region string
+	err    error
+}
+
+var _ error = (*regionErr)(nil)
+
+func (e *regionErr) Error() string {
+	return fmt.Sprintf("unable to boot droplet in %s: %v", e.region, e.err)
+}
+
+// isRegionUnavailable returns true if DigitalOcean refused to create a
+// droplet because of its region. Other errors, such as an invalid token or
+// a full account, aren't caused by the region.
+func isRegionUnavailable(err error) bool {
+	er, ok := err.(*godo.ErrorResponse)
+	if !ok || er.Response == nil {
+		return false
+	}
+
+	return er.Response.StatusCode == http.StatusUnprocessableEntity &&
+		strings.Contains(strings.ToLower(er.Message), "region")
 }
 
 func buildDoClient(pat string) *godo.Client {
 	token := &oauth2.Token{AccessToken: pat}
 	ts := oauth2.StaticTokenSource(token)
 	oauthClient := oauth2.NewClient(oauth2.NoContext, ts)
-	return godo.NewClient(oauthClient)
+	oauthClient.Transport = &instrumentedTransport{
+		base:        oauthClient.Transport,
+		fingerprint: tokenFingerprint(pat),
+	}
+	client := godo.NewClient(oauthClient)
+	if DigitalOceanURL != nil {
+		client.BaseURL = DigitalOceanURL
+	}
+	return client
 }
 
-// NewShellBooter creates an instance of ShellBooter,
-func NewShellBooter(id, doToken, masterToken, dropletRegion string, log *logrus.Entry) *ShellBooter {
+// NewShellBooter creates an instance of ShellBooter. The droplet reports its
+// install with ctx's request ID, so the provision which follows can be tied
+// to the boot. Its clients come from tokenPool, so the pool sees the rate
+// limits and revocations of the requests it makes.
+func NewShellBooter(ctx context.Context, id, doToken, masterToken string, tokenPool *TokenPool, regionSelector *RegionSelector, ca *CertificateAuthority) *ShellBooter {
 
 	return &ShellBooter{
-		id:            id,
-		doToken:       doToken,
-		client:        buildDoClient(doToken),
-		masterClient:  buildDoClient(masterToken),
-		log:           log,
-		dropletRegion: dropletRegion,
+		id:             id,
+		doToken:        doToken,
+		client:         tokenPool.Client(doToken),
+		masterClient:   tokenPool.Client(masterToken),
+		masterToken:    masterToken,
+		log:            LogFromContext(ctx),
+		regionSelector: regionSelector,
+		ca:             ca,
+		events:         EventBusFromContext(ctx),
+		requestID:      RequestIDFromContext(ctx),
 	}
 }
 
-// Boot does the boot process.
-func (sb *ShellBooter) Boot() (*ShellConfig, error) {
+// Boot does the boot process. webhookSecret is installed on the shell
+// droplet so it can sign the webhooks it sends.
+func (sb *ShellBooter) Boot(webhookSecret string) (*ShellConfig, error) {
 	id := sb.id
 
-	kp, err := sb.makeSSHKeyPair()
+	kp, err := makeSSHKeyPair()
 	if err != nil {
 		return nil, err
 	}
@@ -87,55 +140,93 @@ func (sb *ShellBooter) Boot() (*ShellConfig, error) {
 		return nil, fmt.Errorf("invalid do token")
 	}
 
-	td := templateData{
-		PubKey:               string(kp.public),
-		MasterKey:            masterKey,
-		EncodedProjectID:     base64.StdEncoding.EncodeToString([]byte(id)),
-		EncodedToken:         base64.StdEncoding.EncodeToString([]byte(sb.doToken)),
-		EncodedInstallScript: base64.StdEncoding.EncodeToString([]byte(runShellInstaller)),
-		EncodedRegion:        base64.StdEncoding.EncodeToString([]byte(sb.dropletRegion)),
-		EncodedWebhookURL:    base64.StdEncoding.EncodeToString([]byte(WebhookURL)),
-		EncodedDomain:        base64.StdEncoding.EncodeToString([]byte(DropletDomain)),
-	}
-
-	t, err := generateTemplate(td)
+	regions, err := sb.regionSelector.Regions()
 	if err != nil {
 		return nil, err
 	}
 
-	err = sb.bootDroplet(t, id)
-	if err != nil {
-		return nil, err
+	if len(regions) > maxRegionAttempts {
+		regions = regions[:maxRegionAttempts]
 	}
 
-	return &ShellConfig{
-		KeyPair:   kp,
-		ProjectID: id,
-		Hostname:  fmt.Sprintf("shell-%s.%s", id, DropletDomain),
-	}, nil
+	var lastErr error
+	for _, region := range regions {
+		t, err := renderUserData(userDataParams{
+			projectID:     id,
+			region:        region,
+			publicKey:     string(kp.public),
+			doToken:       sb.doToken,
+			caPublicKey:   sb.ca.PublicKey(),
+			webhookSecret: webhookSecret,
+			requestID:     sb.requestID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = sb.bootDroplet(t, id, region)
+		if re, ok := err.(*regionErr); ok {
+			sb.log.WithError(re.err).
+				WithFields(logrus.Fields{
+					"project_id": id,
+					"region":     region,
+				}).Warn("unable to boot droplet in region, trying another region")
+			sb.regionSelector.Failed(region)
+			sb.rollback()
+			lastErr = re.err
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ShellConfig{
+			KeyPair:   kp,
+			ProjectID: id,
+			Hostname:  fmt.Sprintf("shell-%s.%s", id, DropletDomain),
+			Region:    region,
+		}, nil
+	}
+
+	if lastErr == nil {
+		return nil, fmt.Errorf("unable to boot shell droplet in regions %s", strings.Join(regions, ", "))
+	}
+
+	return nil, fmt.Errorf("unable to boot shell droplet in regions %s: %v", strings.Join(regions, ", "), lastErr)
 }
 
-func (sb *ShellBooter) bootDroplet(t, id string) error {
+func (sb *ShellBooter) bootDroplet(t, id, region string) error {
 	dropletName := fmt.Sprintf("shell.%s", id)
 	sb.log.WithFields(logrus.Fields{
 		"project_id": id,
-		"region":     sb.dropletRegion,
+		"region":     region,
 	}).Info("creating shell droplet")
 
 	cr := &godo.DropletCreateRequest{
 		Name:     dropletName,
-		Region:   sb.dropletRegion,
-		Size:     dropletSize,
-		Image:    dropletImage,
+		Region:   region,
+		Size:     CurrentWorkshop.Droplet.Size,
+		Image:    godo.DropletCreateImage{Slug: CurrentWorkshop.Droplet.Image},
 		SSHKeys:  dropletSSHKeys,
 		UserData: t,
 	}
 
-	d, resp, err := sb.client.Droplets.Create(cr)
+	var d *godo.Droplet
+	var resp *godo.Response
+	err := sb.events.Step(sb.event(region), "droplet_create", func() (err error) {
+		d, resp, err = sb.client.Droplets.Create(cr)
+		return err
+	})
+	if isRegionUnavailable(err) {
+		return &regionErr{region: region, err: err}
+	}
 	if err != nil {
 		return err
 	}
 
+	sb.track(Resource{Type: ResourceDroplet, ID: d.ID, Token: sb.doToken})
+
 	var action *godo.LinkAction
 	for _, a := range resp.Links.Actions {
 		if a.Rel == "create" {
@@ -153,10 +244,16 @@ func (sb *ShellBooter) bootDroplet(t, id string) error {
 		"action_id":  action.ID,
 	}).Info("waiting for droplet to boot")
 
-	err = util.WaitForActive(sb.client, action.HREF)
-	if err != nil {
+	err = sb.events.Step(sb.event(region), "droplet_active", func() error {
+		return util.WaitForActive(sb.client, action.HREF)
+	})
+	if _, ok := err.(*godo.ErrorResponse); ok {
 		return err
 	}
+	if err != nil {
+		// the droplet never became active.
+		return &regionErr{region: region, err: err}
+	}
 
 	sb.log.WithFields(logrus.Fields{
 		"project_id": id,
@@ -172,41 +269,81 @@ func (sb *ShellBooter) bootDroplet(t, id string) error {
 		return err
 	}
 
+	ev := sb.event(region)
+	ev.Type = EventDropletCreated
+	ev.Data["droplet_id"] = strconv.Itoa(d.ID)
+	ev.Data["ip"] = ip
+	sb.events.Publish(ev)
+
 	drer := &godo.DomainRecordEditRequest{
 		Type: "A",
 		Name: dropletName,
 		Data: ip,
 	}
-	_, _, err = sb.masterClient.Domains.CreateRecord(DropletDomain, drer)
+	var rec *godo.DomainRecord
+	err = sb.events.Step(sb.event(region), "dns_record", func() (err error) {
+		rec, _, err = sb.masterClient.Domains.CreateRecord(DropletDomain, drer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-	return err
-}
+	sb.track(Resource{Type: ResourceDomainRecord, ID: rec.ID})
+
+	ev = sb.event(region)
+	ev.Type = EventDNSRecordCreated
+	ev.Data["record_id"] = strconv.Itoa(rec.ID)
+	ev.Data["name"] = fmt.Sprintf("%s.%s", dropletName, DropletDomain)
+	ev.Data["ip"] = ip
+	sb.events.Publish(ev)
 
-// KeyPair is a SSH key pair.
-type KeyPair struct {
-	public  []byte
-	private []byte
+	return nil
 }
 
-func (sb *ShellBooter) makeSSHKeyPair() (*KeyPair, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
-	if err != nil {
-		return nil, err
+// event returns the base for the events published while booting in region.
+func (sb *ShellBooter) event(region string) Event {
+	return Event{
+		ProjectID: sb.id,
+		Operation: operationBoot,
+		Data:      map[string]string{"region": region},
 	}
+}
 
-	// generate and write public key
-	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
-	if err != nil {
-		return nil, err
-	}
+func (sb *ShellBooter) track(r Resource) {
+	r.ProjectID = sb.id
+	r.CreatedAt = time.Now()
+	sb.resources = append(sb.resources, r)
+}
+
+// Rollback deletes the resources created by Boot. It returns the resources
+// that could not be deleted so they can be recorded for cleanup.
+func (sb *ShellBooter) Rollback() []Resource {
+	sb.rollback()
 
-	kp := &KeyPair{}
+	leftovers := sb.leftovers
+	sb.leftovers = nil
+	return leftovers
+}
+
+func (sb *ShellBooter) rollback() {
+	for i := len(sb.resources) - 1; i >= 0; i-- {
+		r := sb.resources[i]
+		log := sb.log.WithFields(logrus.Fields{
+			"project_id": sb.id,
+			"resource":   r.String(),
+		})
+
+		if err := deleteResource(r, sb.masterToken); err != nil {
+			log.WithError(err).Error("unable to roll back resource")
+			sb.leftovers = append(sb.leftovers, r)
+			continue
+		}
 
-	privateKeyPEM := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
-	kp.private = pem.EncodeToMemory(privateKeyPEM)
-	kp.public = ssh.MarshalAuthorizedKey(pub)
+		log.Info("rolled back resource")
+	}
 
-	return kp, nil
+	sb.resources = nil
 }
 
 func projectID() string {
@@ -216,15 +353,91 @@ func projectID() string {
 	return str[:7]
 }
 
+// userDataParams are the settings a shell droplet's user data is rendered
+// from.
+type userDataParams struct {
+	projectID     string
+	region        string
+	publicKey     string
+	doToken       string
+	caPublicKey   string
+	webhookSecret string
+	requestID     string
+}
+
+func renderUserData(p userDataParams) (string, error) {
+	return generateTemplate(templateData{
+		PubKey:               strings.TrimSpace(p.publicKey),
+		MasterKeys:           CurrentWorkshop.MasterKeys,
+		EncodedProjectID:     base64.StdEncoding.EncodeToString([]byte(p.projectID)),
+		EncodedToken:         base64.StdEncoding.EncodeToString([]byte(p.doToken)),
+		EncodedInstallScript: base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(runShellInstaller, CurrentWorkshop.InstallScriptURL))),
+		EncodedRegion:        base64.StdEncoding.EncodeToString([]byte(p.region)),
+		EncodedWebhookURL:    base64.StdEncoding.EncodeToString([]byte(WebhookURL)),
+		EncodedDomain:        base64.StdEncoding.EncodeToString([]byte(DropletDomain)),
+		EncodedCAPublicKey:   base64.StdEncoding.EncodeToString([]byte(p.caPublicKey + "\n")),
+		EncodedPrincipals:    base64.StdEncoding.EncodeToString([]byte(projectPrincipal(p.projectID) + "\n" + InstructorPrincipal + "\n")),
+		EncodedSSHCAScript:   base64.StdEncoding.EncodeToString([]byte(configureSSHCA)),
+		EncodedWebhookSecret: base64.StdEncoding.EncodeToString([]byte(p.webhookSecret)),
+		EncodedWebhookScript: base64.StdEncoding.EncodeToString([]byte(webhookScript)),
+		EncodedRequestID:     base64.StdEncoding.EncodeToString([]byte(p.requestID)),
+	})
+}
+
+// ProjectUserData renders the user data for a project's shell droplet from
+// its saved key, token and webhook secret. The user data contains secrets.
+// The request ID of the boot isn't saved, so it is left empty.
+func ProjectUserData(repo Repo, ca *CertificateAuthority, projectID string) (string, error) {
+	p, err := LoadProject(repo, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	privKey, err := repo.GetKey(projectID)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, err := authorizedKeyFromPrivate(privKey)
+	if err != nil {
+		return "", err
+	}
+
+	doToken, err := repo.Token(projectID)
+	if err != nil {
+		return "", err
+	}
+
+	webhookSecret, err := repo.WebhookSecret(projectID)
+	if err != nil {
+		return "", err
+	}
+
+	return renderUserData(userDataParams{
+		projectID:     projectID,
+		region:        p.Region,
+		publicKey:     pubKey,
+		doToken:       doToken,
+		caPublicKey:   ca.PublicKey(),
+		webhookSecret: webhookSecret,
+	})
+}
+
 type templateData struct {
 	PubKey               string
-	MasterKey            string
+	MasterKeys           []string
 	EncodedProjectID     string
 	EncodedToken         string
 	EncodedInstallScript string
 	EncodedRegion        string
 	EncodedWebhookURL    string
 	EncodedDomain        string
+	EncodedCAPublicKey   string
+	EncodedPrincipals    string
+	EncodedSSHCAScript   string
+	EncodedWebhookSecret string
+	EncodedWebhookScript string
+	EncodedRequestID     string
 }
 
 func generateTemplate(td templateData) (string, error) {
@@ -251,7 +464,9 @@ users:
     sudo: ['ALL=(ALL) NOPASSWD:ALL']
     ssh-authorized-keys:
       - {{ .PubKey }}
-      - {{ .MasterKey }}
+{{- range .MasterKeys }}
+      - {{ . }}
+{{- end }}
 write_files:
   - encoding: b64
     content: {{ .EncodedProjectID }}
@@ -283,6 +498,36 @@ write_files:
     owner: root:root
     path: /usr/local/bin/install-shell.sh
     permissions: '0755'
+  - encoding: b64
+    content: {{ .EncodedCAPublicKey }}
+    owner: root:root
+    path: /etc/ssh/confbot_ca.pub
+    permissions: '0644'
+  - encoding: b64
+    content: {{ .EncodedPrincipals }}
+    owner: root:root
+    path: /etc/ssh/auth_principals/workshop
+    permissions: '0644'
+  - encoding: b64
+    content: {{ .EncodedSSHCAScript }}
+    owner: root:root
+    path: /usr/local/bin/configure-ssh-ca.sh
+    permissions: '0755'
+  - encoding: b64
+    content: {{ .EncodedWebhookSecret }}
+    owner: root:root
+    path: /etc/confbot-webhook-secret
+    permissions: '0600'
+  - encoding: b64
+    content: {{ .EncodedWebhookScript }}
+    owner: root:root
+    path: /usr/local/bin/confbot-webhook
+    permissions: '0755'
+  - encoding: b64
+    content: {{ .EncodedRequestID }}
+    owner: root:root
+    path: /etc/confbot-request-id
+    permissions: '0644'
 package_update: true
 apt_sources:
   - source: "ppa:gluster/glusterfs-3.5"
@@ -292,11 +537,64 @@ packages:
   - glusterfs-server
   - ansible
 runcmd:
+  - [/usr/local/bin/configure-ssh-ca.sh]
   - [/usr/local/bin/install-shell.sh]
 `
 
+// configureSSHCA makes sshd trust certificates issued by the confbot CA
+// for the principals listed in /etc/ssh/auth_principals.
+var configureSSHCA = `
+#!/usr/bin/env bash
+
+touch /etc/ssh/revoked_keys
+cat >> /etc/ssh/sshd_config <<EOF
+
+TrustedUserCAKeys /etc/ssh/confbot_ca.pub
+AuthorizedPrincipalsFile /etc/ssh/auth_principals/%u
+RevokedKeys /etc/ssh/revoked_keys
+EOF
+service ssh restart
+`
+
+// runShellInstaller runs the workshop's install script, which is
+// substituted for %s.
 var runShellInstaller = `
 #!/usr/bin/env bash
 
-curl -s https://s3.pifft.com/oscon2016/install.sh | bash
+curl -s %s | bash
+CONFBOT_REQUEST_ID=$(cat /etc/confbot-request-id) /usr/local/bin/confbot-webhook install_complete
+`
+
+// webhookScript sends a signed webhook to the bot. If CONFBOT_PAYLOAD names a
+// JSON file, it is sent as the webhook's payload. If CONFBOT_REQUEST_ID is
+// set, it is sent as the webhook's request ID.
+// Usage: confbot-webhook <type> [name=value ...]
+var webhookScript = `#!/usr/bin/env bash
+
+set -e
+
+type=$1
+shift
+
+options=""
+for kv in "$@"; do
+  options="${options}${options:+,}\"${kv%%=*}\":\"${kv#*=}\""
+done
+
+payload=""
+if [ -n "${CONFBOT_PAYLOAD}" ]; then
+  payload=",\"payload\":$(cat "${CONFBOT_PAYLOAD}")"
+fi
+
+body="{\"type\":\"${type}\",\"project_id\":\"$(cat /etc/project-id)\",\"options\":{${options}}${payload}}"
+ts=$(date +%s)
+sig=$(printf '%s.%s' "${ts}" "${body}" | openssl dgst -sha256 -hmac "$(cat /etc/confbot-webhook-secret)" | sed 's/^.* //')
+
+curl -sf --retry 5 -X POST \
+  -H "Content-Type: application/json" \
+  -H "X-Request-Id: ${CONFBOT_REQUEST_ID}" \
+  -H "X-Confbot-Timestamp: ${ts}" \
+  -H "X-Confbot-Signature: sha256=${sig}" \
+  -d "${body}" \
+  "$(cat /etc/confbot-webhook-url)"
 `
@@ -0,0 +1,552 @@
+package simulate
+
+import (
+	"bytes"
+	"confbot"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+)
+
+const (
+	dropletNew     = "new"
+	dropletActive  = "active"
+	dropletErrored = "errored"
+
+	webhookAttempts = 5
+)
+
+// droplet is a fake droplet. It becomes active once the boot delay has
+// passed, unless it fails.
+type droplet struct {
+	id        int
+	name      string
+	region    string
+	size      string
+	image     string
+	token     string
+	ip        string
+	userData  string
+	actionID  int
+	fails     bool
+	createdAt time.Time
+}
+
+// Cloud is a fake DigitalOcean API. Each token is an account with its own
+// droplets. Domain records are shared.
+type Cloud struct {
+	cfg     *Config
+	log     *logrus.Entry
+	dice    *dice
+	baseURL string
+
+	mu       sync.Mutex
+	nextID   int
+	droplets map[int]*droplet
+	actions  map[int]*droplet
+	records  map[int]godo.DomainRecord
+}
+
+var _ http.Handler = (*Cloud)(nil)
+
+func newCloud(cfg *Config, log *logrus.Entry, d *dice, baseURL string) *Cloud {
+	return &Cloud{
+		cfg:      cfg,
+		log:      log.WithField("service", "digitalocean"),
+		dice:     d,
+		baseURL:  baseURL,
+		nextID:   1000,
+		droplets: map[int]*droplet{},
+		actions:  map[int]*droplet{},
+		records:  map[int]godo.DomainRecord{},
+	}
+}
+
+// status returns a droplet's status, which depends on how long ago it was
+// created.
+func (d *droplet) status(bootDelay time.Duration) string {
+	switch {
+	case time.Since(d.createdAt) < bootDelay:
+		return dropletNew
+	case d.fails:
+		return dropletErrored
+	default:
+		return dropletActive
+	}
+}
+
+// HasHost returns true if hostname resolves to an active droplet. hostname
+// may have a port.
+func (c *Cloud) HasHost(hostname string) bool {
+	if i := strings.LastIndex(hostname, ":"); i >= 0 {
+		hostname = hostname[:i]
+	}
+	name := strings.TrimSuffix(hostname, "."+confbot.DropletDomain)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rec := range c.records {
+		if rec.Type != "A" || rec.Name != name {
+			continue
+		}
+		for _, d := range c.droplets {
+			if d.ip == rec.Data && d.status(c.cfg.bootDelay) == dropletActive {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP answers the DigitalOcean API requests the bot makes.
+func (c *Cloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(c.cfg.requestDelay)
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	log := c.log.WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path})
+
+	if token == "" {
+		writeDOError(w, http.StatusUnauthorized, "unauthorized", "Unable to authenticate you.")
+		return
+	}
+
+	if c.dice.fails(c.cfg.Cloud.RequestFailureRate) {
+		log.Info("failing request")
+		writeDOError(w, http.StatusInternalServerError, "server_error", "Simulated server error.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v2" {
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+	parts = parts[1:]
+
+	switch {
+	case r.Method == "GET" && match(parts, "account"):
+		c.account(w)
+	case r.Method == "GET" && match(parts, "regions"):
+		c.regions(w)
+	case r.Method == "GET" && match(parts, "droplets"):
+		c.listDroplets(w, token)
+	case r.Method == "POST" && match(parts, "droplets"):
+		c.createDroplet(w, r, token)
+	case r.Method == "GET" && match(parts, "droplets", "*"):
+		c.getDroplet(w, token, parts[1])
+	case r.Method == "DELETE" && match(parts, "droplets", "*"):
+		c.deleteDroplet(w, token, parts[1])
+	case r.Method == "GET" && match(parts, "actions", "*"):
+		c.getAction(w, parts[1])
+	case r.Method == "GET" && match(parts, "domains", "*", "records"):
+		c.listRecords(w, parts[1])
+	case r.Method == "POST" && match(parts, "domains", "*", "records"):
+		c.createRecord(w, r, parts[1])
+	case r.Method == "DELETE" && match(parts, "domains", "*", "records", "*"):
+		c.deleteRecord(w, parts[1], parts[3])
+	case r.Method == "GET" && match(parts, "account", "keys"):
+		// keys are never created, droplets are given keys in their user
+		// data.
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ssh_keys": []godo.Key{}, "links": godo.Links{}})
+	case r.Method == "DELETE" && match(parts, "account", "keys", "*"):
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+	default:
+		log.Warn("unsupported digitalocean api request")
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+	}
+}
+
+func (c *Cloud) account(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"account": godo.Account{
+			DropletLimit:  c.cfg.Cloud.DropletLimit,
+			Email:         "instructor@example.com",
+			EmailVerified: true,
+			Status:        "active",
+		},
+	})
+}
+
+func (c *Cloud) regions(w http.ResponseWriter) {
+	regions := []godo.Region{}
+	for _, slug := range c.cfg.Cloud.Regions {
+		regions = append(regions, godo.Region{
+			Slug:      slug,
+			Name:      slug,
+			Sizes:     []string{confbot.CurrentWorkshop.Droplet.Size},
+			Available: true,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"regions": regions, "links": godo.Links{}})
+}
+
+func (c *Cloud) listDroplets(w http.ResponseWriter, token string) {
+	c.mu.Lock()
+	var ids []int
+	for id, d := range c.droplets {
+		if d.token == token {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	droplets := []godo.Droplet{}
+	for _, id := range ids {
+		droplets = append(droplets, c.godoDroplet(c.droplets[id]))
+	}
+	c.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"droplets": droplets, "links": godo.Links{}})
+}
+
+func (c *Cloud) createDroplet(w http.ResponseWriter, r *http.Request, token string) {
+	// the image is a slug or an ID, so godo.DropletCreateRequest can't be
+	// decoded.
+	var cr struct {
+		Name     string      `json:"name"`
+		Region   string      `json:"region"`
+		Size     string      `json:"size"`
+		Image    interface{} `json:"image"`
+		UserData string      `json:"user_data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		writeDOError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	if !contains(c.cfg.Cloud.Regions, cr.Region) {
+		writeDOError(w, http.StatusUnprocessableEntity, "unprocessable_entity", "Region is not available.")
+		return
+	}
+
+	c.mu.Lock()
+	count := 0
+	for _, d := range c.droplets {
+		if d.token == token {
+			count++
+		}
+	}
+	if count >= c.cfg.Cloud.DropletLimit {
+		c.mu.Unlock()
+		writeDOError(w, http.StatusUnprocessableEntity, "unprocessable_entity",
+			"You have reached the droplet limit for your account.")
+		return
+	}
+
+	c.nextID++
+	d := &droplet{
+		id:        c.nextID,
+		name:      cr.Name,
+		region:    cr.Region,
+		size:      cr.Size,
+		image:     fmt.Sprint(cr.Image),
+		token:     token,
+		ip:        fmt.Sprintf("10.%d.%d.%d", (c.nextID>>16)&0xff, (c.nextID>>8)&0xff, c.nextID&0xff),
+		userData:  cr.UserData,
+		fails:     contains(c.cfg.Cloud.FailingRegions, cr.Region) || c.dice.fails(c.cfg.Cloud.BootFailureRate),
+		createdAt: time.Now(),
+	}
+	c.nextID++
+	d.actionID = c.nextID
+	c.droplets[d.id] = d
+	c.actions[d.actionID] = d
+	gd := c.godoDroplet(d)
+	c.mu.Unlock()
+
+	log := c.log.WithFields(logrus.Fields{"droplet-id": d.id, "name": d.name, "region": d.region})
+	log.WithField("fails", d.fails).Info("creating droplet")
+
+	if !d.fails && strings.HasPrefix(d.name, "shell.") {
+		if c.dice.fails(c.cfg.Cloud.InstallFailureRate) {
+			log.Info("shell droplet won't report its install")
+		} else {
+			time.AfterFunc(c.cfg.bootDelay+c.cfg.installDelay, func() { c.reportInstall(d) })
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"droplet": gd,
+		"links": godo.Links{Actions: []godo.LinkAction{{
+			ID:   d.actionID,
+			Rel:  "create",
+			HREF: fmt.Sprintf("%sv2/actions/%d", c.baseURL, d.actionID),
+		}}},
+	})
+}
+
+func (c *Cloud) getDroplet(w http.ResponseWriter, token, id string) {
+	c.mu.Lock()
+	d, ok := c.droplet(token, id)
+	var gd godo.Droplet
+	if ok {
+		gd = c.godoDroplet(d)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"droplet": gd})
+}
+
+func (c *Cloud) deleteDroplet(w http.ResponseWriter, token, id string) {
+	c.mu.Lock()
+	d, ok := c.droplet(token, id)
+	if ok {
+		delete(c.droplets, d.id)
+		delete(c.actions, d.actionID)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+
+	c.log.WithFields(logrus.Fields{"droplet-id": d.id, "name": d.name}).Info("deleted droplet")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Cloud) getAction(w http.ResponseWriter, id string) {
+	actionID, _ := strconv.Atoi(id)
+
+	c.mu.Lock()
+	d, ok := c.actions[actionID]
+	var status string
+	if ok {
+		status = d.status(c.cfg.bootDelay)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+
+	a := godo.Action{
+		ID:           actionID,
+		Type:         "create",
+		ResourceID:   d.id,
+		ResourceType: "droplet",
+		StartedAt:    &godo.Timestamp{Time: d.createdAt},
+	}
+	switch status {
+	case dropletNew:
+		a.Status = godo.ActionInProgress
+	case dropletActive:
+		a.Status = godo.ActionCompleted
+		a.CompletedAt = &godo.Timestamp{Time: d.createdAt.Add(c.cfg.bootDelay)}
+	default:
+		a.Status = "errored"
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"action": a})
+}
+
+func (c *Cloud) listRecords(w http.ResponseWriter, domain string) {
+	c.mu.Lock()
+	var ids []int
+	for id := range c.records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	records := []godo.DomainRecord{}
+	for _, id := range ids {
+		records = append(records, c.records[id])
+	}
+	c.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"domain_records": records, "links": godo.Links{}})
+}
+
+func (c *Cloud) createRecord(w http.ResponseWriter, r *http.Request, domain string) {
+	var req godo.DomainRecordEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDOError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	rec := godo.DomainRecord{ID: c.nextID, Type: req.Type, Name: req.Name, Data: req.Data}
+	c.records[rec.ID] = rec
+	c.mu.Unlock()
+
+	c.log.WithFields(logrus.Fields{"record-id": rec.ID, "name": rec.Name, "data": rec.Data}).Info("created domain record")
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"domain_record": rec})
+}
+
+func (c *Cloud) deleteRecord(w http.ResponseWriter, domain, id string) {
+	recordID, _ := strconv.Atoi(id)
+
+	c.mu.Lock()
+	_, ok := c.records[recordID]
+	delete(c.records, recordID)
+	c.mu.Unlock()
+
+	if !ok {
+		writeDOError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// droplet returns the token's droplet with id. c.mu must be held.
+func (c *Cloud) droplet(token, id string) (*droplet, bool) {
+	dropletID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, false
+	}
+
+	d, ok := c.droplets[dropletID]
+	if !ok || d.token != token {
+		return nil, false
+	}
+
+	return d, true
+}
+
+// godoDroplet describes a droplet like the API does. c.mu must be held.
+func (c *Cloud) godoDroplet(d *droplet) godo.Droplet {
+	gd := godo.Droplet{
+		ID:       d.id,
+		Name:     d.name,
+		Region:   &godo.Region{Slug: d.region, Name: d.region},
+		SizeSlug: d.size,
+		Image:    &godo.Image{Slug: d.image},
+		Status:   d.status(c.cfg.bootDelay),
+		Created:  d.createdAt.UTC().Format(time.RFC3339),
+		Networks: &godo.Networks{},
+	}
+
+	if gd.Status == dropletActive {
+		gd.Networks.V4 = []godo.NetworkV4{{IPAddress: d.ip, Type: "public"}}
+	}
+
+	return gd
+}
+
+// reportInstall sends the install_complete webhook, the way a shell droplet
+// does once its install script finishes.
+func (c *Cloud) reportInstall(d *droplet) {
+	c.mu.Lock()
+	_, exists := c.droplets[d.id]
+	c.mu.Unlock()
+
+	log := c.log.WithFields(logrus.Fields{"droplet-id": d.id, "name": d.name})
+	if !exists {
+		log.Info("droplet was deleted before its install finished")
+		return
+	}
+
+	files := userDataFiles(d.userData)
+	projectID := files["/etc/project-id"]
+	secret := files["/etc/confbot-webhook-secret"]
+	webhookURL := files["/etc/confbot-webhook-url"]
+	if projectID == "" || secret == "" || webhookURL == "" {
+		log.Warn("droplet user data doesn't have the webhook settings")
+		return
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":       "install_complete",
+		"project_id": projectID,
+		"options":    map[string]string{},
+	})
+
+	log = log.WithField("project-id", projectID)
+	for i := 1; i <= webhookAttempts; i++ {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.WithError(err).Error("unable to create install webhook")
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-Id", files["/etc/confbot-request-id"])
+		req.Header.Set(confbot.WebhookTimestampHeader, ts)
+		req.Header.Set(confbot.WebhookSignatureHeader, confbot.SignWebhook(secret, ts, body))
+
+		res, err := http.DefaultClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode < http.StatusBadRequest {
+				log.Info("reported install")
+				return
+			}
+			err = fmt.Errorf("webhook returned %s", res.Status)
+		}
+
+		log.WithError(err).WithField("attempt", i).Warn("unable to report install")
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+}
+
+// userDataFiles returns the contents of the write_files entries in
+// cloud-config user data, by path.
+func userDataFiles(userData string) map[string]string {
+	files := map[string]string{}
+
+	var content string
+	for _, line := range strings.Split(userData, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "- "))
+		switch {
+		case strings.HasPrefix(line, "content: "):
+			b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "content: "))
+			if err != nil {
+				content = ""
+				continue
+			}
+			content = string(b)
+		case strings.HasPrefix(line, "path: "):
+			files[strings.TrimPrefix(line, "path: ")] = strings.TrimSpace(content)
+			content = ""
+		}
+	}
+
+	return files
+}
+
+// match returns true if parts matches pattern. "*" matches any part.
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p != "*" && p != parts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeDOError(w http.ResponseWriter, status int, id, message string) {
+	writeJSON(w, status, map[string]string{"id": id, "message": message})
+}
+
+func contains(vs []string, s string) bool {
+	for _, v := range vs {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// Package simulate runs confbot against fake Slack and DigitalOcean APIs and
+// fake droplets, so a workshop can be rehearsed offline.
+package simulate
+
+import (
+	"confbot"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config describes how the simulated services behave. Durations are strings
+// such as "30s". Rates are between 0 and 1.
+type Config struct {
+	// Users are the Slack user IDs of the attendees. The first one is the
+	// console's user.
+	Users []string `toml:"users"`
+	// Seed seeds the failures. 0 uses the current time.
+	Seed  int64       `toml:"seed"`
+	Cloud CloudConfig `toml:"cloud"`
+	Hosts HostsConfig `toml:"hosts"`
+
+	requestDelay time.Duration
+	bootDelay    time.Duration
+	installDelay time.Duration
+	commandDelay time.Duration
+	probeDelay   time.Duration
+}
+
+// CloudConfig describes the fake DigitalOcean.
+type CloudConfig struct {
+	// Accounts is the amount of DigitalOcean tokens given to the bot.
+	Accounts     int      `toml:"accounts"`
+	DropletLimit int      `toml:"droplet_limit"`
+	Regions      []string `toml:"regions"`
+	// FailingRegions are listed as available, but droplets created in
+	// them never become active.
+	FailingRegions []string `toml:"failing_regions"`
+	// RequestDelay is added to every API request.
+	RequestDelay string `toml:"request_delay"`
+	// BootDelay is how long a droplet takes to become active.
+	BootDelay string `toml:"boot_delay"`
+	// InstallDelay is how long a shell droplet takes to report its install
+	// once it is active.
+	InstallDelay string `toml:"install_delay"`
+	// RequestFailureRate is the rate of API requests which fail with a
+	// server error.
+	RequestFailureRate float64 `toml:"request_failure_rate"`
+	// BootFailureRate is the rate of droplets which never become active.
+	BootFailureRate float64 `toml:"boot_failure_rate"`
+	// InstallFailureRate is the rate of shell droplets which never report
+	// their install.
+	InstallFailureRate float64 `toml:"install_failure_rate"`
+}
+
+// HostsConfig describes the fake droplets' SSH and services.
+type HostsConfig struct {
+	// CommandDelay is how long a command takes to run.
+	CommandDelay string `toml:"command_delay"`
+	// ProbeDelay is how long a service takes to answer.
+	ProbeDelay string `toml:"probe_delay"`
+	// CommandFailureRate is the rate of commands which fail.
+	CommandFailureRate float64 `toml:"command_failure_rate"`
+	// ProbeFailureRate is the rate of service checks which fail.
+	ProbeFailureRate float64 `toml:"probe_failure_rate"`
+}
+
+// ConfigErr is returned when a simulate config is invalid.
+type ConfigErr struct {
+	Path string
+	Err  error
+}
+
+var _ error = (*ConfigErr)(nil)
+
+func (e *ConfigErr) Error() string {
+	return fmt.Sprintf("invalid simulate config %s: %v", e.Path, e.Err)
+}
+
+// DefaultConfig returns a config where everything works, quickly enough to
+// walk through a workshop.
+func DefaultConfig() *Config {
+	c := &Config{
+		Users: []string{"attendee", "instructor"},
+		Cloud: CloudConfig{
+			Accounts:     2,
+			DropletLimit: 10,
+			Regions:      []string{"nyc1", "nyc3", "sfo1", "ams2", "lon1"},
+			RequestDelay: "100ms",
+			BootDelay:    "20s",
+			InstallDelay: "10s",
+		},
+		Hosts: HostsConfig{
+			CommandDelay: "2s",
+			ProbeDelay:   "1s",
+		},
+	}
+
+	if err := c.Validate(); err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// LoadConfig reads a config from a TOML file. Settings which aren't in the
+// file keep their default value.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := DefaultConfig()
+	if _, err := confbot.DecodeConfig(b, c); err != nil {
+		return nil, &ConfigErr{Path: path, Err: err}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, &ConfigErr{Path: path, Err: err}
+	}
+
+	return c, nil
+}
+
+// Validate checks the config's settings.
+func (c *Config) Validate() error {
+	if len(c.Users) == 0 {
+		return fieldErr("users", "must have at least one user")
+	}
+	for _, u := range c.Users {
+		if u == "" {
+			return fieldErr("users", "can't be empty")
+		}
+	}
+
+	if c.Cloud.Accounts < 1 {
+		return fieldErr("cloud.accounts", "must be at least 1")
+	}
+	if c.Cloud.DropletLimit < 1 {
+		return fieldErr("cloud.droplet_limit", "must be at least 1")
+	}
+	if len(c.Cloud.Regions) == 0 {
+		return fieldErr("cloud.regions", "must have at least one region")
+	}
+
+	durations := []struct {
+		field string
+		value string
+		d     *time.Duration
+	}{
+		{"cloud.request_delay", c.Cloud.RequestDelay, &c.requestDelay},
+		{"cloud.boot_delay", c.Cloud.BootDelay, &c.bootDelay},
+		{"cloud.install_delay", c.Cloud.InstallDelay, &c.installDelay},
+		{"hosts.command_delay", c.Hosts.CommandDelay, &c.commandDelay},
+		{"hosts.probe_delay", c.Hosts.ProbeDelay, &c.probeDelay},
+	}
+	for _, d := range durations {
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v < 0 {
+			return fieldErr(d.field, "must be a duration such as 30s")
+		}
+		*d.d = v
+	}
+
+	rates := []struct {
+		field string
+		value float64
+	}{
+		{"cloud.request_failure_rate", c.Cloud.RequestFailureRate},
+		{"cloud.boot_failure_rate", c.Cloud.BootFailureRate},
+		{"cloud.install_failure_rate", c.Cloud.InstallFailureRate},
+		{"hosts.command_failure_rate", c.Hosts.CommandFailureRate},
+		{"hosts.probe_failure_rate", c.Hosts.ProbeFailureRate},
+	}
+	for _, r := range rates {
+		if r.value < 0 || r.value > 1 {
+			return fieldErr(r.field, "must be between 0 and 1")
+		}
+	}
+
+	return nil
+}
+
+// Tokens returns the DigitalOcean tokens of the simulated accounts.
+func (c *Config) Tokens() []string {
+	var tokens []string
+	for i := 1; i <= c.Cloud.Accounts; i++ {
+		tokens = append(tokens, fmt.Sprintf("simulated-token-%d", i))
+	}
+	return tokens
+}
+
+func fieldErr(field, reason string) error {
+	return &confbot.FieldErr{Field: field, Reason: reason}
+}
@@ -0,0 +1,132 @@
+package simulate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const consoleHelp = `Type a message to send it to the bot as the current user.
+  /user <id>      switch to another user
+  /users          list users
+  @<id> <text>    send one message as another user
+  /help           show this help
+`
+
+// Console lets an instructor chat with the bot from a terminal. It prints
+// the bot's messages to every user, and returns when in is closed.
+func (s *Simulator) Console(in io.Reader, out io.Writer) {
+	var mu sync.Mutex
+	printf := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(out, format, args...)
+	}
+
+	s.Slack.Listen(func(m Message) {
+		if m.FromBot {
+			printf("%s", formatMessage(m))
+		}
+	})
+
+	user := s.cfg.Users[0]
+	printf("simulating confbot, chatting as %s\n%s", user, consoleHelp)
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+
+		switch {
+		case line == "":
+		case line == "/help":
+			printf("%s", consoleHelp)
+		case line == "/users":
+			printf("users: %s\n", strings.Join(s.Slack.Users(), ", "))
+		case fields[0] == "/user":
+			if len(fields) != 2 {
+				printf("usage: /user <id>\n")
+				continue
+			}
+			user = fields[1]
+			printf("chatting as %s\n", user)
+		case strings.HasPrefix(line, "@"):
+			if len(fields) < 2 {
+				printf("usage: @<id> <text>\n")
+				continue
+			}
+			s.send(printf, strings.TrimPrefix(fields[0], "@"), strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+		default:
+			s.send(printf, user, line)
+		}
+	}
+}
+
+func (s *Simulator) send(printf func(string, ...interface{}), user, text string) {
+	if _, err := s.Slack.Send(user, text); err != nil {
+		printf("unable to send message: %v\n", err)
+	}
+}
+
+// formatMessage renders a bot message for the console.
+func formatMessage(m Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "confbot -> %s:", m.User)
+	if m.Text != "" {
+		fmt.Fprintf(&b, " %s", strings.Replace(m.Text, "\n", "\n  ", -1))
+	}
+	b.WriteString("\n")
+
+	for _, a := range m.Attachments {
+		for _, s := range []string{a.Pretext, a.Title, a.Text} {
+			if s != "" {
+				fmt.Fprintf(&b, "  | %s\n", strings.Replace(s, "\n", "\n  | ", -1))
+			}
+		}
+		for _, f := range a.Fields {
+			fmt.Fprintf(&b, "  | %s: %s\n", f.Title, f.Value)
+		}
+	}
+
+	if m.File != nil {
+		fmt.Fprintf(&b, "  [file %s, %d bytes, GET /simulate/messages?user=%s to read it]\n", m.File.Name, len(m.File.Content), m.User)
+	}
+
+	return b.String()
+}
+
+// ChatHandler serves the chat over HTTP, for scripts and other attendees.
+//
+//	GET  /simulate/messages?user=<id>&since=<message id>
+//	POST /simulate/messages with the form values user and text
+//	GET  /simulate/users
+func (s *Simulator) ChatHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/simulate/messages", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+			writeJSON(w, http.StatusOK, s.Slack.Messages(r.URL.Query().Get("user"), since))
+		case "POST":
+			m, err := s.Slack.Send(r.FormValue("user"), r.FormValue("text"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusAccepted, m)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/simulate/users", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Slack.Users())
+	})
+
+	return mux
+}
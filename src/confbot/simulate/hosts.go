@@ -0,0 +1,79 @@
+package simulate
+
+import (
+	"confbot"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Hosts runs commands on the fake cloud's droplets. Commands succeed,
+// after a delay, unless they are chosen to fail or the host doesn't exist.
+type Hosts struct {
+	cfg   *Config
+	log   *logrus.Entry
+	dice  *dice
+	cloud *Cloud
+}
+
+var _ confbot.HostExecutor = (*Hosts)(nil)
+
+func newHosts(cfg *Config, log *logrus.Entry, d *dice, cloud *Cloud) *Hosts {
+	return &Hosts{
+		cfg:   cfg,
+		log:   log.WithField("service", "hosts"),
+		dice:  d,
+		cloud: cloud,
+	}
+}
+
+// Execute pretends to run cmd on hostname.
+func (h *Hosts) Execute(hostname string, key []byte, cmd string) (string, error) {
+	if _, err := ssh.ParsePrivateKey(key); err != nil {
+		return "", fmt.Errorf("parse key failed: %v", err)
+	}
+
+	if !h.cloud.HasHost(hostname) {
+		host, _, _ := net.SplitHostPort(hostname)
+		return "", fmt.Errorf("dial tcp: lookup %s: no such host", host)
+	}
+
+	time.Sleep(h.cfg.commandDelay)
+
+	log := h.log.WithFields(logrus.Fields{"hostname": hostname, "cmd": cmd})
+	if h.dice.fails(h.cfg.Hosts.CommandFailureRate) {
+		log.Info("failing command")
+		return "", fmt.Errorf("Process exited with status 1\nsimulated failure running %s", firstLine(cmd))
+	}
+
+	log.Info("ran command")
+	return fmt.Sprintf("$ %s\nsimulated on %s\n", cmd, hostname), nil
+}
+
+// Probe pretends to connect to address. The services on the project's
+// other droplets aren't simulated, so only the failure rate applies.
+func (h *Hosts) Probe(address string, timeout time.Duration) error {
+	delay := h.cfg.probeDelay
+	if delay > timeout {
+		delay = timeout
+	}
+	time.Sleep(delay)
+
+	if h.dice.fails(h.cfg.Hosts.ProbeFailureRate) {
+		h.log.WithField("address", address).Info("failing probe")
+		return fmt.Errorf("dial tcp %s: i/o timeout", address)
+	}
+
+	return nil
+}
+
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
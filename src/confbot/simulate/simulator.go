@@ -0,0 +1,93 @@
+package simulate
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Simulator serves the fake Slack and DigitalOcean APIs on a local port.
+type Simulator struct {
+	Slack *Slack
+	Cloud *Cloud
+	Hosts *Hosts
+
+	cfg      *Config
+	log      *logrus.Entry
+	listener net.Listener
+	url      string
+}
+
+// New creates a Simulator listening on an ephemeral local port. Call Serve
+// to start answering requests.
+func New(cfg *Config, log *logrus.Entry) (*Simulator, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	dice := &dice{r: rand.New(rand.NewSource(seed))}
+
+	log = log.WithField("simulate", true)
+	baseURL := fmt.Sprintf("http://%s", l.Addr().String())
+
+	cloud := newCloud(cfg, log, dice, baseURL+"/digitalocean/")
+
+	return &Simulator{
+		Slack:    newSlack(cfg, log),
+		Cloud:    cloud,
+		Hosts:    newHosts(cfg, log, dice, cloud),
+		cfg:      cfg,
+		log:      log,
+		listener: l,
+		url:      baseURL,
+	}, nil
+}
+
+// Serve answers API requests until the listener is closed.
+func (s *Simulator) Serve() error {
+	mux := http.NewServeMux()
+	mux.Handle("/slack/api/", http.StripPrefix("/slack/api/", s.Slack))
+	mux.Handle("/digitalocean/", http.StripPrefix("/digitalocean/", s.Cloud))
+
+	s.log.WithField("url", s.url).Info("serving simulated apis")
+	return http.Serve(s.listener, mux)
+}
+
+// SlackAPI is the URL of the fake Slack API.
+func (s *Simulator) SlackAPI() string {
+	return s.url + "/slack/api/"
+}
+
+// DigitalOceanURL is the URL of the fake DigitalOcean API.
+func (s *Simulator) DigitalOceanURL() *url.URL {
+	u, _ := url.Parse(s.url + "/digitalocean/")
+	return u
+}
+
+// dice decides which operations fail. It is safe for concurrent use.
+type dice struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// fails returns true with probability rate.
+func (d *dice) fails(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.r.Float64() < rate
+}
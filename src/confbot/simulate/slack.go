@@ -0,0 +1,271 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// BotUserID is the bot's user ID in the fake Slack.
+	BotUserID = "UCONFBOT"
+
+	imChannelPrefix = "D"
+)
+
+// Message is a message sent in the fake Slack, by a user or by the bot.
+type Message struct {
+	ID      int    `json:"id"`
+	Channel string `json:"channel"`
+	// User is the user who sent the message, or who the bot sent it to.
+	User        string             `json:"user"`
+	FromBot     bool               `json:"from_bot"`
+	Text        string             `json:"text,omitempty"`
+	Attachments []slack.Attachment `json:"attachments,omitempty"`
+	File        *File              `json:"file,omitempty"`
+	Time        time.Time          `json:"time"`
+}
+
+// File is a file uploaded by the bot.
+type File struct {
+	Name    string `json:"name"`
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content"`
+}
+
+// Slack is a fake Slack API. Every user has a direct message channel with
+// the bot, and messages sent by users are passed to the bot's handler.
+type Slack struct {
+	log *logrus.Entry
+
+	mu        sync.Mutex
+	users     []string
+	messages  []Message
+	listeners []func(Message)
+	handler   func(*slack.MessageEvent)
+}
+
+var _ http.Handler = (*Slack)(nil)
+
+func newSlack(cfg *Config, log *logrus.Entry) *Slack {
+	return &Slack{
+		log:   log.WithField("service", "slack"),
+		users: append([]string{}, cfg.Users...),
+	}
+}
+
+// HandleMessages sets the func which receives messages sent by users.
+func (s *Slack) HandleMessages(fn func(*slack.MessageEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = fn
+}
+
+// Listen calls fn with every message, in the order they are sent.
+func (s *Slack) Listen(fn func(Message)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Send sends text to the bot as userID.
+func (s *Slack) Send(userID, text string) (Message, error) {
+	if userID == "" || strings.TrimSpace(text) == "" {
+		return Message{}, fmt.Errorf("a message needs a user and text")
+	}
+
+	m := s.record(Message{Channel: imChannelPrefix + userID, User: userID, Text: text})
+
+	s.mu.Lock()
+	handler := s.handler
+	s.mu.Unlock()
+
+	if handler == nil {
+		return m, fmt.Errorf("the bot isn't listening")
+	}
+
+	ev := &slack.MessageEvent{}
+	ev.Type = "message"
+	ev.Channel = m.Channel
+	ev.User = userID
+	ev.Text = text
+	ev.Timestamp = slackTimestamp(m)
+	handler(ev)
+
+	return m, nil
+}
+
+// Messages returns the messages sent to or by userID after the message with
+// ID since. All users' messages are returned if userID is empty.
+func (s *Slack) Messages(userID string, since int) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []Message{}
+	for _, m := range s.messages {
+		if m.ID > since && (userID == "" || m.User == userID) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// Users returns the users who have been seen.
+func (s *Slack) Users() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.users...)
+}
+
+func (s *Slack) record(m Message) Message {
+	s.mu.Lock()
+	m.ID = len(s.messages) + 1
+	m.Time = time.Now()
+	s.messages = append(s.messages, m)
+	s.addUser(m.User)
+	listeners := append([]func(Message){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(m)
+	}
+
+	return m
+}
+
+// addUser adds a user to the user list. s.mu must be held.
+func (s *Slack) addUser(userID string) {
+	if userID == "" {
+		return
+	}
+	for _, u := range s.users {
+		if u == userID {
+			return
+		}
+	}
+	s.users = append(s.users, userID)
+}
+
+// ServeHTTP answers the Slack web API methods the bot uses.
+func (s *Slack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, slackError("invalid_form_data"))
+		return
+	}
+
+	method := r.URL.Path
+	s.log.WithField("method", method).Debug("slack api request")
+
+	switch method {
+	case "auth.test":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":      true,
+			"url":     "https://simulated.slack.com/",
+			"team":    "simulated",
+			"team_id": "TSIMULATED",
+			"user":    "confbot",
+			"user_id": BotUserID,
+		})
+
+	case "im.open":
+		userID := r.Form.Get("user")
+		if userID == "" {
+			writeJSON(w, http.StatusOK, slackError("user_not_found"))
+			return
+		}
+		s.mu.Lock()
+		s.addUser(userID)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":      true,
+			"channel": map[string]string{"id": imChannelPrefix + userID},
+		})
+
+	case "chat.postMessage":
+		channel := r.Form.Get("channel")
+		m := Message{
+			Channel: channel,
+			User:    strings.TrimPrefix(channel, imChannelPrefix),
+			FromBot: true,
+			Text:    r.Form.Get("text"),
+		}
+		if a := r.Form.Get("attachments"); a != "" {
+			if err := json.Unmarshal([]byte(a), &m.Attachments); err != nil {
+				writeJSON(w, http.StatusOK, slackError("invalid_attachments"))
+				return
+			}
+		}
+		m = s.record(m)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":      true,
+			"channel": channel,
+			"ts":      slackTimestamp(m),
+		})
+
+	case "files.upload":
+		channel := r.Form.Get("channels")
+		f := &File{
+			Name:    r.Form.Get("filename"),
+			Title:   r.Form.Get("title"),
+			Content: r.Form.Get("content"),
+		}
+		m := s.record(Message{
+			Channel: channel,
+			User:    strings.TrimPrefix(channel, imChannelPrefix),
+			FromBot: true,
+			Text:    r.Form.Get("initial_comment"),
+			File:    f,
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":   true,
+			"file": map[string]string{"id": fmt.Sprintf("F%d", m.ID), "name": f.Name, "title": f.Title},
+		})
+
+	case "users.info":
+		userID := r.Form.Get("user")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":   true,
+			"user": slackUser(userID),
+		})
+
+	case "users.list":
+		members := []map[string]string{}
+		for _, u := range s.Users() {
+			members = append(members, slackUser(u))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":      true,
+			"members": members,
+		})
+
+	default:
+		s.log.WithField("method", method).Warn("unsupported slack api method")
+		writeJSON(w, http.StatusOK, slackError("unknown_method"))
+	}
+}
+
+// slackUser describes a user. Users are named after their ID.
+func slackUser(userID string) map[string]string {
+	return map[string]string{"id": userID, "name": userID}
+}
+
+func slackError(reason string) map[string]interface{} {
+	return map[string]interface{}{"ok": false, "error": reason}
+}
+
+func slackTimestamp(m Message) string {
+	return strconv.FormatInt(m.Time.Unix(), 10) + "." + fmt.Sprintf("%06d", m.ID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,393 @@
+package confbot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+)
+
+const (
+	// InstructorPrincipal is the principal instructor certificates are issued for.
+	// Every project host accepts it.
+	InstructorPrincipal = "instructor"
+
+	// certificateClockSkew is how far in the past certificates become valid
+	// to allow for hosts with clocks that are behind.
+	certificateClockSkew = 5 * time.Minute
+)
+
+var (
+	// CertificateTTL is how long attendee certificates are valid.
+	CertificateTTL = 12 * time.Hour
+
+	// InstructorCertificateTTL is how long instructor certificates are valid.
+	InstructorCertificateTTL = 8 * time.Hour
+
+	// ErrCertificateNotFound is returned when a certificate can't be found.
+	ErrCertificateNotFound = errors.New("certificate not found")
+)
+
+// IssuedCertificate is a record of a certificate issued by the CA.
+type IssuedCertificate struct {
+	Serial      uint64    `json:"serial"`
+	KeyID       string    `json:"key_id"`
+	UserID      string    `json:"user_id"`
+	ProjectID   string    `json:"project_id,omitempty"`
+	Principals  []string  `json:"principals"`
+	PublicKey   string    `json:"public_key"`
+	ValidBefore time.Time `json:"valid_before"`
+	Revoked     bool      `json:"revoked"`
+}
+
+// Expired returns true if the certificate is no longer valid.
+func (ic IssuedCertificate) Expired(now time.Time) bool {
+	return now.After(ic.ValidBefore)
+}
+
+// projectPrincipal is the principal attendee certificates are issued for.
+func projectPrincipal(projectID string) string {
+	return "project-" + projectID
+}
+
+// CertificateAuthority issues short lived SSH user certificates. Shell droplets
+// trust its public key through cloud-init, and the other project hosts are
+// configured to trust it when the project is provisioned.
+type CertificateAuthority struct {
+	signer ssh.Signer
+	repo   Repo
+	log    *logrus.Entry
+}
+
+// NewCertificateAuthority creates an instance of CertificateAuthority. The CA key is
+// loaded from the repo, and is generated if it doesn't exist yet.
+func NewCertificateAuthority(ctx context.Context, repo Repo) (*CertificateAuthority, error) {
+	log := LogFromContext(ctx).WithField("component", "ssh-ca")
+
+	key, err := repo.CAKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		log.Info("generating CA key")
+
+		privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := repo.SaveCAKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+
+		// another instance could have saved a key first.
+		if key, err = repo.CAKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key failed: %v", err)
+	}
+
+	return &CertificateAuthority{
+		signer: signer,
+		repo:   repo,
+		log:    log,
+	}, nil
+}
+
+// PublicKey returns the CA public key in authorized keys format.
+func (ca *CertificateAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// IssueUserCertificate creates a key pair and a certificate for it which can
+// only be used with the hosts of projectID.
+func (ca *CertificateAuthority) IssueUserCertificate(userID, projectID string) (*KeyPair, []byte, error) {
+	keyID := fmt.Sprintf("%s@%s", userID, projectID)
+	return ca.issue(userID, projectID, keyID, []string{projectPrincipal(projectID)}, CertificateTTL)
+}
+
+// IssueInstructorCertificate creates a key pair and a certificate for it which can
+// be used with the hosts of every project.
+func (ca *CertificateAuthority) IssueInstructorCertificate(userID string) (*KeyPair, []byte, error) {
+	keyID := fmt.Sprintf("%s@%s", userID, InstructorPrincipal)
+	return ca.issue(userID, "", keyID, []string{InstructorPrincipal}, InstructorCertificateTTL)
+}
+
+func (ca *CertificateAuthority) issue(userID, projectID, keyID string, principals []string, ttl time.Duration) (*KeyPair, []byte, error) {
+	kp, err := makeSSHKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(kp.public)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := ca.repo.NextCertificateSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	validBefore := now.Add(ttl)
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-certificateClockSkew).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+				"permit-X11-forwarding":   "",
+			},
+		},
+	}
+
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, nil, err
+	}
+
+	ic := IssuedCertificate{
+		Serial:      serial,
+		KeyID:       keyID,
+		UserID:      userID,
+		ProjectID:   projectID,
+		Principals:  principals,
+		PublicKey:   strings.TrimSpace(string(kp.public)),
+		ValidBefore: validBefore,
+	}
+
+	if err := ca.repo.SaveCertificate(ic); err != nil {
+		return nil, nil, err
+	}
+
+	ca.log.WithFields(logrus.Fields{
+		"serial":       serial,
+		"key-id":       keyID,
+		"principals":   principals,
+		"valid-before": validBefore,
+	}).Info("issued certificate")
+
+	return kp, ssh.MarshalAuthorizedKey(cert), nil
+}
+
+// Certificates returns the unexpired certificates issued to userID.
+func (ca *CertificateAuthority) Certificates(userID string) ([]IssuedCertificate, error) {
+	certs, err := ca.repo.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var out []IssuedCertificate
+	for _, c := range certs {
+		if c.Expired(now) {
+			continue
+		}
+
+		if userID == "" || c.UserID == userID {
+			out = append(out, c)
+		}
+	}
+
+	return out, nil
+}
+
+// RemoveExpiredCertificates removes the records of certificates which
+// expired before now, as they can no longer be used. It returns the amount
+// of certificates that were removed.
+func RemoveExpiredCertificates(repo Repo, now time.Time) (int, error) {
+	certs, err := repo.Certificates()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, c := range certs {
+		if !c.Expired(now) {
+			continue
+		}
+
+		if err := repo.RemoveCertificate(c.Serial); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Revoke marks a certificate as revoked. Hosts have to be told about the
+// revoked key, see revokeOnHosts.
+func (ca *CertificateAuthority) Revoke(serial uint64) (*IssuedCertificate, error) {
+	certs, err := ca.Certificates("")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range certs {
+		if c.Serial != serial {
+			continue
+		}
+
+		c.Revoked = true
+		if err := ca.repo.SaveCertificate(c); err != nil {
+			return nil, err
+		}
+
+		ca.log.WithField("serial", serial).Info("revoked certificate")
+		return &c, nil
+	}
+
+	return nil, ErrCertificateNotFound
+}
+
+// revokeKeyScript adds the key substituted for %s to the revoked keys of a
+// host, if sshd on the host checks them. Hosts which don't trust the CA
+// print notConfiguredOutput instead.
+var revokeKeyScript = `
+if ! grep -qs '^RevokedKeys /etc/ssh/revoked_keys' /etc/ssh/sshd_config; then
+  echo not-configured
+  exit 0
+fi
+echo '%s' | sudo tee -a /etc/ssh/revoked_keys > /dev/null
+`
+
+const notConfiguredOutput = "not-configured"
+
+// revokeOnHosts adds the key of a revoked certificate to the revoked keys of
+// the hosts it can access. Hosts which don't trust the CA are skipped, as
+// the certificate can't be used on them.
+func revokeOnHosts(ctx context.Context, repo Repo, masterToken string, ic *IssuedCertificate) error {
+	log := LogFromContext(ctx).WithField("serial", ic.Serial)
+
+	projectIDs := []string{ic.ProjectID}
+	if ic.ProjectID == "" {
+		ids, err := repo.ProjectIDs()
+		if err != nil {
+			return err
+		}
+		projectIDs = ids
+	}
+
+	masterClient := buildDoClient(masterToken)
+	cmd := fmt.Sprintf(revokeKeyScript, strings.TrimSpace(ic.PublicKey))
+
+	var failed []string
+	for _, projectID := range projectIDs {
+		hosts, err := projectHosts(masterClient, projectID)
+		if err != nil {
+			return err
+		}
+
+		sshClient := NewSSHClient(ctx, projectID, repo)
+		for _, host := range hosts {
+			hostLog := log.WithFields(logrus.Fields{"project-id": projectID, "host": host})
+
+			out, err := sshClient.Execute(host, cmd)
+			if err != nil {
+				hostLog.WithError(err).Error("unable to revoke key on host")
+				failed = append(failed, fmt.Sprintf("%s.%s", host, projectID))
+				continue
+			}
+
+			if strings.TrimSpace(out) == notConfiguredOutput {
+				hostLog.Info("host doesn't trust the ca, skipping revocation")
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to revoke key on %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// configureHostSSHCAScript makes a host trust the CA like cloud-init does on
+// the shell droplet. The CA public key, the principals and configureSSHCA
+// are substituted base64 encoded. Hosts which already trust a CA are left
+// alone.
+var configureHostSSHCAScript = `
+if grep -qs '^TrustedUserCAKeys' /etc/ssh/sshd_config; then
+  exit 0
+fi
+sudo mkdir -p /etc/ssh/auth_principals
+echo '%s' | base64 -d | sudo tee /etc/ssh/confbot_ca.pub > /dev/null
+echo '%s' | base64 -d | sudo tee /etc/ssh/auth_principals/workshop > /dev/null
+echo '%s' | base64 -d | sudo bash
+`
+
+// configureHostsSSHCA makes the hosts of a project other than its shell
+// droplet trust the CA. The CA public key is copied from the shell droplet,
+// which got it through cloud-init.
+func configureHostsSSHCA(ctx context.Context, repo Repo, masterToken, projectID string) error {
+	log := LogFromContext(ctx).WithField("project-id", projectID)
+
+	hosts, err := projectHosts(buildDoClient(masterToken), projectID)
+	if err != nil {
+		return err
+	}
+
+	sshClient := NewSSHClient(ctx, projectID, repo)
+
+	caPublicKey, err := sshClient.Execute("shell", "cat /etc/ssh/confbot_ca.pub")
+	if err != nil {
+		return fmt.Errorf("unable to read the ca public key from the shell droplet: %v", err)
+	}
+
+	cmd := fmt.Sprintf(configureHostSSHCAScript,
+		base64.StdEncoding.EncodeToString([]byte(strings.TrimSpace(caPublicKey)+"\n")),
+		base64.StdEncoding.EncodeToString([]byte(projectPrincipal(projectID)+"\n"+InstructorPrincipal+"\n")),
+		base64.StdEncoding.EncodeToString([]byte(configureSSHCA)))
+
+	var failed []string
+	for _, host := range hosts {
+		if host == "shell" {
+			continue
+		}
+
+		if _, err := sshClient.Execute(host, cmd); err != nil {
+			log.WithError(err).WithField("host", host).Error("unable to configure ssh ca on host")
+			failed = append(failed, host)
+			continue
+		}
+
+		log.WithField("host", host).Info("configured ssh ca on host")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to configure the ssh ca on %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
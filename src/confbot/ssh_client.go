@@ -3,6 +3,8 @@ package confbot
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
@@ -14,32 +16,27 @@ const (
 	defaultSSHPort = 22
 )
 
-// SSHClient is a SSH client.
-type SSHClient struct {
-	projectID string
-	repo      Repo
-	log       *logrus.Entry
+// HostExecutor runs commands on project hosts and checks their services.
+type HostExecutor interface {
+	// Execute runs cmd on hostname as the workshop user, authenticating
+	// with a PEM encoded private key. It returns the command's output.
+	Execute(hostname string, key []byte, cmd string) (string, error)
+	// Probe returns an error unless address accepts TCP connections within
+	// timeout.
+	Probe(address string, timeout time.Duration) error
 }
 
-// NewSSHClient builds an instance of SSHClient.
-func NewSSHClient(ctx context.Context, projectID string, repo Repo) *SSHClient {
-	return &SSHClient{
-		projectID: projectID,
-		repo:      repo,
-		log:       logFromContext(ctx).WithField("project-id", projectID),
-	}
-}
+// CurrentHostExecutor is the HostExecutor used to reach droplets. It is
+// replaced when simulating.
+var CurrentHostExecutor HostExecutor = &sshExecutor{}
 
-// Execute executes a command on a remote ssh host.
-func (s *SSHClient) Execute(host, cmd string) (string, error) {
-	hostname := fmt.Sprintf("%s.%s.%s:%d", host, s.projectID, DropletDomain, defaultSSHPort)
+// sshExecutor reaches hosts over the network.
+type sshExecutor struct{}
 
-	pemBytes, err := s.repo.GetKey(s.projectID)
-	if err != nil {
-		return "", err
-	}
+var _ HostExecutor = (*sshExecutor)(nil)
 
-	signer, err := ssh.ParsePrivateKey(pemBytes)
+func (e *sshExecutor) Execute(hostname string, key []byte, cmd string) (string, error) {
+	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
 		return "", fmt.Errorf("parse key failed: %v", err)
 	}
@@ -49,13 +46,11 @@ func (s *SSHClient) Execute(host, cmd string) (string, error) {
 		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
 	}
 
-	s.log.WithField("hostname", hostname).Info("dialing")
 	conn, err := ssh.Dial("tcp", hostname, config)
 	if err != nil {
 		return "", err
 	}
 
-	s.log.WithField("hostname", hostname).Info("creating session")
 	session, err := conn.NewSession()
 	if err != nil {
 		return "", err
@@ -66,15 +61,79 @@ func (s *SSHClient) Execute(host, cmd string) (string, error) {
 	var buf bytes.Buffer
 	session.Stdout = &buf
 
+	if err := session.Run(cmd); err != nil {
+		return "", fmt.Errorf("%s\n%s", err, buf.String())
+	}
+
+	return buf.String(), nil
+}
+
+func (e *sshExecutor) Probe(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// SSHClient is a SSH client.
+type SSHClient struct {
+	projectID string
+	repo      Repo
+	key       []byte
+	log       *logrus.Entry
+}
+
+// NewSSHClient builds an instance of SSHClient.
+func NewSSHClient(ctx context.Context, projectID string, repo Repo) *SSHClient {
+	return &SSHClient{
+		projectID: projectID,
+		repo:      repo,
+		log:       LogFromContext(ctx).WithField("project-id", projectID),
+	}
+}
+
+// NewSSHClientWithKey builds an instance of SSHClient which authenticates
+// with key instead of the project key stored in the repo.
+func NewSSHClientWithKey(ctx context.Context, projectID string, key []byte) *SSHClient {
+	return &SSHClient{
+		projectID: projectID,
+		key:       key,
+		log:       LogFromContext(ctx).WithField("project-id", projectID),
+	}
+}
+
+// Execute executes a command on a remote ssh host.
+func (s *SSHClient) Execute(host, cmd string) (string, error) {
+	start := time.Now()
+	out, err := s.execute(host, cmd)
+	sshCommandDuration.Observe(time.Since(start).Seconds(), host, outcome(err))
+
+	return out, err
+}
+
+func (s *SSHClient) execute(host, cmd string) (string, error) {
+	hostname := fmt.Sprintf("%s.%s.%s:%d", host, s.projectID, DropletDomain, defaultSSHPort)
+
+	pemBytes := s.key
+	if pemBytes == nil {
+		var err error
+		pemBytes, err = s.repo.GetKey(s.projectID)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	s.log.WithFields(logrus.Fields{
 		"hostname": hostname,
 		"cmd":      cmd}).
 		Info("running command")
-	err = session.Run(cmd)
+	out, err := CurrentHostExecutor.Execute(hostname, pemBytes, cmd)
 	if err != nil {
-		s.log.WithField("output", buf.String()).WithError(err).Error("ssh client run returned non zero result")
-		return "", fmt.Errorf("%s\n%s", err, buf.String())
+		s.log.WithError(err).Error("ssh client run returned non zero result")
+		return "", err
 	}
 
-	return buf.String(), nil
+	return out, nil
 }
@@ -0,0 +1,103 @@
+package confbot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// KeyTypeRSA generates RSA keys.
+	KeyTypeRSA = "rsa"
+	// KeyTypeECDSA generates ECDSA keys using the P-256 curve.
+	KeyTypeECDSA = "ecdsa"
+
+	// minRSAKeyBits is the smallest RSA key size current OpenSSH releases accept.
+	minRSAKeyBits = 2048
+)
+
+var (
+	// SSHKeyType is the type of SSH keys generated for projects. Ed25519 keys
+	// are not supported by the vendored ssh package, so ECDSA is the modern option.
+	SSHKeyType = KeyTypeRSA
+
+	// SSHKeyBits is the size of generated RSA keys.
+	SSHKeyBits = 3072
+)
+
+// KeyPair is a SSH key pair.
+type KeyPair struct {
+	public  []byte
+	private []byte
+}
+
+// ValidateSSHKeySettings checks the configured key type and size.
+func ValidateSSHKeySettings() error {
+	switch SSHKeyType {
+	case KeyTypeRSA:
+		if SSHKeyBits < minRSAKeyBits {
+			return fmt.Errorf("RSA keys must be at least %d bits, got %d", minRSAKeyBits, SSHKeyBits)
+		}
+	case KeyTypeECDSA:
+	default:
+		return fmt.Errorf("unknown ssh key type %q", SSHKeyType)
+	}
+
+	return nil
+}
+
+func makeSSHKeyPair() (*KeyPair, error) {
+	var publicKey interface{}
+	var privateKeyPEM *pem.Block
+
+	switch SSHKeyType {
+	case KeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey = &key.PublicKey
+		privateKeyPEM = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		key, err := rsa.GenerateKey(rand.Reader, SSHKeyBits)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey = &key.PublicKey
+		privateKeyPEM = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	}
+
+	pub, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kp := &KeyPair{}
+	kp.private = pem.EncodeToMemory(privateKeyPEM)
+	kp.public = ssh.MarshalAuthorizedKey(pub)
+
+	return kp, nil
+}
+
+// authorizedKeyFromPrivate returns the authorized key line for a PEM encoded private key.
+func authorizedKeyFromPrivate(pemBytes []byte) (string, error) {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return "", fmt.Errorf("parse key failed: %v", err)
+	}
+
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), nil
+}
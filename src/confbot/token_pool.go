@@ -0,0 +1,535 @@
+package confbot
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/digitalocean/godo"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tokenRefreshInterval is how long account information is trusted before
+	// it is fetched again.
+	tokenRefreshInterval = time.Minute
+
+	// revokedRetryInterval is how long a revoked token is left alone before
+	// it is checked again, in case it was revoked by mistake and restored.
+	revokedRetryInterval = 10 * time.Minute
+
+	// projectRecountInterval is how long the projects counted against the
+	// tokens are trusted before they are counted again from the repo. Projects
+	// booted and deleted by this process are counted as they happen; the
+	// recount picks up changes made elsewhere, e.g. with confbotctl.
+	projectRecountInterval = 10 * time.Minute
+
+	// minRateRemaining is the amount of API requests an account needs to have
+	// left before new projects are assigned to it. Booting and provisioning a
+	// project makes a fair amount of calls.
+	minRateRemaining = 100
+
+	headerRateLimit     = "RateLimit-Limit"
+	headerRateRemaining = "RateLimit-Remaining"
+	headerRateReset     = "RateLimit-Reset"
+)
+
+var (
+	// ErrOutOfCapacity is returned when none of the DigitalOcean accounts
+	// have room for another project.
+	ErrOutOfCapacity = errors.New("all DigitalOcean accounts are out of capacity")
+
+	// DropletsPerProject is the amount of droplets a single project creates. This
+	// includes the shell droplet and the hosts the infra setup creates.
+	DropletsPerProject = 3
+)
+
+type tokenState struct {
+	token        string
+	client       *godo.Client
+	dropletLimit int
+	droplets     int
+	pending      int
+	projects     int
+	rate         godo.Rate
+	revoked      bool
+	revokedAt    time.Time
+	status       string
+	refreshedAt  time.Time
+}
+
+// room is the amount of droplets that can still be created with this token.
+// Projects using the token keep their droplets reserved, including the ones
+// they haven't created yet.
+func (ts *tokenState) room() int {
+	used := ts.droplets
+	if reserved := ts.projects * DropletsPerProject; reserved > used {
+		used = reserved
+	}
+
+	return ts.dropletLimit - used - ts.pending*DropletsPerProject
+}
+
+func (ts *tokenState) rateLimited(now time.Time) bool {
+	if ts.rate.Limit == 0 {
+		return false
+	}
+
+	if !ts.rate.Reset.IsZero() && now.After(ts.rate.Reset.Time) {
+		return false
+	}
+
+	return ts.rate.Remaining < minRateRemaining
+}
+
+func (ts *tokenState) available(now time.Time) bool {
+	return !ts.revoked &&
+		ts.status == "active" &&
+		!ts.rateLimited(now) &&
+		ts.room() >= DropletsPerProject
+}
+
+// TokenCapacity is a snapshot of the capacity of a DigitalOcean token.
+type TokenCapacity struct {
+	Fingerprint   string
+	DropletLimit  int
+	Droplets      int
+	Pending       int
+	Projects      int
+	RateLimit     int
+	RateRemaining int
+	Revoked       bool
+	Status        string
+	RefreshedAt   time.Time
+}
+
+// TokenPool hands out DigitalOcean tokens based on the capacity
+// of the account they belong to.
+type TokenPool struct {
+	mu     sync.Mutex
+	tokens []*tokenState
+	repo   Repo
+	log    *logrus.Entry
+
+	// projects maps project IDs to the token they use.
+	projects  map[string]string
+	countedAt time.Time
+}
+
+// NewTokenPool creates an instance of TokenPool. The projects in repo are
+// counted against the tokens they use.
+func NewTokenPool(ctx context.Context, tokens []string, repo Repo) *TokenPool {
+	tp := &TokenPool{
+		repo:     repo,
+		log:      LogFromContext(ctx).WithField("component", "token-pool"),
+		projects: map[string]string{},
+	}
+
+	for _, token := range tokens {
+		ts := &tokenState{token: token}
+		ts.client = buildDoClient(token)
+		ts.client.OnRequestCompleted(tp.observer(ts))
+		tp.tokens = append(tp.tokens, ts)
+	}
+
+	return tp
+}
+
+// Refresh fetches account information for all tokens, and counts the
+// projects using them.
+func (tp *TokenPool) Refresh() {
+	tp.refresh(func(*tokenState) bool { return true })
+	tp.countProjects()
+}
+
+func (tp *TokenPool) refreshStale() {
+	now := time.Now()
+	tp.refresh(func(ts *tokenState) bool {
+		return now.Sub(ts.refreshedAt) > tokenRefreshInterval
+	})
+
+	tp.mu.Lock()
+	countedAt := tp.countedAt
+	tp.mu.Unlock()
+
+	if now.Sub(countedAt) > projectRecountInterval {
+		tp.countProjects()
+	}
+}
+
+func (tp *TokenPool) refresh(shouldRefresh func(*tokenState) bool) {
+	now := time.Now()
+
+	tp.mu.Lock()
+	var stale []*tokenState
+	for _, ts := range tp.tokens {
+		if ts.revoked && now.Sub(ts.revokedAt) < revokedRetryInterval {
+			continue
+		}
+		if shouldRefresh(ts) {
+			stale = append(stale, ts)
+		}
+	}
+	tp.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ts := range stale {
+		wg.Add(1)
+		go func(ts *tokenState) {
+			defer wg.Done()
+			tp.refreshToken(ts)
+		}(ts)
+	}
+	wg.Wait()
+}
+
+// countProjects counts the projects using each token from the repo.
+func (tp *TokenPool) countProjects() {
+	ids, err := tp.repo.ProjectIDs()
+	if err != nil {
+		tp.log.WithError(err).Error("unable to list projects")
+		return
+	}
+
+	projects := map[string]string{}
+	for _, id := range ids {
+		// projects which failed to boot have had their droplets removed.
+		if p, err := tp.repo.Project(id); err == nil && p != nil && p.BootFailed() {
+			continue
+		}
+
+		token, err := tp.repo.Token(id)
+		if err != nil {
+			tp.log.WithError(err).WithField("project-id", id).Error("unable to fetch project token")
+			continue
+		}
+		projects[id] = token
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	tp.projects = projects
+	tp.countedAt = time.Now()
+	tp.updateProjectCounts()
+}
+
+// updateProjectCounts sets the amount of projects using each token. tp.mu
+// has to be held.
+func (tp *TokenPool) updateProjectCounts() {
+	counts := map[string]int{}
+	for _, token := range tp.projects {
+		counts[token]++
+	}
+
+	for _, ts := range tp.tokens {
+		ts.projects = counts[ts.token]
+	}
+}
+
+// Assign counts a registered project against the token it uses.
+func (tp *TokenPool) Assign(projectID, token string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	tp.projects[projectID] = token
+	tp.updateProjectCounts()
+}
+
+// Unassign stops counting a project against its token, once its droplets
+// have been removed.
+func (tp *TokenPool) Unassign(projectID string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	delete(tp.projects, projectID)
+	tp.updateProjectCounts()
+}
+
+// Client returns the client for a token. Clients for tokens in the pool
+// report rate limits and revocations back to the pool; other tokens, such
+// as the master token or tokens which have been removed, get a new client.
+// A nil pool always creates a new client.
+func (tp *TokenPool) Client(token string) *godo.Client {
+	if tp != nil {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+
+		for _, ts := range tp.tokens {
+			if ts.token == token {
+				return ts.client
+			}
+		}
+	}
+
+	return buildDoClient(token)
+}
+
+func (tp *TokenPool) refreshToken(ts *tokenState) {
+	log := tp.log.WithField("token-fingerprint", tokenFingerprint(ts.token))
+
+	account, _, err := ts.client.Account.Get()
+	if err != nil {
+		if isUnauthorized(err) {
+			log.Warn("token has been revoked")
+			tp.mu.Lock()
+			ts.revoked = true
+			ts.revokedAt = time.Now()
+			tp.mu.Unlock()
+			return
+		}
+
+		tp.mu.Lock()
+		status := ts.status
+		tp.mu.Unlock()
+
+		if status == "" {
+			// without account information the token is never handed out.
+			log.WithError(err).Error("unable to fetch account, the token won't be used until it can be fetched")
+			return
+		}
+
+		log.WithError(err).Error("unable to fetch account")
+		return
+	}
+
+	droplets, err := listDroplets(ts.client)
+	if err != nil {
+		log.WithError(err).Error("unable to list droplets")
+		return
+	}
+
+	tp.mu.Lock()
+	wasRevoked := ts.revoked
+	ts.revoked = false
+	ts.dropletLimit = account.DropletLimit
+	ts.status = account.Status
+	ts.droplets = len(droplets)
+	ts.refreshedAt = time.Now()
+	rateRemaining := ts.rate.Remaining
+	tp.mu.Unlock()
+
+	if wasRevoked {
+		log.Info("revoked token can be used again")
+	}
+
+	log.WithFields(logrus.Fields{
+		"droplet-limit":  account.DropletLimit,
+		"droplets":       len(droplets),
+		"status":         account.Status,
+		"rate-remaining": rateRemaining,
+	}).Info("refreshed token capacity")
+}
+
+// Acquire returns the token with the most room for a new project. The
+// returned token has to be given back with Release once the project has
+// been registered, and the project counted against it with Assign until it
+// is removed. If there are no tokens with room, ErrOutOfCapacity is
+// returned.
+func (tp *TokenPool) Acquire() (string, error) {
+	tp.refreshStale()
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	now := time.Now()
+
+	var best *tokenState
+	for _, ts := range tp.tokens {
+		if !ts.available(now) {
+			continue
+		}
+
+		if best == nil ||
+			ts.room() > best.room() ||
+			(ts.room() == best.room() && ts.rate.Remaining > best.rate.Remaining) {
+			best = ts
+		}
+	}
+
+	if best == nil {
+		tp.log.Warn("no tokens with capacity available")
+		return "", ErrOutOfCapacity
+	}
+
+	best.pending++
+
+	return best.token, nil
+}
+
+// Release gives back a token acquired with Acquire. The account
+// is refreshed on the next Acquire so droplets and projects that were
+// created are counted.
+func (tp *TokenPool) Release(token string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for _, ts := range tp.tokens {
+		if ts.token == token {
+			if ts.pending > 0 {
+				ts.pending--
+			}
+			ts.refreshedAt = time.Time{}
+			return
+		}
+	}
+}
+
+// Add adds a token to the pool.
+func (tp *TokenPool) Add(token string) error {
+	tp.mu.Lock()
+	for _, ts := range tp.tokens {
+		if ts.token == token {
+			tp.mu.Unlock()
+			return fmt.Errorf("token %s is already in the pool", tokenFingerprint(token))
+		}
+	}
+
+	ts := &tokenState{token: token}
+	ts.client = buildDoClient(token)
+	ts.client.OnRequestCompleted(tp.observer(ts))
+	tp.tokens = append(tp.tokens, ts)
+	tp.mu.Unlock()
+
+	tp.refreshToken(ts)
+
+	return nil
+}
+
+// Remove removes a token from the pool, and returns the token. Projects
+// already using the token keep it.
+func (tp *TokenPool) Remove(fingerprint string) (string, error) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for i, ts := range tp.tokens {
+		if tokenFingerprint(ts.token) == fingerprint {
+			tp.tokens = append(tp.tokens[:i], tp.tokens[i+1:]...)
+			return ts.token, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown token %s", fingerprint)
+}
+
+// Fingerprints returns the fingerprints of the tokens in the pool.
+func (tp *TokenPool) Fingerprints() []string {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	var out []string
+	for _, ts := range tp.tokens {
+		out = append(out, tokenFingerprint(ts.token))
+	}
+
+	return out
+}
+
+// Ping checks that a token can reach the DigitalOcean API.
+func (tp *TokenPool) Ping(fingerprint string) error {
+	tp.mu.Lock()
+	var client *godo.Client
+	for _, ts := range tp.tokens {
+		if tokenFingerprint(ts.token) == fingerprint {
+			client = ts.client
+		}
+	}
+	tp.mu.Unlock()
+
+	if client == nil {
+		return fmt.Errorf("unknown token %s", fingerprint)
+	}
+
+	_, _, err := client.Account.Get()
+	return err
+}
+
+// Checks returns a check for every token in the pool, named after its
+// fingerprint, so a revoked token shows up even if others still work.
+func (tp *TokenPool) Checks() map[string]func() error {
+	fingerprints := tp.Fingerprints()
+	if len(fingerprints) == 0 {
+		return map[string]func() error{
+			"digitalocean": func() error {
+				return errors.New("there are no tokens in the pool")
+			},
+		}
+	}
+
+	checks := map[string]func() error{}
+	for _, fp := range fingerprints {
+		fp := fp
+		checks["digitalocean:"+fp] = func() error {
+			return tp.Ping(fp)
+		}
+	}
+
+	return checks
+}
+
+// Capacity returns a snapshot of the capacity for all tokens.
+func (tp *TokenPool) Capacity() []TokenCapacity {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	var out []TokenCapacity
+	for _, ts := range tp.tokens {
+		out = append(out, TokenCapacity{
+			Fingerprint:   tokenFingerprint(ts.token),
+			DropletLimit:  ts.dropletLimit,
+			Droplets:      ts.droplets,
+			Pending:       ts.pending,
+			Projects:      ts.projects,
+			RateLimit:     ts.rate.Limit,
+			RateRemaining: ts.rate.Remaining,
+			Revoked:       ts.revoked,
+			Status:        ts.status,
+			RefreshedAt:   ts.refreshedAt,
+		})
+	}
+
+	return out
+}
+
+func (tp *TokenPool) observer(ts *tokenState) godo.RequestCompletionCallback {
+	return func(req *http.Request, res *http.Response) {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+
+		if res.StatusCode == http.StatusUnauthorized && !ts.revoked {
+			ts.revoked = true
+			ts.revokedAt = time.Now()
+		}
+
+		if limit := res.Header.Get(headerRateLimit); limit != "" {
+			ts.rate.Limit, _ = strconv.Atoi(limit)
+		}
+		if remaining := res.Header.Get(headerRateRemaining); remaining != "" {
+			ts.rate.Remaining, _ = strconv.Atoi(remaining)
+		}
+		if reset := res.Header.Get(headerRateReset); reset != "" {
+			if v, _ := strconv.ParseInt(reset, 10, 64); v != 0 {
+				ts.rate.Reset = godo.Timestamp{Time: time.Unix(v, 0)}
+			}
+		}
+	}
+}
+
+func isUnauthorized(err error) bool {
+	if er, ok := err.(*godo.ErrorResponse); ok && er.Response != nil {
+		return er.Response.StatusCode == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// tokenFingerprint identifies a token in logs without leaking it.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", sum[:4])
+}
@@ -0,0 +1,54 @@
+package confbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+func TestTokenStateRoomReservesProjects(t *testing.T) {
+	// two booted projects which have only created their shell droplets.
+	ts := &tokenState{
+		status:       "active",
+		dropletLimit: 3 * DropletsPerProject,
+		droplets:     2,
+		projects:     2,
+	}
+
+	if got, want := ts.room(), DropletsPerProject; got != want {
+		t.Fatalf("room() = %d, want %d", got, want)
+	}
+
+	ts.pending = 1
+	if ts.available(time.Now()) {
+		t.Fatalf("token with a project being booted in its last slot is available")
+	}
+}
+
+func TestTokenPoolAssign(t *testing.T) {
+	ctx := ContextWithLog(context.Background(), logrus.NewEntry(logrus.New()))
+	tp := NewTokenPool(ctx, []string{"a", "b"}, nil)
+
+	tp.Assign("p1", "a")
+	tp.Assign("p2", "a")
+	tp.Assign("p2", "a")
+	tp.Assign("p3", "b")
+	tp.Unassign("p3")
+
+	got := map[string]int{}
+	for _, tc := range tp.Capacity() {
+		got[tc.Fingerprint] = tc.Projects
+	}
+	if n := got[tokenFingerprint("a")]; n != 2 {
+		t.Errorf("projects using a = %d, want 2", n)
+	}
+	if n := got[tokenFingerprint("b")]; n != 0 {
+		t.Errorf("projects using b = %d, want 0", n)
+	}
+
+	if tp.Client("a") != tp.tokens[0].client {
+		t.Errorf("Client() didn't return the pool's client for a token in the pool")
+	}
+}
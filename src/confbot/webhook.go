@@ -0,0 +1,149 @@
+package confbot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookTimestampHeader is the header with the time a webhook was signed.
+	WebhookTimestampHeader = "X-Confbot-Timestamp"
+
+	// WebhookSignatureHeader is the header with the signature of a webhook.
+	WebhookSignatureHeader = "X-Confbot-Signature"
+
+	webhookSignaturePrefix = "sha256="
+)
+
+var (
+	// WebhookTolerance is how far a webhook timestamp can be from the current time.
+	WebhookTolerance = 5 * time.Minute
+
+	// ErrWebhookUnsigned is returned when a webhook has no signature.
+	ErrWebhookUnsigned = errors.New("webhook is not signed")
+	// ErrWebhookStale is returned when a webhook timestamp is outside of WebhookTolerance.
+	ErrWebhookStale = errors.New("webhook timestamp is stale")
+	// ErrWebhookSignature is returned when a webhook signature does not match.
+	ErrWebhookSignature = errors.New("webhook signature is invalid")
+	// ErrWebhookReplayed is returned when a webhook has been received before.
+	ErrWebhookReplayed = errors.New("webhook has already been received")
+)
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// SignWebhook returns the signature for a webhook body.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature and timestamp of a webhook for a project.
+// A signature is only accepted once.
+func VerifyWebhook(repo Repo, projectID, timestamp, signature string, body []byte) error {
+	if timestamp == "" || signature == "" || !strings.HasPrefix(signature, webhookSignaturePrefix) {
+		return ErrWebhookUnsigned
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookStale
+	}
+
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > WebhookTolerance || skew < -WebhookTolerance {
+		return ErrWebhookStale
+	}
+
+	secret, err := repo.WebhookSecret(projectID)
+	if err != nil {
+		return err
+	}
+
+	if secret == "" {
+		return ErrWebhookSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body))) {
+		return ErrWebhookSignature
+	}
+
+	// signatures older than the tolerance are rejected as stale, so
+	// they only have to be remembered for twice as long.
+	ok, err := repo.MarkWebhookSeen(signature, 2*WebhookTolerance)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrWebhookReplayed
+	}
+
+	return nil
+}
+
+const (
+	// Webhook event statuses.
+	WebhookQueued  = "queued"
+	WebhookRunning = "running"
+	WebhookDone    = "done"
+	WebhookFailed  = "failed"
+)
+
+var (
+	// WebhookEventTTL is how long webhook events are remembered for
+	// detecting duplicate deliveries.
+	WebhookEventTTL = 24 * time.Hour
+)
+
+// WebhookEvent is a webhook that has been accepted for processing.
+type WebhookEvent struct {
+	Key        string            `json:"key"`
+	Type       string            `json:"type"`
+	ProjectID  string            `json:"project_id"`
+	UserID     string            `json:"user_id"`
+	Options    map[string]string `json:"options"`
+	Payload    json.RawMessage   `json:"payload,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	ReceivedAt time.Time         `json:"received_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// WebhookIdempotencyKey creates a key which is the same for every delivery
+// of an event.
+func WebhookIdempotencyKey(projectID, eventType string, options map[string]string, payload []byte) string {
+	var keys []string
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", projectID, eventType)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, options[k])
+	}
+	h.Write(payload)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
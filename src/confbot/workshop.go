@@ -0,0 +1,261 @@
+package confbot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// CurrentWorkshop is the workshop the bot is running.
+	CurrentWorkshop = DefaultWorkshop()
+
+	validSlug     = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+	validHostname = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	validKeyName  = regexp.MustCompile(`^[\w.-]*$`)
+)
+
+// Workshop describes an event: the droplets attendees get, what is installed
+// on them, and what the bot tells attendees.
+type Workshop struct {
+	// Name is used in messages, e.g. "the OSCON AppOps tutorial".
+	Name string `toml:"name"`
+	// Location is where the event is held, as "latitude,longitude". It is
+	// used by the nearest region policy.
+	Location string `toml:"location"`
+
+	Droplet WorkshopDroplet `toml:"droplet"`
+
+	// InstallScriptURL is the script which installs the workshop on a
+	// shell droplet.
+	InstallScriptURL string `toml:"install_script_url"`
+	// BeatsScriptURL is the script which creates the beats dashboards.
+	BeatsScriptURL string `toml:"beats_script_url"`
+	// MasterKeys are the authorized keys for instructors. They are
+	// installed on every shell droplet.
+	MasterKeys []string `toml:"master_keys"`
+	// KeyNamePrefix is the prefix of the names of DigitalOcean SSH keys
+	// created for projects.
+	KeyNamePrefix string `toml:"key_name_prefix"`
+	// PPKFileName is the name of the PuTTY key file attendees download.
+	PPKFileName string `toml:"ppk_file_name"`
+	// JenkinsIconURL is the icon used for Jenkins notifications.
+	JenkinsIconURL string `toml:"jenkins_icon_url"`
+
+	// Services are the services attendees use, listed by ./settings.
+	Services []WorkshopService `toml:"services"`
+}
+
+// WorkshopDroplet describes the shell droplets.
+type WorkshopDroplet struct {
+	Size  string `toml:"size"`
+	Image string `toml:"image"`
+	// RegionPolicy chooses the regions shell droplets are booted in.
+	RegionPolicy string `toml:"region_policy"`
+	// Regions are the regions used by the pinned policy.
+	Regions []string `toml:"regions"`
+}
+
+// WorkshopService is a service running on a project's droplets.
+type WorkshopService struct {
+	Name string `toml:"name"`
+	// Host is the droplet the service runs on, e.g. "app".
+	Host   string `toml:"host"`
+	Port   int    `toml:"port"`
+	Scheme string `toml:"scheme"`
+}
+
+// URL returns the service's URL for a project.
+func (s *WorkshopService) URL(projectID string) string {
+	u := fmt.Sprintf("%s://%s.%s.%s", s.Scheme, s.Host, projectID, DropletDomain)
+	if s.Port != 0 {
+		u += ":" + strconv.Itoa(s.Port)
+	}
+	return u
+}
+
+// WorkshopConfigErr is returned when a workshop config is invalid.
+type WorkshopConfigErr struct {
+	Path string
+	Err  error
+}
+
+var _ error = (*WorkshopConfigErr)(nil)
+
+func (e *WorkshopConfigErr) Error() string {
+	return fmt.Sprintf("invalid workshop config %s: %v", e.Path, e.Err)
+}
+
+// DefaultWorkshop returns the OSCON 2016 AppOps tutorial, which the bot was
+// written for.
+func DefaultWorkshop() *Workshop {
+	return &Workshop{
+		Name: "OSCON AppOps tutorial",
+		Droplet: WorkshopDroplet{
+			Size:         "4gb",
+			Image:        "ubuntu-14-04-x64",
+			RegionPolicy: RegionPolicyRandom,
+		},
+		InstallScriptURL: "https://s3.pifft.com/oscon2016/install.sh",
+		BeatsScriptURL:   "https://s3.pifft.com/oscon2016/create-beats.sh",
+		KeyNamePrefix:    "oscon-",
+		PPKFileName:      "oscon2016.ppk",
+		JenkinsIconURL:   "https://s3.pifft.com/oscon2016/jenkins.png",
+		Services: []WorkshopService{
+			{Name: "Site", Host: "app", Port: 8888, Scheme: "http"},
+			{Name: "Consul", Host: "app", Scheme: "http"},
+			{Name: "Jenkins", Host: "app", Port: 8080, Scheme: "http"},
+			{Name: "Kibana", Host: "app", Port: 5601, Scheme: "http"},
+			{Name: "Grafana", Host: "app", Port: 3000, Scheme: "http"},
+			{Name: "Prometheus", Host: "app", Port: 9090, Scheme: "http"},
+		},
+	}
+}
+
+// LoadWorkshop reads a workshop from a TOML file. Settings which aren't in
+// the file keep their default value.
+func LoadWorkshop(path string) (*Workshop, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := DefaultWorkshop()
+
+	// services are decoded into the existing elements, so a service which
+	// leaves out its port or scheme would get the default service's.
+	defaultServices := w.Services
+	w.Services = nil
+
+	md, err := DecodeConfig(b, w)
+	if err != nil {
+		return nil, &WorkshopConfigErr{Path: path, Err: err}
+	}
+
+	if !md.IsDefined("services") {
+		w.Services = defaultServices
+	}
+
+	for i := range w.Services {
+		if w.Services[i].Scheme == "" {
+			w.Services[i].Scheme = "http"
+		}
+	}
+
+	if err := w.Validate(); err != nil {
+		return nil, &WorkshopConfigErr{Path: path, Err: err}
+	}
+
+	return w, nil
+}
+
+// Validate checks the workshop's settings.
+func (w *Workshop) Validate() error {
+	if strings.TrimSpace(w.Name) == "" {
+		return fieldErr("name", "is required")
+	}
+
+	if w.Location != "" {
+		if _, err := ParseLocation(w.Location); err != nil {
+			return fieldErr("location", err.Error())
+		}
+	}
+
+	if !validSlug.MatchString(w.Droplet.Size) {
+		return fieldErr("droplet.size", "must be a droplet size slug")
+	}
+	if !validSlug.MatchString(w.Droplet.Image) {
+		return fieldErr("droplet.image", "must be an image slug")
+	}
+	if _, err := NewRegionPolicy(w.Droplet.RegionPolicy, w.Droplet.Regions, w.Location); err != nil {
+		return fieldErr("droplet.region_policy", err.Error())
+	}
+	for i, r := range w.Droplet.Regions {
+		if !validSlug.MatchString(r) {
+			return fieldErr(fmt.Sprintf("droplet.regions[%d]", i), "must be a region slug")
+		}
+	}
+
+	for _, f := range []struct {
+		name, value string
+	}{
+		{"install_script_url", w.InstallScriptURL},
+		{"beats_script_url", w.BeatsScriptURL},
+		{"jenkins_icon_url", w.JenkinsIconURL},
+	} {
+		if err := validateURL(f.value); err != nil {
+			return fieldErr(f.name, err.Error())
+		}
+	}
+
+	for i, k := range w.MasterKeys {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k)); err != nil {
+			return fieldErr(fmt.Sprintf("master_keys[%d]", i), "is not an authorized key")
+		}
+	}
+
+	if w.KeyNamePrefix == "" || !validKeyName.MatchString(w.KeyNamePrefix) {
+		return fieldErr("key_name_prefix", "must be letters, digits, '.', '_' or '-'")
+	}
+	if !strings.HasSuffix(w.PPKFileName, ".ppk") || strings.ContainsAny(w.PPKFileName, `/\`) {
+		return fieldErr("ppk_file_name", "must be a file name ending in .ppk")
+	}
+
+	names := map[string]bool{}
+	for i, s := range w.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		switch {
+		case s.Name == "":
+			return fieldErr(field+".name", "is required")
+		case names[strings.ToLower(s.Name)]:
+			return fieldErr(field+".name", fmt.Sprintf("%q is used by another service", s.Name))
+		case !validHostname.MatchString(s.Host):
+			return fieldErr(field+".host", "must be a host name, e.g. app")
+		case s.Port < 0 || s.Port > 65535:
+			return fieldErr(field+".port", "must be between 1 and 65535, or left out")
+		case s.Scheme != "http" && s.Scheme != "https":
+			return fieldErr(field+".scheme", "must be http or https")
+		}
+		names[strings.ToLower(s.Name)] = true
+	}
+
+	return nil
+}
+
+// Service returns the service with a name.
+func (w *Workshop) Service(name string) (*WorkshopService, bool) {
+	for i := range w.Services {
+		if strings.EqualFold(w.Services[i].Name, name) {
+			return &w.Services[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// KeyName is the name of a project's DigitalOcean SSH key.
+func (w *Workshop) KeyName(projectID string) string {
+	return w.KeyNamePrefix + projectID
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not a http or https URL", s)
+	}
+
+	return nil
+}
+
+func fieldErr(field, reason string) error {
+	return &FieldErr{Field: field, Reason: reason}
+}
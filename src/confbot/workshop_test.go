@@ -0,0 +1,73 @@
+package confbot
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func loadTestWorkshop(t *testing.T, doc string) *Workshop {
+	f, err := ioutil.TempFile("", "workshop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(doc); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w, err := LoadWorkshop(f.Name())
+	if err != nil {
+		t.Fatalf("LoadWorkshop() = %v", err)
+	}
+
+	return w
+}
+
+func TestLoadWorkshopSparseServices(t *testing.T) {
+	w := loadTestWorkshop(t, `
+[[services]]
+name = "Docs"
+host = "docs"
+
+[[services]]
+name = "Admin"
+host = "app"
+port = 9000
+scheme = "https"
+`)
+
+	want := []WorkshopService{
+		{Name: "Docs", Host: "docs", Scheme: "http"},
+		{Name: "Admin", Host: "app", Port: 9000, Scheme: "https"},
+	}
+
+	if len(w.Services) != len(want) {
+		t.Fatalf("got %d services, want %d: %+v", len(w.Services), len(want), w.Services)
+	}
+	for i := range want {
+		if w.Services[i] != want[i] {
+			t.Errorf("services[%d] = %+v, want %+v", i, w.Services[i], want[i])
+		}
+	}
+}
+
+func TestLoadWorkshopDefaultServices(t *testing.T) {
+	w := loadTestWorkshop(t, `name = "Rehearsal"`)
+
+	if w.Name != "Rehearsal" {
+		t.Errorf("name = %q, want Rehearsal", w.Name)
+	}
+
+	defaults := DefaultWorkshop().Services
+	if len(w.Services) != len(defaults) {
+		t.Fatalf("got %d services, want the %d defaults", len(w.Services), len(defaults))
+	}
+	for i := range defaults {
+		if w.Services[i] != defaults[i] {
+			t.Errorf("services[%d] = %+v, want %+v", i, w.Services[i], defaults[i])
+		}
+	}
+}